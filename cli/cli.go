@@ -0,0 +1,1913 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/proc"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	err010 = "(tripl/010) error:%w"
+	err020 = "(tripl/020) expected command: add, delete, update, verify, list, deleteset, copyset, rename, export, import, listsets, sign, verifysig, snapshots, stats, fsck, augment, fingerprint, history, quarantine, restore, compute-pending, repair, watch, resign, diff-export, diff, savequery, listqueries, deletequery or shell"
+	err030 = "(tripl/030) command %q expects one or more filenames"
+	err040 = "(tripl/040) command %q does not accept arguments"
+	err050 = "(tripl/050) command \"copyset\" expects a single argument, the target fileset name"
+	err060 = "(tripl/060) unknown command %q"
+	err070 = "(tripl/070) command read password:%w"
+	err080 = "(tripl/080) invalid size %q"
+	err090 = "(tripl/090) command \"sign\" option --detached requires --out"
+	err095 = "(tripl/095) command \"augment\" requires --add"
+	err100 = "(tripl/100) invalid database mode %q"
+	err105 = "(tripl/105) database mode %q is world writable, use --db-force-mode"
+	err110 = "(tripl/110) database owner %q:%w"
+	err120 = "(tripl/120) export failures %q:%w"
+	err130 = "(tripl/130) command \"quarantine\" requires --out"
+	err170 = "(tripl/170) command \"restore\" requires --from and --sig"
+	err180 = "(tripl/180) invalid permission bits %q"
+	err190 = "(tripl/190) write badge %q:%w"
+	err200 = "(tripl/200) open baseline %q:%w"
+	err210 = "(tripl/210) write csv %q:%w"
+	err220 = "(tripl/220) several filesets cannot be combined with --baseline, --history or --baseline-hash"
+	err230 = "(tripl/230) run follow-up command %q:%w"
+	err240 = "(tripl/240) command %q requires a query --name"
+	err250 = "(tripl/250) write prometheus metrics %q:%w"
+	err260 = "(tripl/260) command %q expects two filesets, A and B"
+	err270 = "(tripl/270) unknown report format %q, expected text or json"
+	err280 = "(tripl/280) command \"rename\" expects a single argument, the new fileset name"
+	err290 = "(tripl/290) write export %q:%w"
+	err300 = "(tripl/300) read export %q:%w"
+	err310 = "(tripl/310) command %q expects two export files, OLD and NEW"
+	err320 = "(tripl/320) --baseline cannot be combined with %s"
+	err330 = "(tripl/330) command \"verify\" option --baseline-signed requires --baseline"
+)
+
+const (
+	msg010 = "%d failed checks"
+	msg020 = "0 failed checks"
+	msg030 = "%d problems"
+	msg040 = "0 problems"
+	msg050 = "interrupted, stopping at the next file"
+	msg060 = "not running as root, database owner %q ignored"
+	msg090 = "%d pending checks not verified, run compute-pending"
+	msg100 = "%d buckets, %d records copied to %q, review and replace %q with it"
+	msg110 = "bucket %q lost or partially recovered"
+	msg120 = "check cache: %d hits, %d misses"
+	msg130 = "running %q"
+	msg140 = "%d %s checks skipped, not available on this platform"
+	msg150 = "Integrity fileset %q is ok."
+	msg160 = "%d checks not compared, the recorded data is incomplete"
+)
+
+// Exit code after an interrupt, the shell convention 128 + SIGINT.
+const ExitInterrupted = 130
+
+// Type runner holds the input and output of a single Run, the commands do not touch the process wide state.
+// Stdin provides the file names, the imports, the passwords and the shell commands. The output of the command, the
+// log and the json results go to stdout, the events, the progress and the prompts go to stderr.
+type runner struct {
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	// The messages of the command, without timestamps. It writes to stderr when the json output reserves stdout.
+	log *log.Logger
+	// Set when the context of the run is canceled, the running operation stops at the next file.
+	interrupt *proc.Interrupt
+	// The context of the run, it also ends the wait for the database lock.
+	ctx context.Context
+}
+
+// Run a tripline command line, the arguments without the executable name. Returns the exit code of the command,
+// the input is read from stdin and the output is written to stdout and stderr. The database of the global options
+// is opened if tripDb is nil, otherwise the command works on tripDb and it is left open, e.g. to run several
+// commands against a database of a test.
+// Canceling the context interrupts the command, e.g. on a signal. The running operation stops at the next file and
+// its transaction is rolled back.
+func Run(ctx context.Context, args []string, tripDb *db.TriplineDb, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	r := &runner{stdin: stdin, stdout: stdout, stderr: stderr, log: log.New(stdout, "", 0), interrupt: &proc.Interrupt{}, ctx: ctx}
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			r.log.Println(msg050)
+			r.interrupt.Set()
+		case <-done:
+		}
+	}()
+	code, err := r.run(args, tripDb)
+	close(done)
+	if err != nil {
+		// The error ends the command like log.Fatal, after the rollbacks and the close of the database.
+		r.log.Println(err)
+		code = 1
+		if errors.Is(err, proc.ErrInterrupted) || errors.Is(err, context.Canceled) {
+			code = ExitInterrupted
+		}
+	}
+	return code
+}
+
+// Parse the global options, open the database and run the command. Returns the exit code of the command, it is
+// ignored if there is an error.
+func (r *runner) run(args []string, tripDb *db.TriplineDb) (code int, err error) {
+	// Define command line args
+	addFlags := flag.NewFlagSet("add", flag.ContinueOnError)
+	addFileset := addFlags.String("fileset", "default", "Fileset where files are added. Created if not present.")
+	recursive := addFlags.Bool("recursive", true, "Add directories recursively.")
+	overwrite := addFlags.Bool("overwrite", false, "Overwrite existing data if already in the database. Also see --skip.")
+	filechecks := addFlags.String("filechecks", "size,modtime,ownership,permissions,sha256", "File checks.")
+	dirchecks := addFlags.String("dirchecks", "child,modtime,ownership,permissions", "Directory checks.")
+	overwriteIfChanged := addFlags.Bool("overwrite-if-changed", false, "Only overwrite existing data if it changed. Also see --overwrite.")
+	skip := addFlags.Bool("skip", false, "Ignore files if already in the database. Also see --overwrite")
+	maxFileSize := addFlags.String("max-filesize", "", "Skip the content checks of files larger than this size, e.g. 100MB.")
+	addExcludes := &stringList{}
+	addFlags.Var(addExcludes, "exclude", "Do not add the files and directories matching this glob, e.g. node_modules or '*.tmp'. Matched directories are not descended into. Repeatable.")
+	addJobs := addFlags.Int("jobs", runtime.NumCPU(), "Number of workers that prepare the checks of the files, e.g. the content hashes. 1 to prepare the files in turn.")
+	contentLimit := addFlags.String("content-limit", "", "Largest file whose contents are stored by the content check, e.g. 1MB. Default 64KB.")
+	inlineUnder := addFlags.String("inline-under", "", "Store the contents of files smaller than this size, a failed verification shows a diff, e.g. 64KB.")
+	excludeLarger := addFlags.String("exclude-larger-than", "", "Do not add files larger than this size, e.g. 1MB.")
+	excludeSmaller := addFlags.String("exclude-smaller-than", "", "Do not add files smaller than this size, e.g. 1KB.")
+	deferContent := addFlags.Bool("defer-content", false, "Record the content checks as pending, compute them later with compute-pending.")
+	regularOnly := addFlags.Bool("regular-only", false, "Skip device nodes, fifos and sockets, only add regular files and directories.")
+	modTimePrecision := addFlags.String("modtime-precision", "nanosecond", "Resolution of the recorded modification times: second, millisecond or nanosecond.")
+	addEvents := addFlags.Bool("events", false, "Write progress events as newline delimited json to stderr.")
+	addProgress := addFlags.Bool("progress", false, "Count the files first and show the progress on stderr, the events include the total.")
+	addEstimate := addFlags.Bool("estimate", false, "Only count the files, directories and bytes that would be added, nothing is added.")
+	fromStdin := addFlags.Bool("from-stdin", false, "Read the files to add from stdin, one per line.")
+	fromStdinNull := addFlags.Bool("null", false, "The files on stdin are terminated by a null character instead of a newline.")
+	resolve := addFlags.Bool("resolve", false, "Resolve the symbolic links in the paths before recording them.")
+	followSymlinks := addFlags.Bool("follow-symlinks", false, "Follow the symbolic links and descend into linked directories, the cycles are broken. Otherwise the links are recorded as links.")
+	selfOwner := addFlags.Bool("self-owner", false, "Record the ownership of the files of the current user as $SELF, it matches the verifying user.")
+	homeRelative := addFlags.Bool("home-relative", false, "Store paths in the home directory as ~/... so the fileset can be verified by other users.")
+	addTreeRoot := addFlags.Bool("record-hash-tree-root", false, "Maintain the root of a hash tree of the records, it is updated with each modification. See fingerprint.")
+	addSignTreeRoot := addFlags.Bool("sign-tree-root", false, "Sign the tree root after the add, asks for the password. Implies --record-hash-tree-root.")
+	hashPaths := addFlags.Bool("hash-paths", false, "Key the records of a new fileset by a keyed hash of the paths, asks for the path key. The paths are stored encrypted.")
+
+	deleteFlags := flag.NewFlagSet("delete", flag.ContinueOnError)
+	deleteFileset := deleteFlags.String("fileset", "default", "Fileset where files will be deleted.")
+	deleteStrict := deleteFlags.Bool("strict", false, "Fail if a file has no records in the fileset, nothing is deleted.")
+
+	verifyFlags := flag.NewFlagSet("verify", flag.ContinueOnError)
+	verifyFileset := verifyFlags.String("fileset", "default", "Fileset containing the checks, a comma separated list to verify several filesets.")
+	verifyBucketCache := verifyFlags.Bool("bucket-cache", false, "Check the paths that are recorded in several of the filesets once.")
+	verifyFormat := verifyFlags.String("format", "text", "Format of the report: text or json. The json report goes to stdout, the other output to stderr.")
+	verifyEvents := verifyFlags.Bool("events", false, "Write progress and result events as newline delimited json to stderr.")
+	verifyMaps := &stringList{}
+	verifyFlags.Var(verifyMaps, "map", "Translate recorded paths FROM=TO before verification, e.g. /mnt/share=S:\\. Repeatable.")
+	verifyMinSeverity := verifyFlags.String("min-severity-exit", proc.SeverityWarning, "Only exit with a non-zero code for failures with this severity or higher: warning or critical.")
+	verifyRequireChecks := verifyFlags.String("require-checks", "", "Comma separated list of checks each record should have, e.g. size,sha256.")
+	verifyBaselineHash := verifyFlags.String("baseline-hash", "", "Abort if the fingerprint of the fileset differs from this one, see the fingerprint command.")
+	verifyHistory := verifyFlags.Bool("history", false, "Append the failure counts per check to the verification history of the fileset.")
+	verifyExportFailures := verifyFlags.String("export-failures", "", "Write the paths with failed checks to this file, one per line.")
+	verifyExportNull := verifyFlags.Bool("null", false, "Terminate the exported paths with a null character instead of a newline.")
+	verifyUidMaps := &stringList{}
+	verifyFlags.Var(verifyUidMaps, "uid-map", "Translate recorded user ids FROM:TO[:COUNT] before the ownership check, e.g. 100000:0:65536. Repeatable.")
+	verifyGidMaps := &stringList{}
+	verifyFlags.Var(verifyGidMaps, "gid-map", "Translate recorded group ids FROM:TO[:COUNT] before the ownership check. Repeatable.")
+	verifyExistenceOnly := verifyFlags.Bool("existence-only", false, "Only check that the recorded files still exist with the same type, skip the recorded checks.")
+	verifyThreadsIO := verifyFlags.Int("threads-io", 0, "Threads reading the file contents for the content checks, 0 to read and hash each file in turn.")
+	verifyThreadsCPU := verifyFlags.Int("threads-cpu", 0, "Threads hashing the file contents, 0 to read and hash each file in turn.")
+	verifyRetry := verifyFlags.Int("retry", 0, "Retry the stat and the open of a file this many times after a transient error, e.g. EIO or ESTALE on a network filesystem.")
+	verifyRetryDelay := verifyFlags.Duration("retry-delay", 100*time.Millisecond, "Delay before the first retry, it doubles on each following retry.")
+	verifyClosedWorld := verifyFlags.Bool("closed-world", false, "Report the files below the recorded directories that are not recorded.")
+	verifyPermissionsMask := verifyFlags.String("permissions-mask", "", "Only compare these permission bits, octal, e.g. 0777 to ignore setuid, setgid and sticky.")
+	verifyPermissionsIgnore := verifyFlags.String("permissions-ignore", "", "Ignore these permission bits, octal, e.g. 0020 for the group write bit.")
+	verifyCSV := verifyFlags.String("csv", "", "Write a row per check result to this csv file: fileset,path,check,status,expected,actual,severity.")
+	verifyPrometheus := verifyFlags.String("prometheus", "", "Write the metrics per fileset and check to this file in the Prometheus text format, e.g. for the node exporter textfile collector.")
+	verifyBadge := verifyFlags.String("badge", "", "Write the outcome as a shields.io endpoint badge to this file.")
+	verifyBadgeStaleDays := verifyFlags.Int("badge-stale-days", 90, "A clean badge turns yellow when the baseline was not updated for this number of days, 0 to disable.")
+	verifyWhere := &stringList{}
+	verifyFlags.Var(verifyWhere, "where", "Only verify the records with this recorded value CHECK=VALUE, e.g. sha256=abcd.... Repeatable, the clauses are combined.")
+	verifyDbs := &stringList{}
+	verifyFlags.Var(verifyDbs, "baseline", "Verify against this baseline database instead of the default one, opened read only. Repeatable, see --quorum. Not named --db, that is the global database option.")
+	verifyQuorumSize := verifyFlags.Int("quorum", 0, "Number of --baseline databases that should agree on the hash of a file. Default a majority.")
+	verifyBaselineSigned := verifyFlags.Bool("baseline-signed", false, "Verify the signature of the fileset in each --baseline database before the files, the password is prompted.")
+	verifySnapshot := verifyFlags.String("snapshot", "", "Verify against a temporary read only snapshot TYPE:PATH of the live tree, e.g. btrfs:/srv. Types: btrfs, zfs.")
+	verifySince := verifyFlags.String("since-signature", "", "Only verify the records that changed since the signature snapshot with this timestamp, or \"latest\".")
+	verifyQuery := verifyFlags.String("query", "", "Verify the records and checks selected by this saved query of the fileset, see savequery.")
+	verifyOnSuccess := verifyFlags.String("on-success", "", "Run this shell command when the verification passes, its exit code becomes the exit code of tripline.")
+	verifyOnFailure := verifyFlags.String("on-failure", "", "Run this shell command when the verification fails, its exit code becomes the exit code of tripline.")
+
+	listFlags := flag.NewFlagSet("list", flag.ContinueOnError)
+	listFileset := listFlags.String("fileset", "default", "Fileset for which contents is listed.")
+	listLong := listFlags.Bool("l", true, "List the paths with the recorded data.")
+	listOne := listFlags.Bool("1", false, "Only list the paths.")
+	listBySize := listFlags.Bool("S", false, "Sort by recorded size, largest first.")
+	listByTime := listFlags.Bool("t", false, "Sort by recorded modification time, newest first.")
+	listJSON := listFlags.Bool("json", false, "List the records as a json array, the export format.")
+	listPretty := listFlags.Bool("pretty", false, "Indent the json.")
+
+	deleteSetFlags := flag.NewFlagSet("deleteset", flag.ContinueOnError)
+	deleteSetFileset := deleteSetFlags.String("fileset", "default", "Fileset to delete.")
+
+	copySetFlags := flag.NewFlagSet("copyset", flag.ContinueOnError)
+	copyFileset := copySetFlags.String("fileset", "default", "Fileset to copy.")
+
+	renameFlags := flag.NewFlagSet("rename", flag.ContinueOnError)
+	renameFileset := renameFlags.String("fileset", "default", "Fileset to rename.")
+
+	exportFlags := flag.NewFlagSet("export", flag.ContinueOnError)
+	exportFileset := exportFlags.String("fileset", "default", "Fileset to export.")
+	exportOut := exportFlags.String("out", "", "Export file, default stdout.")
+
+	importFlags := flag.NewFlagSet("import", flag.ContinueOnError)
+	importFileset := importFlags.String("fileset", "default", "Fileset where the records are imported. Created if not present.")
+	importFrom := importFlags.String("from", "", "Export file, default stdin.")
+	importOverwrite := importFlags.Bool("overwrite", false, "Import into a fileset with records, the imported records replace the records with the same path.")
+
+	signFlags := flag.NewFlagSet("sign/verifysig", flag.ContinueOnError)
+	signFileset := signFlags.String("fileset", "default", "Fileset to copy.")
+	signOverwrite := signFlags.Bool("overwrite", false, "Overwrite existing signature.")
+	signOut := signFlags.String("out", "", "Sign: also write the signature to this file.")
+	signDetached := signFlags.Bool("detached", false, "Sign: only write the signature to the --out file, do not store it.")
+	signSig := signFlags.String("sig", "", "Verifysig: verify against this signature file instead of the stored signature.")
+	signJSON := signFlags.Bool("json", false, "Write the outcome as json to stdout.")
+	signDebug := signFlags.Bool("debug", false, "Log the fileset hash and the new signature.")
+
+	snapshotsFlags := flag.NewFlagSet("snapshots", flag.ContinueOnError)
+	snapshotsFileset := snapshotsFlags.String("fileset", "default", "Fileset for which the signature snapshots are listed.")
+
+	statsFlags := flag.NewFlagSet("stats", flag.ContinueOnError)
+	statsFileset := statsFlags.String("fileset", "default", "Fileset to report on.")
+	statsChanged := statsFlags.Bool("changed", false, "Quick scan of the size and modification time to count the entries that probably changed.")
+	statsTop := statsFlags.Int("top", 0, "Number of changed paths to list.")
+	statsStorage := statsFlags.Bool("storage", false, "Report the storage usage and the savings of the compression.")
+
+	fsckFlags := flag.NewFlagSet("fsck", flag.ContinueOnError)
+	fsckFileset := fsckFlags.String("fileset", "default", "Fileset to check.")
+	fsckRoot := fsckFlags.String("root", "", "Report the records outside of this directory.")
+
+	updateFlags := flag.NewFlagSet("update", flag.ContinueOnError)
+	updateFileset := updateFlags.String("fileset", "default", "Fileset where the records are updated.")
+
+	augmentFlags := flag.NewFlagSet("augment", flag.ContinueOnError)
+	augmentFileset := augmentFlags.String("fileset", "default", "Fileset to augment.")
+	augmentChecks := augmentFlags.String("add", "", "Comma separated list of checks to add to the records that lack them.")
+
+	fingerprintFlags := flag.NewFlagSet("fingerprint", flag.ContinueOnError)
+	fingerprintFileset := fingerprintFlags.String("fileset", "default", "Fileset to fingerprint.")
+	fingerprintVerifyTreeRoot := fingerprintFlags.Bool("verify-tree-root", false, "Check the signature of the tree root against the current records, asks for the password.")
+
+	saveQueryFlags := flag.NewFlagSet("savequery", flag.ContinueOnError)
+	saveQueryFileset := saveQueryFlags.String("fileset", "default", "Fileset of the query.")
+	saveQueryName := saveQueryFlags.String("name", "", "Name of the query, an existing query is replaced.")
+	saveQueryIncludes := &stringList{}
+	saveQueryFlags.Var(saveQueryIncludes, "include", "Only select the paths matching this glob, e.g. *.conf. Repeatable.")
+	saveQueryExcludes := &stringList{}
+	saveQueryFlags.Var(saveQueryExcludes, "exclude", "Skip the paths matching this glob. Repeatable.")
+	saveQueryChecks := saveQueryFlags.String("checks", "", "Comma separated list of the recorded checks to run, default all.")
+
+	listQueriesFlags := flag.NewFlagSet("listqueries", flag.ContinueOnError)
+	listQueriesFileset := listQueriesFlags.String("fileset", "default", "Fileset of the queries.")
+
+	deleteQueryFlags := flag.NewFlagSet("deletequery", flag.ContinueOnError)
+	deleteQueryFileset := deleteQueryFlags.String("fileset", "default", "Fileset of the query.")
+	deleteQueryName := deleteQueryFlags.String("name", "", "Name of the query.")
+
+	historyFlags := flag.NewFlagSet("history", flag.ContinueOnError)
+	historyFileset := historyFlags.String("fileset", "default", "Fileset of the verification history.")
+	historyJSON := historyFlags.Bool("json", false, "Print each verification as json.")
+
+	globalFlags := flag.NewFlagSet("tripline", flag.ContinueOnError)
+	dbFile := globalFlags.String("db", "", "Database file. Default $TRIPLINE_DB or ~/.tripline.")
+	dbMode := globalFlags.String("db-mode", "0600", "File mode of a new database, octal.")
+	dbForceMode := globalFlags.Bool("db-force-mode", false, "Accept a world writable database mode.")
+	dbOwner := globalFlags.String("db-owner", "", "Owner USER[:GROUP] of a new database, only when running as root.")
+
+	namespace := globalFlags.String("namespace", "", "Namespace of the filesets, to keep independent projects apart in one database.")
+	reservedPrefix := globalFlags.String("reserved-prefix", "_", "Prefix of the reserved bucket names, e.g. _signatures. It is recorded in a new database, another prefix is refused.")
+	checkSignatures := globalFlags.Bool("check-signatures-on-open", false, "Verify all signed filesets before running the command, asks for the password.")
+	lockWait := globalFlags.Duration("lock-wait", 0, "How long to wait for another tripline process that uses the database, e.g. 10s. Default until it is released.")
+	compressThreshold := globalFlags.String("compress-threshold", "", "Store the records of at least this size compressed, e.g. 512B. Default no compression.")
+	ioBufferSize := globalFlags.String("io-buffer-size", "32KB", "Size of the buffer used to read the file contents, e.g. 1MB.")
+	pathKey := globalFlags.Bool("path-key", false, "Ask for the path key of the filesets with hashed paths, see add --hash-paths.")
+
+	quarantineFlags := flag.NewFlagSet("quarantine", flag.ContinueOnError)
+	quarantineFileset := quarantineFlags.String("fileset", "default", "Fileset to verify.")
+	quarantineOut := quarantineFlags.String("out", "", "Quarantine directory for the copies of the files that failed a content check.")
+
+	computePendingFlags := flag.NewFlagSet("compute-pending", flag.ContinueOnError)
+	computePendingFileset := computePendingFlags.String("fileset", "default", "Fileset with pending checks.")
+
+	resignFlags := flag.NewFlagSet("resign", flag.ContinueOnError)
+	resignFileset := resignFlags.String("fileset", "default", "Fileset to re-sign with the current hash version.")
+	resignAll := resignFlags.Bool("all", false, "Re-sign all the signed filesets.")
+
+	watchFlags := flag.NewFlagSet("watch", flag.ContinueOnError)
+	watchFileset := watchFlags.String("fileset", "default", "Fileset to watch.")
+	watchCheckInterval := watchFlags.Duration("check-interval", time.Hour, "Interval of the full verification that catches the missed events, e.g. 30m, 0 to only verify on events.")
+	watchEvents := watchFlags.Bool("events", false, "Write the result events as newline delimited json to stderr.")
+
+	diffExportFlags := flag.NewFlagSet("diff-export", flag.ContinueOnError)
+	diffExportJSON := diffExportFlags.Bool("json", false, "Write the changes as a json array to stdout.")
+
+	diffFlags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	diffJSON := diffFlags.Bool("json", false, "Write the changes as a json array to stdout.")
+
+	repairFlags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	repairOut := repairFlags.String("out", "", "Repaired database, default the database path with the .repaired suffix.")
+
+	restoreFlags := flag.NewFlagSet("restore", flag.ContinueOnError)
+	restoreFileset := restoreFlags.String("fileset", "default", "Fileset to restore, the current records are replaced.")
+	restoreFrom := restoreFlags.String("from", "", "Trusted export of the fileset.")
+	restoreSig := restoreFlags.String("sig", "", "Detached signature of the fileset, see sign --detached.")
+
+	flagSets := []*flag.FlagSet{globalFlags, addFlags, deleteFlags, verifyFlags, listFlags, deleteSetFlags, copySetFlags, renameFlags, exportFlags, importFlags, signFlags, snapshotsFlags, statsFlags, fsckFlags, updateFlags, augmentFlags, fingerprintFlags, historyFlags, quarantineFlags, restoreFlags, computePendingFlags, repairFlags, watchFlags, resignFlags, diffExportFlags, diffFlags, saveQueryFlags, listQueriesFlags, deleteQueryFlags}
+	for _, set := range flagSets {
+		set.SetOutput(r.stderr)
+	}
+	// 0 = executable name
+	// 1 ... the global options
+	// then the command
+	// and the arguments of the command
+	err = globalFlags.Parse(args)
+	if err != nil {
+		return parseErrorCode(err), nil
+	}
+	if globalFlags.NArg() < 1 {
+		r.printManual(flagSets)
+		return 1, nil
+	}
+	cmd := globalFlags.Arg(0)
+	cmdArgs := globalFlags.Args()[1:]
+
+	if cmd == "diff-export" {
+		// The exports are compared without a database, the database path and
+		// the secrets are not resolved.
+		err := diffExportFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if diffExportFlags.NArg() != 2 {
+			return 0, fmt.Errorf(err310, cmd)
+		}
+		changes, err := proc.DiffExports(diffExportFlags.Arg(0), diffExportFlags.Arg(1))
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if *diffExportJSON {
+			err = r.writeJSON(changes)
+		} else {
+			err = proc.WriteDiff(r.log.Writer(), changes)
+		}
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if len(changes) > 0 {
+			// Differences fail the command, e.g. to gate a change of the baselines.
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	mode, err := parseDbMode(*dbMode, *dbForceMode)
+	if err != nil {
+		return 0, err
+	}
+	bufferSize, err := parseSize(*ioBufferSize)
+	if err != nil {
+		return 0, err
+	}
+	err = proc.CheckIOBufferSize(bufferSize)
+	if err != nil {
+		return 0, err
+	}
+	readOpts := proc.ReadOptions{IOBufferSize: int(bufferSize), Log: r.log, Interrupt: r.interrupt}
+	threshold, err := parseSize(*compressThreshold)
+	if err != nil {
+		return 0, err
+	}
+	dbPath, err := resolveDbPath(*dbFile)
+	if err != nil {
+		return 0, wrap(err)
+	}
+	_, err = os.Stat(dbPath)
+	created := os.IsNotExist(err)
+	dbOpts := &db.OpenOptions{
+		// The readers share the database, a new database is created by any command.
+		ReadOnly:          !created && readsOnly(cmd, cmdArgs),
+		Mode:              mode,
+		ReservedPrefix:    *reservedPrefix,
+		LockWait:          *lockWait,
+		Context:           r.ctx,
+		LeaseCommand:      cmd,
+		CompressThreshold: int(threshold),
+		Logger:            r.log,
+	}
+	pathSecret := ""
+	if *pathKey {
+		pathSecret, err = r.readSecretPrompt("Enter Path Key: ")
+		if err != nil {
+			return 0, wrap(err)
+		}
+	}
+
+	if cmd == "repair" {
+		// A damaged database cannot be opened, the repair works on the file.
+		err := repairFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if repairFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		out := *repairOut
+		if len(out) == 0 {
+			out = dbPath + ".repaired"
+		}
+		result, err := db.Repair(dbPath, out)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		for _, lost := range result.Lost {
+			r.log.Printf(msg110, lost)
+		}
+		r.log.Printf(msg100, result.Buckets, result.Keys, out, dbPath)
+		return 0, nil
+	}
+
+	// Release the database before the command ends, a database of the caller is left open.
+	closeDb := func() error { return nil }
+	if tripDb == nil {
+		// Open the database + make sure it will be closed.
+		tripDb, err = db.OpenTriplineDbWith(dbPath, dbOpts)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		closed := false
+		closeDb = func() error {
+			if closed {
+				return nil
+			}
+			closed = true
+			return tripDb.Close()
+		}
+		defer func() {
+			// A failing close fails the command, unless it already failed.
+			if closeErr := closeDb(); closeErr != nil && err == nil {
+				err = wrap(closeErr)
+			}
+		}()
+		if created && len(*dbOwner) > 0 {
+			if err := r.chownDb(dbPath, *dbOwner); err != nil {
+				return 0, wrap(err)
+			}
+		}
+	} else if err := tripDb.CheckReservedPrefix(*reservedPrefix); err != nil {
+		return 0, wrap(err)
+	}
+	if *pathKey {
+		tripDb.SetPathKey(pathSecret)
+	}
+	if err := tripDb.SetNamespace(*namespace); err != nil {
+		return 0, wrap(err)
+	}
+	if *checkSignatures {
+		// Refuse to work with a database that was tampered with.
+		pwd, err := r.readSecret()
+		if err != nil {
+			return 0, fmt.Errorf(err070, err)
+		}
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		err = rollback(proc.CheckSignatures(pwd, r.log, tripDb), tripDb)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	switch cmd {
+	case "add":
+		// Parse the arguments
+		err := addFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		if *hashPaths && !*pathKey {
+			key, err := r.readSecretPrompt("Enter Path Key: ")
+			if err != nil {
+				return 0, wrap(err)
+			}
+			tripDb.SetPathKey(key)
+		}
+		fileNames := addFlags.Args()
+		if *fromStdin {
+			stdinNames, err := readFileNames(r.stdin, *fromStdinNull)
+			if err != nil {
+				return 0, wrap(err)
+			}
+			fileNames = append(fileNames, stdinNames...)
+		}
+		// Arity check
+		if len(fileNames) <= 0 {
+			return 0, fmt.Errorf(err030, cmd)
+		}
+		maxSize, err := parseSize(*maxFileSize)
+		if err != nil {
+			return 0, err
+		}
+		contentSize, err := parseSize(*contentLimit)
+		if err != nil {
+			return 0, err
+		}
+		inlineSize, err := parseSize(*inlineUnder)
+		if err != nil {
+			return 0, err
+		}
+		largerThan, err := parseSize(*excludeLarger)
+		if err != nil {
+			return 0, err
+		}
+		smallerThan, err := parseSize(*excludeSmaller)
+		if err != nil {
+			return 0, err
+		}
+		opts := &proc.AddOptions{
+			Recursive:          *recursive,
+			Overwrite:          *overwrite,
+			Skip:               *skip,
+			FileChecks:         *filechecks,
+			DirChecks:          *dirchecks,
+			HomeRelative:       *homeRelative,
+			Resolve:            *resolve,
+			FollowSymlinks:     *followSymlinks,
+			HashPaths:          *hashPaths,
+			TreeRoot:           *addTreeRoot || *addSignTreeRoot,
+			MaxFileSize:        maxSize,
+			Jobs:               *addJobs,
+			ContentLimit:       contentSize,
+			InlineUnder:        inlineSize,
+			Exclude:            *addExcludes,
+			ExcludeLargerThan:  largerThan,
+			ExcludeSmallerThan: smallerThan,
+			RegularOnly:        *regularOnly,
+			DeferContent:       *deferContent,
+			SelfOwner:          *selfOwner,
+			OverwriteIfChanged: *overwriteIfChanged,
+			ModTimePrecision:   *modTimePrecision,
+			Progress:           *addProgress,
+			ReadOptions:        readOpts,
+		}
+		if *addEstimate {
+			if err := proc.LogAddEstimate(fileNames, opts); err != nil {
+				return 0, wrap(err)
+			}
+			break
+		}
+		if *addEvents {
+			opts.Events = r.writeEvent
+		} else if *addProgress {
+			opts.Events = r.newProgressWriter()
+			// End the progress line.
+			defer fmt.Fprintln(r.stderr)
+		}
+		var pwd string
+		if *addSignTreeRoot {
+			pwd, err = r.readSecret()
+			if err != nil {
+				return 0, fmt.Errorf(err070, err)
+			}
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		err = proc.AddFiles(fileNames, *addFileset, opts, tripDb)
+		if err == nil && *addSignTreeRoot {
+			err = proc.SignTreeRoot(*addFileset, pwd, r.log, tripDb)
+		}
+		return 0, commitOrRollback(err, tripDb)
+	case "delete":
+		// Parse the arguments
+		err := deleteFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if deleteFlags.NArg() <= 0 {
+			return 0, fmt.Errorf(err030, cmd)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.DeleteFiles(deleteFlags.Args(), *deleteFileset, *deleteStrict, r.log, tripDb), tripDb)
+	case "update":
+		// Parse the arguments
+		err := updateFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if updateFlags.NArg() <= 0 {
+			return 0, fmt.Errorf(err030, cmd)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.UpdateFiles(updateFlags.Args(), *updateFileset, &readOpts, tripDb), tripDb)
+	case "verify":
+		// Parse arguments
+		err := verifyFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		minSeverity, err := proc.ParseSeverity(*verifyMinSeverity)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if err := proc.CheckRetries(*verifyRetry); err != nil {
+			return 0, wrap(err)
+		}
+		switch *verifyFormat {
+		case "text":
+		case "json":
+			// Stdout is reserved for the json report.
+			r.log.SetOutput(r.stderr)
+		default:
+			return 0, fmt.Errorf(err270, *verifyFormat)
+		}
+		opts := &proc.VerifyOptions{
+			SinceSignature: *verifySince,
+			BaselineHash:   *verifyBaselineHash,
+			ClosedWorld:    *verifyClosedWorld,
+			ExistenceOnly:  *verifyExistenceOnly,
+			Query:          *verifyQuery,
+			IOThreads:      *verifyThreadsIO,
+			CPUThreads:     *verifyThreadsCPU,
+			ReadOptions:    readOpts,
+		}
+		opts.Retries = *verifyRetry
+		opts.RetryDelay = *verifyRetryDelay
+		if *verifyEvents {
+			opts.Events = r.writeEvent
+		}
+		for _, mapping := range *verifyMaps {
+			pathMap, err := proc.ParsePathMap(mapping)
+			if err != nil {
+				return 0, err
+			}
+			opts.PathMaps = append(opts.PathMaps, pathMap)
+		}
+		for _, clause := range *verifyWhere {
+			where, err := proc.ParseWhere(clause)
+			if err != nil {
+				return 0, wrap(err)
+			}
+			opts.Where = append(opts.Where, where)
+		}
+		opts.UidMaps, err = parseIdMaps(*verifyUidMaps)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		opts.GidMaps, err = parseIdMaps(*verifyGidMaps)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		opts.IgnorePermissions, err = parseIgnoredPermissions(*verifyPermissionsMask, *verifyPermissionsIgnore)
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if len(*verifyRequireChecks) > 0 {
+			opts.RequireChecks, err = proc.ParseChecks(*verifyRequireChecks)
+			if err != nil {
+				return 0, wrap(err)
+			}
+		}
+		filesets := strings.Split(*verifyFileset, ",")
+		if len(filesets) > 1 && (len(*verifyDbs) > 0 || *verifyHistory || len(*verifyBaselineHash) > 0) {
+			return 0, errors.New(err220)
+		}
+		if len(*verifyDbs) > 0 {
+			// The baselines only vote on the recorded sha256 hashes, the options that select the records or the
+			// checks, or that use the default database, do not apply.
+			unsupported := []struct {
+				option string
+				set    bool
+			}{
+				{"--baseline-hash", len(*verifyBaselineHash) > 0},
+				{"--require-checks", len(*verifyRequireChecks) > 0},
+				{"--query", len(*verifyQuery) > 0},
+				{"--where", len(*verifyWhere) > 0},
+				{"--since-signature", len(*verifySince) > 0},
+				{"--history", *verifyHistory},
+				{"--snapshot", len(*verifySnapshot) > 0},
+				{"--closed-world", *verifyClosedWorld},
+				{"--existence-only", *verifyExistenceOnly},
+				{"file arguments", verifyFlags.NArg() > 0},
+			}
+			for _, option := range unsupported {
+				if option.set {
+					return 0, fmt.Errorf(err320, option.option)
+				}
+			}
+		} else if *verifyBaselineSigned {
+			return 0, errors.New(err330)
+		}
+		if *verifyBucketCache {
+			opts.Cache = proc.NewVerifyCache()
+		}
+		var report *proc.VerifyReport
+		if len(*verifyDbs) > 0 {
+			// The independent baselines replace the default database.
+			var pwd *string
+			if *verifyBaselineSigned {
+				secret, err := r.readSecret()
+				if err != nil {
+					return 0, fmt.Errorf(err070, err)
+				}
+				pwd = &secret
+			}
+			report, err = verifyQuorum(*verifyDbs, dbOpts, *verifyQuorumSize, *verifyFileset, *namespace, pwd, opts)
+			if err != nil {
+				return 0, wrap(err)
+			}
+		} else {
+			var snapshot *proc.Snapshot
+			if len(*verifySnapshot) > 0 {
+				snapshot, err = proc.CreateSnapshot(*verifySnapshot, r.log)
+				if err != nil {
+					return 0, wrap(err)
+				}
+				// The snapshot takes precedence over the other mappings.
+				opts.PathMaps = append([]proc.PathMap{snapshot.PathMap()}, opts.PathMaps...)
+			}
+			// Start read transaction, recording the history requires a writable one.
+			if err := tripDb.Begin(*verifyHistory); err != nil {
+				return 0, wrap(err)
+			}
+			report, err = verifyFilesets(verifyFlags.Args(), filesets, opts, tripDb)
+			if snapshot != nil {
+				// Clean up before any of the exits below.
+				if removeErr := snapshot.Remove(); removeErr != nil {
+					r.log.Println(removeErr)
+				}
+			}
+			if *verifyHistory {
+				if err == nil {
+					err = proc.RecordVerifyRun(*verifyFileset, report, tripDb)
+				}
+				err = commitOrRollback(err, tripDb)
+			} else {
+				err = rollback(err, tripDb)
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		if *verifyFormat == "json" {
+			err = report.WriteJSON(r.stdout)
+		} else {
+			err = report.WriteText(r.log.Writer())
+		}
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if opts.Cache != nil {
+			hits, misses := opts.Cache.Stats()
+			r.log.Printf(msg120, hits, misses)
+		}
+		if len(*verifyExportFailures) > 0 {
+			if err := exportFailures(report, *verifyExportFailures, *verifyExportNull); err != nil {
+				return 0, wrap(err)
+			}
+		}
+		if len(*verifyCSV) > 0 {
+			if err := writeCSV(report, *verifyCSV); err != nil {
+				return 0, wrap(err)
+			}
+		}
+		if len(*verifyPrometheus) > 0 {
+			if err := writePrometheus(report, *verifyPrometheus); err != nil {
+				return 0, wrap(err)
+			}
+		}
+		if len(*verifyBadge) > 0 {
+			if err := writeBadge(report, *verifyBadge, time.Duration(*verifyBadgeStaleDays)*24*time.Hour); err != nil {
+				return 0, wrap(err)
+			}
+		}
+		fails := report.Failures()
+		if pending := report.Pending(); pending > 0 {
+			r.log.Printf(msg090, pending)
+		}
+		if notCompared := report.NotCompared(); notCompared > 0 {
+			r.log.Printf(msg160, notCompared)
+		}
+		r.logUnavailable(report)
+		failed := report.FailuresAtOrAbove(minSeverity) > 0
+		if fails > 0 {
+			// Failures below the exit threshold are reported but do not fail the command.
+			r.log.Printf(msg010, fails)
+		} else {
+			r.log.Println(msg020)
+		}
+		followUp := *verifyOnSuccess
+		if failed {
+			followUp = *verifyOnFailure
+		}
+		if len(followUp) > 0 {
+			// The database is released first, the follow-up command can run tripline itself.
+			if err := closeDb(); err != nil {
+				return 0, wrap(err)
+			}
+			return r.runFollowUp(followUp, *verifyFileset, report, failed), nil
+		}
+		if failed {
+			// If there are failed checks, the command should exit with non-zero exit code as well.
+			return 1, nil
+		}
+	case "list":
+		// Parse args
+		err := listFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if listFlags.NArg() > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		opts := &proc.ListOptions{
+			PathsOnly:  *listOne || !*listLong,
+			SortBySize: *listBySize,
+			SortByTime: *listByTime,
+			JSON:       *listJSON,
+			Pretty:     *listPretty,
+			Log:        r.log,
+		}
+		return 0, rollback(proc.ListRecords(*listFileset, opts, tripDb), tripDb)
+	case "deleteset":
+		// Parse args
+		err := deleteSetFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if deleteSetFlags.NArg() > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.DeleteSet(*deleteSetFileset, tripDb), tripDb)
+	case "listsets":
+		// Arity check
+		if len(cmdArgs) > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, rollback(proc.Listsets(r.log, tripDb), tripDb)
+	case "copyset":
+		// Parse args
+		err := copySetFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if copySetFlags.NArg() != 1 {
+			return 0, errors.New(err050)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.CopySet(*copyFileset, copySetFlags.Arg(0), tripDb), tripDb)
+	case "rename":
+		// Parse args
+		err := renameFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if renameFlags.NArg() != 1 {
+			return 0, errors.New(err280)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.RenameSet(*renameFileset, renameFlags.Arg(0), tripDb), tripDb)
+	case "export":
+		// Parse args
+		err := exportFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if exportFlags.NArg() > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, rollback(r.exportSet(*exportFileset, *exportOut, tripDb), tripDb)
+	case "import":
+		// Parse args
+		err := importFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if importFlags.NArg() > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		in := r.stdin
+		if len(*importFrom) > 0 {
+			f, err := os.Open(*importFrom)
+			if err != nil {
+				return 0, fmt.Errorf(err300, *importFrom, err)
+			}
+			defer f.Close()
+			in = f
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.ImportSet(*importFileset, in, *importOverwrite, r.log, tripDb), tripDb)
+	case "sign":
+		// Parse the arguments
+		err := signFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if signFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if *signJSON {
+			// Stdout is reserved for the json output.
+			r.log.SetOutput(r.stderr)
+		}
+		pwd, err := r.readSecret()
+		if err != nil {
+			return 0, fmt.Errorf(err070, err)
+		}
+		if *signDetached {
+			if len(*signOut) == 0 {
+				return 0, errors.New(err090)
+			}
+			// The signature is not stored, a read transaction suffices.
+			if err := tripDb.Begin(false); err != nil {
+				return 0, wrap(err)
+			}
+			result, err := proc.ExportSignature(*signFileset, pwd, true, *signOut, r.log, tripDb)
+			err = rollback(err, tripDb)
+			if err != nil {
+				return 0, err
+			}
+			if *signDebug {
+				r.logSignature(result)
+			}
+			if *signJSON {
+				if err := r.writeJSON(result); err != nil {
+					return 0, wrap(err)
+				}
+			}
+			break
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		result, err := proc.SignSet(*signFileset, pwd, *signOverwrite, r.log, tripDb)
+		if err == nil && len(*signOut) > 0 {
+			_, err = proc.ExportSignature(*signFileset, pwd, false, *signOut, r.log, tripDb)
+		}
+		err = commitOrRollback(err, tripDb)
+		if err != nil {
+			return 0, err
+		}
+		if *signDebug {
+			r.logSignature(result)
+		}
+		if *signJSON {
+			if err := r.writeJSON(result); err != nil {
+				return 0, wrap(err)
+			}
+		}
+	case "verifysig":
+		// Parse the arguments
+		err := signFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if signFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if *signJSON {
+			// Stdout is reserved for the json output.
+			r.log.SetOutput(r.stderr)
+		}
+		pwd, err := r.readSecret()
+		if err != nil {
+			return 0, fmt.Errorf(err070, err)
+		}
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		if len(*signSig) > 0 {
+			err = proc.VerifySetDetachedSignature(*signFileset, pwd, *signSig, r.log, tripDb)
+		} else {
+			err = proc.VerifySetSignature(*signFileset, pwd, tripDb)
+		}
+		if rollbackErr := tripDb.Rollback(); rollbackErr != nil {
+			return 0, wrap(rollbackErr)
+		}
+		if *signJSON {
+			if err := r.writeJSON(proc.NewSignatureVerification(*signFileset, err)); err != nil {
+				return 0, wrap(err)
+			}
+			if err != nil {
+				// A failed verification should result in a non-zero exit code, the reason is in the json.
+				return 1, nil
+			}
+		} else {
+			if err != nil {
+				return 0, wrap(err)
+			}
+			r.log.Printf(msg150, *signFileset)
+		}
+	case "resign":
+		// Parse the arguments
+		err := resignFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if resignFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		pwd, err := r.readSecret()
+		if err != nil {
+			return 0, fmt.Errorf(err070, err)
+		}
+		var filesets []string
+		if !*resignAll {
+			filesets = []string{*resignFileset}
+		}
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		err = proc.Resign(filesets, pwd, r.log, tripDb)
+		// The filesets that were re-signed are kept, the others are reported.
+		if commitErr := tripDb.Commit(); commitErr != nil {
+			return 0, wrap(commitErr)
+		}
+		if err != nil {
+			return 0, wrap(err)
+		}
+	case "snapshots":
+		// Parse the arguments
+		err := snapshotsFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if snapshotsFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, rollback(proc.ListSnapshots(*snapshotsFileset, r.log, tripDb), tripDb)
+	case "stats":
+		// Parse the arguments
+		err := statsFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if statsFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		err = proc.Stats(*statsFileset, *statsChanged, *statsTop, r.interrupt, r.log, tripDb)
+		if err == nil && *statsStorage {
+			err = proc.Storage(*statsFileset, r.log, tripDb)
+		}
+		return 0, rollback(err, tripDb)
+	case "fsck":
+		// Parse the arguments
+		err := fsckFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if fsckFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		problems, err := proc.Fsck(*fsckFileset, *fsckRoot, r.log, tripDb)
+		err = rollback(err, tripDb)
+		if err != nil {
+			return 0, err
+		}
+		if problems > 0 {
+			r.log.Printf(msg030, problems)
+			return 1, nil
+		}
+		r.log.Println(msg040)
+	case "augment":
+		// Parse the arguments
+		err := augmentFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if augmentFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if len(*augmentChecks) == 0 {
+			return 0, errors.New(err095)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.Augment(*augmentFileset, *augmentChecks, &readOpts, tripDb), tripDb)
+	case "compute-pending":
+		// Parse the arguments
+		err := computePendingFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if computePendingFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.ComputePending(*computePendingFileset, &readOpts, tripDb), tripDb)
+	case "watch":
+		// Parse the arguments
+		err := watchFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if watchFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		opts := &proc.WatchOptions{CheckInterval: *watchCheckInterval, Verify: &proc.VerifyOptions{ReadOptions: readOpts}}
+		if *watchEvents {
+			opts.Verify.Events = r.writeEvent
+		}
+		// The verifications run in their own transactions, the database is released between them. The watch only
+		// ends when interrupted.
+		err = proc.WatchSet(*watchFileset, opts, tripDb)
+		if err != nil {
+			return 0, wrap(err)
+		}
+	case "fingerprint":
+		// Parse the arguments
+		err := fingerprintFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if fingerprintFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		var pwd string
+		if *fingerprintVerifyTreeRoot {
+			pwd, err = r.readSecret()
+			if err != nil {
+				return 0, fmt.Errorf(err070, err)
+			}
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		err = proc.Fingerprint(*fingerprintFileset, r.log, tripDb)
+		if err == nil && *fingerprintVerifyTreeRoot {
+			err = proc.VerifyTreeRoot(*fingerprintFileset, pwd, r.log, tripDb)
+		}
+		return 0, rollback(err, tripDb)
+	case "diff":
+		// Parse the arguments
+		err := diffFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if diffFlags.NArg() != 2 {
+			return 0, fmt.Errorf(err260, cmd)
+		}
+		if *diffJSON {
+			// Stdout is reserved for the json output.
+			r.log.SetOutput(r.stderr)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		changes, err := proc.DiffSets(diffFlags.Arg(0), diffFlags.Arg(1), tripDb)
+		err = rollback(err, tripDb)
+		if err != nil {
+			return 0, err
+		}
+		if *diffJSON {
+			err = r.writeJSON(changes)
+		} else {
+			err = proc.WriteDiff(r.log.Writer(), changes)
+		}
+		if err != nil {
+			return 0, wrap(err)
+		}
+		if len(changes) > 0 {
+			// Differences fail the command like diff-export.
+			return 1, nil
+		}
+	case "quarantine":
+		// Parse the arguments
+		err := quarantineFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if quarantineFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if len(*quarantineOut) == 0 {
+			return 0, errors.New(err130)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		report, err := proc.Quarantine(*quarantineFileset, *quarantineOut, &proc.VerifyOptions{ReadOptions: readOpts}, tripDb)
+		err = rollback(err, tripDb)
+		if err != nil {
+			return 0, err
+		}
+		if err := report.WriteText(r.log.Writer()); err != nil {
+			return 0, wrap(err)
+		}
+		if fails := report.Failures(); fails > 0 {
+			r.log.Printf(msg010, fails)
+			return 1, nil
+		}
+		r.log.Println(msg020)
+	case "history":
+		// Parse the arguments
+		err := historyFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if historyFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, rollback(proc.History(*historyFileset, *historyJSON, r.log, tripDb), tripDb)
+	case "savequery":
+		// Parse the arguments
+		err := saveQueryFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		if len(*saveQueryName) == 0 {
+			return 0, fmt.Errorf(err240, cmd)
+		}
+		query := &db.SavedQuery{Include: *saveQueryIncludes, Exclude: *saveQueryExcludes}
+		if len(*saveQueryChecks) > 0 {
+			query.Checks, err = proc.ParseChecks(*saveQueryChecks)
+			if err != nil {
+				return 0, wrap(err)
+			}
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.SaveQuery(*saveQueryFileset, *saveQueryName, saveQueryFlags.Args(), query, r.log, tripDb), tripDb)
+	case "listqueries":
+		// Parse the arguments
+		err := listQueriesFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if listQueriesFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		// Start readable transaction
+		if err := tripDb.Begin(false); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, rollback(proc.ListQueries(*listQueriesFileset, r.log, tripDb), tripDb)
+	case "deletequery":
+		// Parse the arguments
+		err := deleteQueryFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if deleteQueryFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if len(*deleteQueryName) == 0 {
+			return 0, fmt.Errorf(err240, cmd)
+		}
+		// Start writable transaction
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.DeleteQuery(*deleteQueryFileset, *deleteQueryName, r.log, tripDb), tripDb)
+	case "restore":
+		// Parse the arguments
+		err := restoreFlags.Parse(cmdArgs)
+		if err != nil {
+			return parseErrorCode(err), nil
+		}
+		// Arity check
+		if restoreFlags.NArg() != 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if len(*restoreFrom) == 0 || len(*restoreSig) == 0 {
+			return 0, errors.New(err170)
+		}
+		pwd, err := r.readSecret()
+		if err != nil {
+			return 0, fmt.Errorf(err070, err)
+		}
+		// Start writable transaction, it is rolled back if the signature does not validate.
+		if err := tripDb.Begin(true); err != nil {
+			return 0, wrap(err)
+		}
+		return 0, commitOrRollback(
+			proc.Restore(*restoreFileset, *restoreFrom, *restoreSig, pwd, r.log, tripDb), tripDb)
+	case "shell":
+		// Arity check
+		if len(cmdArgs) > 0 {
+			return 0, fmt.Errorf(err040, cmd)
+		}
+		if err := r.runShell(tripDb, readOpts); err != nil {
+			return 0, wrap(err)
+		}
+	default:
+		r.log.Printf(err060, cmd)
+		r.printManual(flagSets)
+		return 1, nil
+	}
+	return 0, nil
+}
+
+// The exit code after a flag parse error like flag.ExitOnError, the flag set printed the error and the usage.
+func parseErrorCode(err error) int {
+	if err == flag.ErrHelp {
+		return 0
+	}
+	return 2
+}
+
+// Helper for the operations that should not fail, e.g. the transactions. The error is prefixed like the other
+// command errors, an interrupt is passed on as it is and Run turns it into the interrupted exit code.
+func wrap(err error) error {
+	if err == nil || errors.Is(err, proc.ErrInterrupted) {
+		return err
+	}
+	return fmt.Errorf(err010, err)
+}
+
+// Helper to commit/rollback the database according to the result of the operation.
+// The idea is to insert the transactional call in the first argument, the error of the operation is returned as it
+// is. Nothing was written after an interrupt.
+func commitOrRollback(err error, tripDb *db.TriplineDb) error {
+	if err == nil {
+		// No errors, we can commit the changes.
+		return wrap(tripDb.Commit())
+	}
+	// Roll back all database modifications if an error was reported.
+	if rollbackErr := tripDb.Rollback(); rollbackErr != nil {
+		return wrap(rollbackErr)
+	}
+	return err
+}
+
+// Helper to end the read transaction of the operation in the first argument. Returns the error of the operation,
+// or else the error of the rollback.
+func rollback(err error, tripDb *db.TriplineDb) error {
+	rollbackErr := tripDb.Rollback()
+	if err == nil {
+		err = rollbackErr
+	}
+	return wrap(err)
+}
+
+// Helper to print the "usage" of each set in a list of flag sets.
+func (r *runner) printManual(sets []*flag.FlagSet) {
+	r.log.Printf(err020)
+	for _, set := range sets {
+		set.Usage()
+	}
+}
+
+// Parse the values of a repeated id mapping flag.
+func parseIdMaps(mappings []string) ([]proc.IdMap, error) {
+	result := make([]proc.IdMap, 0, len(mappings))
+	for _, mapping := range mappings {
+		idMap, err := proc.ParseIdMap(mapping)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, idMap)
+	}
+	return result, nil
+}
+
+// Write the paths of the failed checks to a file.
+func exportFailures(report *proc.VerifyReport, out string, null bool) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf(err120, out, err)
+	}
+	separator := byte('\n')
+	if null {
+		separator = 0
+	}
+	err = report.WriteFailedPaths(f, separator)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf(err120, out, err)
+	}
+	return f.Close()
+}
+
+// Export a fileset to a file or to stdout.
+func (r *runner) exportSet(fileset string, out string, tripDb *db.TriplineDb) error {
+	if len(out) == 0 {
+		return proc.ExportSet(fileset, r.stdout, tripDb)
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf(err290, out, err)
+	}
+	err = proc.ExportSet(fileset, f, tripDb)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Verify the filesets in turn, the sections of the reports are combined. The combined report has the oldest update
+// time of the filesets.
+func verifyFilesets(fileNames []string, filesets []string, opts *proc.VerifyOptions, tripDb *db.TriplineDb) (*proc.VerifyReport, error) {
+	combined := &proc.VerifyReport{}
+	for i, fileset := range filesets {
+		report, err := proc.VerifyFiles(fileNames, fileset, opts, tripDb)
+		if err != nil {
+			return nil, err
+		}
+		combined.Sections = append(combined.Sections, report.Sections...)
+		if i == 0 || report.UpdatedAt < combined.UpdatedAt {
+			combined.UpdatedAt = report.UpdatedAt
+		}
+	}
+	return combined, nil
+}
+
+// Log the fileset hash and the signature, they should not end up in the logs routinely.
+func (r *runner) logSignature(result *proc.SignResult) {
+	debug := log.New(r.log.Writer(), "debug: ", r.log.Flags())
+	debug.Printf("hash: %s", result.Fingerprint)
+	debug.Printf("signature: %x", result.Signature)
+}
+
+// Open the baselines read only and verify the fileset against the quorum of them.
+// A quorum of 0 is a majority of the baselines. With a password the signature of the fileset in each baseline is
+// verified first, a baseline that fails it aborts the verification. The password is nil to skip the signatures.
+func verifyQuorum(dbPaths []string, dbOpts *db.OpenOptions, quorum int, fileset string, namespace string, password *string, opts *proc.VerifyOptions) (*proc.VerifyReport, error) {
+	if quorum == 0 {
+		quorum = len(dbPaths)/2 + 1
+	}
+	baselines := make([]*db.TriplineDb, 0, len(dbPaths))
+	defer func() {
+		for _, baseline := range baselines {
+			_ = baseline.Rollback()
+			_ = baseline.Close()
+		}
+	}()
+	readOnly := *dbOpts
+	readOnly.ReadOnly = true
+	for _, dbPath := range dbPaths {
+		baseline, err := db.OpenTriplineDbWith(dbPath, &readOnly)
+		if err != nil {
+			return nil, fmt.Errorf(err200, dbPath, err)
+		}
+		err = baseline.SetNamespace(namespace)
+		if err == nil {
+			err = baseline.Begin(false)
+		}
+		if err != nil {
+			_ = baseline.Close()
+			return nil, fmt.Errorf(err200, dbPath, err)
+		}
+		baselines = append(baselines, baseline)
+		if password != nil {
+			err = proc.VerifySetSignature(fileset, *password, baseline)
+			if err != nil {
+				return nil, fmt.Errorf(err200, dbPath, err)
+			}
+		}
+	}
+	return proc.VerifyQuorum(fileset, baselines, quorum, opts)
+}
+
+// Write the badge of the verification to a file.
+func writeBadge(report *proc.VerifyReport, out string, staleAfter time.Duration) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf(err190, out, err)
+	}
+	err = report.WriteBadge(f, staleAfter)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf(err190, out, err)
+	}
+	return f.Close()
+}
+
+// Print the number of skipped checks that are not available on this platform, per check.
+func (r *runner) logUnavailable(report *proc.VerifyReport) {
+	unavailable := report.Unavailable()
+	checks := make([]string, 0, len(unavailable))
+	for check := range unavailable {
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	for _, check := range checks {
+		r.log.Printf(msg140, unavailable[check], check)
+	}
+}
+
+// Run the follow-up command of a verification with the shell, the outcome is passed in the environment:
+// TRIPLINE_FILESET, TRIPLINE_RESULT (passed or failed), TRIPLINE_ENTRIES, TRIPLINE_FAILURES and TRIPLINE_PENDING.
+// Returns the exit code of the command.
+func (r *runner) runFollowUp(command string, fileset string, report *proc.VerifyReport, failed bool) int {
+	result := "passed"
+	if failed {
+		result = "failed"
+	}
+	entries := 0
+	for _, section := range report.Sections {
+		entries += section.Entries
+	}
+	r.log.Printf(msg130, command)
+	child := exec.Command("sh", "-c", command)
+	child.Stdin, child.Stdout, child.Stderr = r.stdin, r.stdout, r.stderr
+	child.Env = append(os.Environ(),
+		"TRIPLINE_FILESET="+fileset,
+		"TRIPLINE_RESULT="+result,
+		fmt.Sprintf("TRIPLINE_ENTRIES=%d", entries),
+		fmt.Sprintf("TRIPLINE_FAILURES=%d", report.Failures()),
+		fmt.Sprintf("TRIPLINE_PENDING=%d", report.Pending()))
+	err := child.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		return exitErr.ExitCode()
+	}
+	if err != nil {
+		// Not started or killed by a signal.
+		r.log.Println(fmt.Errorf(err230, command, err))
+		return 1
+	}
+	return 0
+}
+
+// Write the metrics of the verification to a file. The metrics are written to a temporary file in the same directory
+// that is renamed, so a collector never reads a partial file.
+func writePrometheus(report *proc.VerifyReport, out string) error {
+	f, err := ioutil.TempFile(filepath.Dir(out), "."+filepath.Base(out)+".*")
+	if err != nil {
+		return fmt.Errorf(err250, out, err)
+	}
+	// The collector runs as another user, the temporary files are private.
+	err = f.Chmod(0644)
+	if err == nil {
+		err = report.WritePrometheus(f, time.Now())
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), out)
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+		return fmt.Errorf(err250, out, err)
+	}
+	return nil
+}
+
+// Write the check results to a csv file.
+func writeCSV(report *proc.VerifyReport, out string) error {
+	f, err := os.Create(out)
+	if err != nil {
+		return fmt.Errorf(err210, out, err)
+	}
+	err = report.WriteCSV(f)
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf(err210, out, err)
+	}
+	return f.Close()
+}
+
+// Read the file names, terminated by a newline or a null character. Empty names are ignored.
+func readFileNames(r io.Reader, null bool) ([]string, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	separator := "\n"
+	if null {
+		separator = "\x00"
+	}
+	result := make([]string, 0)
+	for _, name := range strings.Split(string(data), separator) {
+		if !null {
+			name = strings.TrimRight(name, "\r")
+		}
+		if len(name) > 0 {
+			result = append(result, name)
+		}
+	}
+	return result, nil
+}
+
+// Parse the permission bits that are not compared, the complement of the mask and the ignored bits, octal.
+func parseIgnoredPermissions(mask string, ignore string) (uint32, error) {
+	var result uint64
+	if len(mask) > 0 {
+		bits, err := strconv.ParseUint(mask, 8, 32)
+		if err != nil || bits > 07777 {
+			return 0, fmt.Errorf(err180, mask)
+		}
+		result = 07777 &^ bits
+	}
+	if len(ignore) > 0 {
+		bits, err := strconv.ParseUint(ignore, 8, 32)
+		if err != nil || bits > 07777 {
+			return 0, fmt.Errorf(err180, ignore)
+		}
+		result |= bits
+	}
+	return uint32(result), nil
+}
+
+// Parse the octal file mode of the database.
+// World writable modes allow anybody to tamper with the baselines, they are only accepted when forced.
+func parseDbMode(mode string, force bool) (os.FileMode, error) {
+	value, err := strconv.ParseUint(mode, 8, 32)
+	if err != nil || value > 0777 {
+		return 0, fmt.Errorf(err100, mode)
+	}
+	if value&0002 != 0 && !force {
+		return 0, fmt.Errorf(err105, mode)
+	}
+	return os.FileMode(value), nil
+}
+
+// The commands that only read the database, they open it read only so they can run at the same time. A verify
+// that records the history writes, the flag is looked up before the arguments are parsed.
+func readsOnly(cmd string, cmdArgs []string) bool {
+	switch cmd {
+	case "list", "listsets", "export", "verifysig", "snapshots", "stats", "fsck", "watch", "fingerprint", "diff",
+		"quarantine", "history", "listqueries":
+		return true
+	case "verify":
+		for _, arg := range cmdArgs {
+			if arg == "--" {
+				break
+			}
+			name := strings.TrimLeft(arg, "-")
+			if len(name) < len(arg) && (name == "history" || strings.HasPrefix(name, "history=") && name != "history=false") {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// The database file: the -db option, the TRIPLINE_DB environment variable or the default location.
+func resolveDbPath(dbFile string) (string, error) {
+	if len(dbFile) == 0 {
+		dbFile = os.Getenv("TRIPLINE_DB")
+	}
+	if len(dbFile) == 0 {
+		return db.DefaultTriplineDbPath()
+	}
+	return filepath.Abs(dbFile)
+}
+
+// Hand a new database over to a service account. Only root can change the owner, it is ignored for other users.
+func (r *runner) chownDb(dbPath string, owner string) error {
+	if os.Geteuid() != 0 {
+		r.log.Printf(msg060, owner)
+		return nil
+	}
+	parts := strings.SplitN(owner, ":", 2)
+	usr, err := user.Lookup(parts[0])
+	if err != nil {
+		return fmt.Errorf(err110, owner, err)
+	}
+	uid, err := strconv.Atoi(usr.Uid)
+	if err != nil {
+		return fmt.Errorf(err110, owner, err)
+	}
+	gid, err := strconv.Atoi(usr.Gid)
+	if err != nil {
+		return fmt.Errorf(err110, owner, err)
+	}
+	if len(parts) > 1 {
+		grp, err := user.LookupGroup(parts[1])
+		if err != nil {
+			return fmt.Errorf(err110, owner, err)
+		}
+		gid, err = strconv.Atoi(grp.Gid)
+		if err != nil {
+			return fmt.Errorf(err110, owner, err)
+		}
+	}
+	err = os.Chown(dbPath, uid, gid)
+	if err != nil {
+		return fmt.Errorf(err110, owner, err)
+	}
+	return nil
+}
+
+// Flag value that collects the values of a repeated flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// Write a value as a json line to stdout.
+func (r *runner) writeJSON(v interface{}) error {
+	return json.NewEncoder(r.stdout).Encode(v)
+}
+
+// Event sink that writes the events as newline delimited json to stderr.
+func (r *runner) writeEvent(e *proc.Event) {
+	jsn, err := json.Marshal(e)
+	if err == nil {
+		_, _ = r.stderr.Write(append(jsn, '\n'))
+	}
+}
+
+// Show the progress events with a total as a percentage on stderr, the line is rewritten on each change.
+func (r *runner) newProgressWriter() proc.EventSink {
+	last := -1
+	return func(e *proc.Event) {
+		if e.Type != proc.EventProgress || e.Total <= 0 {
+			return
+		}
+		percent := e.Done * 100 / e.Total
+		if percent != last {
+			last = percent
+			fmt.Fprintf(r.stderr, "\r%d of %d, %d%%", e.Done, e.Total, percent)
+		}
+	}
+}
+
+// Parse a size with an optional unit suffix like "512", "64KB" or "100MB" into a number of bytes.
+// The units are powers of 1024. The empty string is parsed as 0.
+func parseSize(size string) (int64, error) {
+	s := strings.ToUpper(strings.TrimSpace(size))
+	if s == "" {
+		return 0, nil
+	}
+	multiplier := int64(1)
+	for i, unit := range []string{"KB", "MB", "GB", "TB"} {
+		if strings.HasSuffix(s, unit) {
+			multiplier = int64(1) << (10 * uint(i+1))
+			s = strings.TrimSuffix(s, unit)
+			break
+		}
+	}
+	s = strings.TrimSuffix(s, "B")
+	n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf(err080, size)
+	}
+	return n * multiplier, nil
+}
+
+func (r *runner) readSecret() (string, error) {
+	return r.readSecretPrompt("Enter Password: ")
+}
+
+func (r *runner) readSecretPrompt(prompt string) (string, error) {
+	// The prompt is written to stderr, stdout is reserved for the json output.
+	fmt.Fprint(r.stderr, prompt)
+	var bytePassword []byte
+	var err error
+	if fd, isTerminal := r.stdinTerminal(); isTerminal {
+		bytePassword, err = terminal.ReadPassword(fd)
+	} else {
+		// Piped in by a script.
+		bytePassword, err = readLine(r.stdin)
+	}
+	fmt.Fprintln(r.stderr)
+	if err != nil {
+		return "", err
+	}
+
+	password := string(bytePassword)
+	return strings.TrimSpace(password), nil
+}
+
+// The file descriptor of stdin if it is a terminal, only a terminal can hide the password and edit the shell
+// commands.
+func (r *runner) stdinTerminal() (int, bool) {
+	f, isFile := r.stdin.(*os.File)
+	if !isFile || !terminal.IsTerminal(int(f.Fd())) {
+		return 0, false
+	}
+	return int(f.Fd()), true
+}
+
+// Read a line without the line ending. The bytes are read one at a time, the rest of the input is left to the
+// command, e.g. the file names after the password.
+func readLine(r io.Reader) ([]byte, error) {
+	var line []byte
+	b := make([]byte, 1)
+	for {
+		n, err := r.Read(b)
+		if n > 0 {
+			if b[0] == '\n' {
+				return line, nil
+			}
+			line = append(line, b[0])
+		}
+		if err == io.EOF && len(line) > 0 {
+			return line, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"github.com/branscha/tripline/proc"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Run the command line against the database, returns the exit code and the output.
+func runTest(t *testing.T, tripDb *db.TriplineDb, args ...string) (int, string) {
+	t.Helper()
+	return runTestStdin(t, tripDb, "", args...)
+}
+
+// Run the command line with the given input on stdin.
+func runTestStdin(t *testing.T, tripDb *db.TriplineDb, stdin string, args ...string) (int, string) {
+	t.Helper()
+	var out bytes.Buffer
+	code := Run(context.Background(), args, tripDb, strings.NewReader(stdin), &out, &out)
+	return code, out.String()
+}
+
+func TestRunExitCodes(t *testing.T) {
+	tripDb := dbtest.Open(t, false)
+	dir := t.TempDir()
+
+	code, out := runTest(t, tripDb, "add", "-fileset", "test", "-filechecks", "size", "-dirchecks", "modtime", dir)
+	if code != 0 {
+		t.Fatalf("add exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "verify", "-fileset", "test", dir)
+	if code != 0 {
+		t.Fatalf("verify exited with %d: %s", code, out)
+	}
+
+	// The errors end the command with exit code 1, the database of the caller is rolled back and left open.
+	code, out = runTest(t, tripDb, "add", "-fileset", "test", "-filechecks", "bogus", dir)
+	if code != 1 || !strings.Contains(out, "(proc/030) unknown check \"bogus\"") {
+		t.Errorf("add with an unknown check exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "add", "-fileset", "_signatures", dir)
+	if code != 1 || !strings.Contains(out, "(proc/005)") {
+		t.Errorf("add to a reserved fileset exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "list", "-fileset", "test")
+	if code != 0 || !strings.Contains(out, dir) {
+		t.Errorf("list after the failed commands exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "list", "-fileset", "test", dir)
+	if code != 1 || !strings.Contains(out, "(tripl/040)") {
+		t.Errorf("list with an argument exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "diff-export", "old.json")
+	if code != 1 || !strings.Contains(out, "(tripl/310)") {
+		t.Errorf("diff-export with a single export exited with %d: %s", code, out)
+	}
+
+	// The flag errors exit with 2 like the flag package does, a help request with 0.
+	code, _ = runTest(t, tripDb, "list", "-bogus")
+	if code != 2 {
+		t.Errorf("unknown flag exited with %d, want 2", code)
+	}
+	code, _ = runTest(t, tripDb, "list", "-h")
+	if code != 0 {
+		t.Errorf("help exited with %d, want 0", code)
+	}
+}
+
+func TestRunStdin(t *testing.T) {
+	tripDb := dbtest.Open(t, false)
+	dir := t.TempDir()
+	file := filepath.Join(dir, "a.txt")
+	if err := ioutil.WriteFile(file, []byte("a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The path key is the first line, the file names follow.
+	code, out := runTestStdin(t, tripDb, "secret\n"+file+"\n", "add", "-fileset", "test", "-hash-paths", "-from-stdin")
+	if code != 0 {
+		t.Fatalf("add from stdin exited with %d: %s", code, out)
+	}
+	code, out = runTestStdin(t, tripDb, "secret\n", "-path-key", "list", "-fileset", "test")
+	if code != 0 || !strings.Contains(out, file) {
+		t.Errorf("list with the path key exited with %d: %s", code, out)
+	}
+
+	// Without input the prompt fails.
+	code, out = runTest(t, tripDb, "-path-key", "list", "-fileset", "test")
+	if code != 1 {
+		t.Errorf("list without the path key exited with %d: %s", code, out)
+	}
+}
+
+func TestRunReadOnlyCommands(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tripline.db")
+	dir := t.TempDir()
+	code, out := runTest(t, nil, "-db", dbPath, "add", "-fileset", "test", "-filechecks", "size", "-dirchecks", "modtime", dir)
+	if code != 0 {
+		t.Fatalf("add exited with %d: %s", code, out)
+	}
+	reader, err := db.OpenTriplineDbWith(dbPath, &db.OpenOptions{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	// The readers run next to another reader, the commands that write wait for it.
+	for _, args := range [][]string{{"listsets"}, {"verify", "-fileset", "test"}} {
+		code, out = runTest(t, nil, append([]string{"-db", dbPath, "-lock-wait", "100ms"}, args...)...)
+		if code != 0 {
+			t.Errorf("%s next to a reader exited with %d: %s", args[0], code, out)
+		}
+	}
+	code, out = runTest(t, nil, "-db", dbPath, "-lock-wait", "100ms", "verify", "-fileset", "test", "-history")
+	if code != 1 || !strings.Contains(out, "(db/330)") {
+		t.Errorf("verify with the history next to a reader exited with %d: %s", code, out)
+	}
+}
+
+func TestRunDiffExport(t *testing.T) {
+	tripDb := dbtest.Open(t, false)
+	dir := t.TempDir()
+	exports := t.TempDir()
+	oldExport := filepath.Join(exports, "old.json")
+	newExport := filepath.Join(exports, "new.json")
+	code, out := runTest(t, tripDb, "add", "-fileset", "test", "-filechecks", "size", "-dirchecks", "modtime", dir)
+	if code != 0 {
+		t.Fatalf("add exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "export", "-fileset", "test", "-out", oldExport)
+	if code != 0 {
+		t.Fatalf("export exited with %d: %s", code, out)
+	}
+	file := filepath.Join(dir, "new.txt")
+	err := ioutil.WriteFile(file, []byte("new"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, out = runTest(t, tripDb, "add", "-fileset", "test", "-filechecks", "size", file)
+	if code != 0 {
+		t.Fatalf("add exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, tripDb, "export", "-fileset", "test", "-out", newExport)
+	if code != 0 {
+		t.Fatalf("export exited with %d: %s", code, out)
+	}
+
+	// The exports are compared without a database, no home directory is needed.
+	t.Setenv("HOME", "")
+	t.Setenv("TRIPLINE_DB", "")
+	code, out = runTest(t, nil, "diff-export", oldExport, oldExport)
+	if code != 0 {
+		t.Errorf("diff-export of equal exports exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, nil, "diff-export", oldExport, newExport)
+	if code != 1 || !strings.Contains(out, "+ "+file) {
+		t.Errorf("diff-export with differences exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, nil, "diff-export", "-json", oldExport, newExport)
+	if code != 1 {
+		t.Fatalf("diff-export -json exited with %d: %s", code, out)
+	}
+	var changes []proc.RecordChange
+	err = json.Unmarshal([]byte(out), &changes)
+	if err != nil {
+		t.Fatalf("diff-export -json output %q: %v", out, err)
+	}
+	if len(changes) != 1 || changes[0].Path != file || changes[0].Change != proc.ChangeAdded {
+		t.Errorf("diff-export -json = %+v", changes)
+	}
+}
+
+func TestRunVerifyBaselines(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(file, []byte("app"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	dbs := t.TempDir()
+	signed, unsigned := filepath.Join(dbs, "signed.db"), filepath.Join(dbs, "unsigned.db")
+	// The default database of the verifications, the baselines replace it.
+	dbPath := filepath.Join(dbs, "tripline.db")
+	for _, baseline := range []string{signed, unsigned} {
+		code, out := runTest(t, nil, "-db", baseline, "add", "-fileset", "test", "-filechecks", "sha256", file)
+		if code != 0 {
+			t.Fatalf("add exited with %d: %s", code, out)
+		}
+	}
+
+	// The options that the baselines do not support are refused instead of ignored.
+	for _, args := range [][]string{{"-query", "conf"}, {"-require-checks", "size"}, {"-history"}, {file}} {
+		code, out := runTest(t, nil, append([]string{"-db", dbPath, "verify", "-fileset", "test", "-baseline", signed}, args...)...)
+		if code != 1 || !strings.Contains(out, "(tripl/320)") {
+			t.Errorf("verify -baseline %v exited with %d: %s", args, code, out)
+		}
+	}
+	code, out := runTest(t, nil, "-db", dbPath, "verify", "-fileset", "test", "-baseline-signed")
+	if code != 1 || !strings.Contains(out, "(tripl/330)") {
+		t.Errorf("verify -baseline-signed without a baseline exited with %d: %s", code, out)
+	}
+	code, out = runTest(t, nil, "-db", dbPath, "verify", "-fileset", "test", "-baseline", signed, "-baseline", unsigned)
+	if code != 0 {
+		t.Errorf("verify against the baselines exited with %d: %s", code, out)
+	}
+
+	if testing.Short() {
+		t.Skip("the key derivation of the signature takes seconds")
+	}
+	code, out = runTestStdin(t, nil, "secret\n", "-db", signed, "sign", "-fileset", "test")
+	if code != 0 {
+		t.Fatalf("sign exited with %d: %s", code, out)
+	}
+	code, out = runTestStdin(t, nil, "secret\n", "-db", dbPath, "verify", "-fileset", "test", "-baseline", signed, "-baseline-signed")
+	if code != 0 {
+		t.Errorf("verify against the signed baseline exited with %d: %s", code, out)
+	}
+	code, out = runTestStdin(t, nil, "secret\n", "-db", dbPath, "verify", "-fileset", "test", "-baseline", signed, "-baseline", unsigned, "-baseline-signed")
+	if code != 1 || !strings.Contains(out, "(tripl/200) open baseline \""+unsigned+"\"") {
+		t.Errorf("verify against an unsigned baseline exited with %d: %s", code, out)
+	}
+}
@@ -0,0 +1,250 @@
+package cli
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/proc"
+	"golang.org/x/crypto/ssh/terminal"
+	"io"
+	"log"
+	"strings"
+)
+
+const (
+	err140 = "(tripl/140) unknown shell command %q, try help"
+	err150 = "(tripl/150) shell command %q expects at most one fileset"
+	err160 = "(tripl/160) invalid fileset name %q"
+)
+
+const (
+	msg070 = "using fileset %q"
+	msg080 = "commands: listsets, list [-1] [-S] [-t] [FILESET], verify [FILESET], stats [FILESET], fsck [FILESET], fingerprint [FILESET], snapshots [FILESET], history [FILESET], use FILESET, help, exit"
+)
+
+// The commands of the shell, used for the tab completion.
+var shellCommands = []string{"exit", "fingerprint", "fsck", "help", "history", "list", "listsets", "quit", "snapshots", "stats", "use", "verify"}
+
+// Type shell runs the commands against a database that stays open for the whole session.
+// Each command runs in its own read transaction, a failing command is reported and the session continues.
+type shell struct {
+	tripDb  *db.TriplineDb
+	fileset string
+	// How the verify command reads the files.
+	readOpts proc.ReadOptions
+	// The output of the commands.
+	log *log.Logger
+}
+
+// Read and execute commands until exit or the end of the input. On a terminal the line editing and the tab
+// completion of command and fileset names are available, otherwise the commands are read line by line so a session
+// can be scripted.
+func (r *runner) runShell(tripDb *db.TriplineDb, readOpts proc.ReadOptions) error {
+	sh := &shell{tripDb: tripDb, fileset: "default", readOpts: readOpts, log: r.log}
+	fd, isTerminal := r.stdinTerminal()
+	if !isTerminal {
+		scanner := bufio.NewScanner(r.stdin)
+		for scanner.Scan() {
+			if !sh.execute(scanner.Text()) {
+				return nil
+			}
+		}
+		return scanner.Err()
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		return err
+	}
+	defer terminal.Restore(fd, state)
+
+	term := terminal.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{r.stdin, r.stdout}, "tripline> ")
+	term.AutoCompleteCallback = sh.complete
+	// The terminal translates the line endings of the output in raw mode.
+	sh.log = log.New(term, "", 0)
+	for {
+		line, err := term.ReadLine()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !sh.execute(line) {
+			return nil
+		}
+	}
+}
+
+// Execute a single command line, the result is false when the session should end.
+func (sh *shell) execute(line string) bool {
+	args := strings.Fields(line)
+	if len(args) == 0 {
+		return true
+	}
+	cmd, cmdArgs := args[0], args[1:]
+	switch cmd {
+	case "exit", "quit":
+		return false
+	case "help":
+		sh.log.Println(msg080)
+		return true
+	case "use":
+		if len(cmdArgs) != 1 {
+			sh.log.Printf(err150, cmd)
+			return true
+		}
+		if sh.tripDb.IsReservedFileset(cmdArgs[0]) || db.HasNamespaceSeparator(cmdArgs[0]) {
+			sh.log.Printf(err160, cmdArgs[0])
+			return true
+		}
+		sh.fileset = cmdArgs[0]
+		sh.log.Printf(msg070, sh.fileset)
+		return true
+	}
+
+	var err error
+	switch cmd {
+	case "listsets", "list", "verify", "stats", "fsck", "fingerprint", "snapshots", "history":
+		err = sh.run(cmd, cmdArgs)
+	default:
+		err = fmt.Errorf(err140, cmd)
+	}
+	if err != nil {
+		sh.log.Println(err)
+	}
+	return true
+}
+
+// Run a database command in a read transaction that is rolled back afterwards, none of the shell commands modify
+// the database.
+func (sh *shell) run(cmd string, args []string) error {
+	listFlags := flag.NewFlagSet("list", flag.ContinueOnError)
+	listFlags.SetOutput(sh.log.Writer())
+	listOne := listFlags.Bool("1", false, "Only list the paths.")
+	listBySize := listFlags.Bool("S", false, "Sort by recorded size, largest first.")
+	listByTime := listFlags.Bool("t", false, "Sort by recorded modification time, newest first.")
+	if cmd == "list" {
+		if err := listFlags.Parse(args); err != nil {
+			return err
+		}
+		args = listFlags.Args()
+	}
+
+	fileset := sh.fileset
+	if len(args) > 1 || (cmd == "listsets" && len(args) > 0) {
+		return fmt.Errorf(err150, cmd)
+	}
+	if len(args) == 1 {
+		fileset = args[0]
+	}
+	// The processing functions refuse the reserved names as well, the check gives the error of the shell.
+	if sh.tripDb.IsReservedFileset(fileset) || db.HasNamespaceSeparator(fileset) {
+		return fmt.Errorf(err160, fileset)
+	}
+
+	err := sh.tripDb.Begin(false)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := sh.tripDb.Rollback(); err != nil {
+			sh.log.Println(err)
+		}
+	}()
+
+	switch cmd {
+	case "listsets":
+		return proc.Listsets(sh.log, sh.tripDb)
+	case "list":
+		opts := &proc.ListOptions{
+			PathsOnly:  *listOne,
+			SortBySize: *listBySize,
+			SortByTime: *listByTime,
+			Log:        sh.log,
+		}
+		return proc.ListRecords(fileset, opts, sh.tripDb)
+	case "verify":
+		opts := &proc.VerifyOptions{ReadOptions: sh.readOpts}
+		opts.Log = sh.log
+		report, err := proc.VerifyFiles(nil, fileset, opts, sh.tripDb)
+		if err != nil {
+			return err
+		}
+		if err := report.WriteText(sh.log.Writer()); err != nil {
+			return err
+		}
+		if fails := report.Failures(); fails > 0 {
+			sh.log.Printf(msg010, fails)
+		} else {
+			sh.log.Println(msg020)
+		}
+	case "stats":
+		return proc.Stats(fileset, false, 0, sh.readOpts.Interrupt, sh.log, sh.tripDb)
+	case "fsck":
+		problems, err := proc.Fsck(fileset, "", sh.log, sh.tripDb)
+		if err != nil {
+			return err
+		}
+		if problems > 0 {
+			sh.log.Printf(msg030, problems)
+		} else {
+			sh.log.Println(msg040)
+		}
+	case "fingerprint":
+		return proc.Fingerprint(fileset, sh.log, sh.tripDb)
+	case "snapshots":
+		return proc.ListSnapshots(fileset, sh.log, sh.tripDb)
+	case "history":
+		return proc.History(fileset, false, sh.log, sh.tripDb)
+	}
+	return nil
+}
+
+// Complete the word before the cursor on a tab, the first word is a command and the others are fileset names.
+// The output of the terminal cannot be used here, so the candidates are not listed.
+func (sh *shell) complete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	start := strings.LastIndex(line[:pos], " ") + 1
+	word := line[start:pos]
+
+	candidates := shellCommands
+	if len(strings.TrimSpace(line[:start])) > 0 {
+		// A separate transaction, the completion runs in between the commands.
+		tx, err := sh.tripDb.BeginTx(false)
+		if err != nil {
+			return "", 0, false
+		}
+		candidates, err = tx.ListFilesets()
+		tx.Rollback()
+		if err != nil {
+			return "", 0, false
+		}
+	}
+
+	// Complete up to the longest common prefix of the candidates.
+	completion := ""
+	found := false
+	for _, candidate := range candidates {
+		if !strings.HasPrefix(candidate, word) {
+			continue
+		}
+		if !found {
+			completion, found = candidate, true
+			continue
+		}
+		for !strings.HasPrefix(candidate, completion) {
+			completion = completion[:len(completion)-1]
+		}
+	}
+	if !found || completion == word {
+		return "", 0, false
+	}
+	return line[:start] + completion + line[pos:], start + len(completion), true
+}
@@ -4,55 +4,69 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"errors"
 	"golang.org/x/crypto/scrypt"
 )
 
+// Size of the key derivation salt that is appended to the encrypted data.
+const SaltSize = 32
+
 func Encrypt(key, data []byte) ([]byte, error) {
 	key, salt, err := DeriveKey(key, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	blockCipher, err := aes.NewCipher(key)
-	if err != nil {
-		return nil, err
-	}
-
-	gcm, err := cipher.NewGCM(blockCipher)
+	ciphertext, err := Seal(key, data)
 	if err != nil {
 		return nil, err
 	}
 
-	nonce := make([]byte, gcm.NonceSize())
-	if _, err = rand.Read(nonce); err != nil {
-		return nil, err
-	}
-
-	ciphertext := gcm.Seal(nonce, nonce, data, nil)
-
 	ciphertext = append(ciphertext, salt...)
 
 	return ciphertext, nil
 }
 
 func Decrypt(key, data []byte) ([]byte, error) {
-	salt, data := data[len(data)-32:], data[:len(data)-32]
+	if len(data) < SaltSize {
+		return nil, errors.New("data too short")
+	}
+	salt, data := data[len(data)-SaltSize:], data[:len(data)-SaltSize]
 
 	key, _, err := DeriveKey(key, salt)
 	if err != nil {
 		return nil, err
 	}
 
-	blockCipher, err := aes.NewCipher(key)
+	return Open(key, data)
+}
+
+// Encrypt the data with a key that was derived before, see DeriveKey. The nonce precedes the encrypted data.
+// Use it to encrypt many small values with the same key, the key derivation is expensive.
+func Seal(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
-	gcm, err := cipher.NewGCM(blockCipher)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// Decrypt the data that was encrypted by Seal.
+func Open(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("data too short")
+	}
 	nonce, ciphertext := data[:gcm.NonceSize()], data[gcm.NonceSize():]
 
 	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
@@ -63,9 +77,18 @@ func Decrypt(key, data []byte) ([]byte, error) {
 	return plaintext, nil
 }
 
+func newGCM(key []byte) (cipher.AEAD, error) {
+	blockCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(blockCipher)
+}
+
 func DeriveKey(password, salt []byte) ([]byte, []byte, error) {
 	if salt == nil {
-		salt = make([]byte, 32)
+		salt = make([]byte, SaltSize)
 		if _, err := rand.Read(salt); err != nil {
 			return nil, nil, err
 		}
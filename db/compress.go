@@ -0,0 +1,96 @@
+package db
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+)
+
+// The gzip magic number, the stored json records start with "{" so a compressed record is recognized by its header.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// Storage usage of a fileset.
+type StorageStats struct {
+	Records int
+	// The number of records that are stored compressed.
+	Compressed int
+	// The size of the json records.
+	RawBytes int64
+	// The size of the stored values.
+	StoredBytes int64
+	// The size of the values if all the records above the threshold were compressed.
+	ThresholdBytes int64
+}
+
+// Compress the record if it is at least threshold bytes and the compression pays off, 0 disables the compression.
+func encodeValue(jsn []byte, threshold int) ([]byte, error) {
+	if threshold <= 0 || len(jsn) < threshold {
+		return jsn, nil
+	}
+	compressed, err := compress(jsn)
+	if err != nil || len(compressed) >= len(jsn) {
+		return jsn, err
+	}
+	return compressed, nil
+}
+
+// Return the json of a stored record.
+func decodeValue(v []byte) ([]byte, error) {
+	if !bytes.HasPrefix(v, gzipMagic) {
+		return v, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(v))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func compress(jsn []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, err := w.Write(jsn)
+	if err != nil {
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Scan the values of the fileset and compare the stored size with the size of the json records.
+// The values are compressed with the current threshold as well, to see what a threshold would save.
+func (tx *TriplineTx) FilesetStorage(fileset string) (result *StorageStats, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
+	if bkt == nil {
+		return nil, fmt.Errorf(err020, fileset)
+	}
+	stats := &StorageStats{}
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		jsn, err := decodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf(err070, err)
+		}
+		encoded, err := encodeValue(jsn, tx.compressThreshold)
+		if err != nil {
+			return nil, fmt.Errorf(err030, err)
+		}
+		stats.Records++
+		if bytes.HasPrefix(v, gzipMagic) {
+			stats.Compressed++
+		}
+		stats.RawBytes += int64(len(jsn))
+		stats.StoredBytes += int64(len(v))
+		stats.ThresholdBytes += int64(len(encoded))
+	}
+	return stats, nil
+}
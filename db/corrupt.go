@@ -0,0 +1,57 @@
+package db
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	err270 = "(db/270) database %q is corrupt, run repair or restore the filesets from a backup:%w"
+	err280 = "(db/280) database corrupt, run repair or restore the filesets from a backup:%v"
+)
+
+// The magic number and the version in the meta pages of a bolt database.
+const (
+	boltMagic   = 0xED0CDAED
+	boltVersion = 2
+	// The meta data follows the page header of 16 bytes.
+	boltMetaOffset = 16
+)
+
+// Bolt panics on corrupted pages, the panic is converted to an error at the boundary of the package.
+// Use it in a deferred call with the named error result of the function.
+func recoverCorrupt(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf(err280, r)
+	}
+}
+
+// Check the header of an existing database before bolt opens it, a truncated file or a file that is not a bolt
+// database is reported with a clear error. Bolt checks the checksums of the meta pages itself.
+func checkHeader(dbPath string) error {
+	f, err := os.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	header := make([]byte, boltMetaOffset+8)
+	_, err = io.ReadFull(f, header)
+	if err == io.EOF {
+		// An empty file is initialized by bolt.
+		return nil
+	}
+	if err == io.ErrUnexpectedEOF {
+		return fmt.Errorf(err270, dbPath, fmt.Errorf("truncated"))
+	}
+	if err != nil {
+		return err
+	}
+	magic := binary.LittleEndian.Uint32(header[boltMetaOffset:])
+	version := binary.LittleEndian.Uint32(header[boltMetaOffset+4:])
+	if magic != boltMagic || version != boltVersion {
+		return fmt.Errorf(err270, dbPath, fmt.Errorf("not a tripline database"))
+	}
+	return nil
+}
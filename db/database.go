@@ -1,22 +1,41 @@
 package db
 
 import (
-	"bytes"
+	"context"
 	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/boltdb/bolt"
 	"github.com/branscha/tripline/crypto"
+	"io/ioutil"
 	"log"
 	"os"
 	"path"
+	"sort"
 	"strings"
+	"time"
 )
 
 const (
-	dbname    = ".tripline"
-	sigbucket = "_signatures"
+	dbname = ".tripline"
+	// Format of the snapshot keys, sortable.
+	snapFormat = "2006-01-02T15:04:05.000000000Z"
+	// The prefix of the reserved bucket names by default.
+	defaultReservedPrefix = "_"
+)
+
+// The reserved buckets next to the filesets, their names start with the reserved prefix of the database, e.g.
+// "_signatures" with the default prefix. See OpenOptions.
+const (
+	sigbucket = "signatures"
+	// Snapshots of the record hashes of a fileset taken when it was signed.
+	snapbucket = "snapshots"
+	// Settings of the filesets that apply to all their records.
+	metabucket = "meta"
+	// The failure counts of the verifications, a time series per fileset.
+	historybucket = "verifyhistory"
 )
 
 const (
@@ -42,17 +61,75 @@ const (
 	err180 = "(db/180) no signature, not added or tampered"
 	err190 = "(db/190) wrong password or tampered: %w"
 	err200 = "(db/200) contents changed or tampered"
+	err220 = "(db/220) snapshot fileset %q:%w"
+	err230 = "(db/230) no snapshot %q for fileset %q"
+	err240 = "(db/240) fileset meta %q:%w"
+	err250 = "(db/250) verify history %q:%w"
+	err260 = "(db/260) invalid namespace %q"
+	err440 = "(db/440) fileset %q exists"
+	err450 = "(db/450) rename fileset %q:%w"
+	err460 = "(db/460) invalid reserved prefix %q"
+	err470 = "(db/470) database %q uses the reserved prefix %q"
+	err490 = "(db/490) delete fileset %q:%w"
 )
 
 var (
 	RecordExists = errors.New(err005)
 )
 
+// Outcome of signing a fileset.
+type SignatureInfo struct {
+	Fileset string
+	// The sha256 hash of the fileset contents that is signed.
+	Hash []byte
+	// The version of the hash.
+	Version int
+	// The encrypted hash, including the encryption nonce and key derivation salt.
+	Signature []byte
+}
+
 // Record to store in the tripline database.
 type TriplineRecord struct {
-	IsDir  bool                   `json:"isDir"`
+	IsDir bool `json:"isDir"`
+	// The file type: regular, dir, symlink, fifo, ... Empty in records of older versions.
+	Type   string                 `json:"type,omitempty"`
 	Checks []string               `json:"checks"`
 	Data   map[string]interface{} `json:"data"`
+	// The checks that were deferred, they have no data yet.
+	Pending []string `json:"pending,omitempty"`
+	// The encrypted path of a record in a fileset with hashed paths. It is only stored, the records that are
+	// returned have the path in their entry.
+	EncPath string `json:"encPath,omitempty"`
+}
+
+// Settings of a fileset that apply to all of its records.
+type FilesetMeta struct {
+	// The paths were resolved with filepath.EvalSymlinks before they were recorded.
+	Resolve bool `json:"resolve,omitempty"`
+	// The paths were recorded relative to the home directory, see AddOptions.HomeRelative.
+	HomeRelative bool `json:"homeRelative,omitempty"`
+	// Time of the last modification of the records, absent in filesets of older versions.
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	// The records are keyed by a keyed hash of their path, see EnableHashedPaths.
+	HashPaths bool `json:"hashPaths,omitempty"`
+	// The hex encoded salt of the path keys and the check value of the secret.
+	PathSalt  string `json:"pathSalt,omitempty"`
+	PathCheck string `json:"pathCheck,omitempty"`
+	// The hex encoded root of the hash tree of the records, empty if the fileset does not maintain one.
+	TreeRoot string `json:"treeRoot,omitempty"`
+	// The signed root and its signature, see SignTreeRoot.
+	TreeRootSigned    string `json:"treeRootSigned,omitempty"`
+	TreeRootSignature string `json:"treeRootSignature,omitempty"`
+	// The saved queries by name, see SaveQuery.
+	Queries map[string]*SavedQuery `json:"queries,omitempty"`
+}
+
+// Outcome of a verification of a fileset, an item of the verification history.
+type VerifyRun struct {
+	Timestamp string `json:"timestamp"`
+	Entries   int    `json:"entries"`
+	// The number of failures per check, the checks without failures are omitted.
+	Failures map[string]int `json:"failures"`
 }
 
 type TriplineEntry struct {
@@ -60,68 +137,407 @@ type TriplineEntry struct {
 	Path   string
 }
 
+// A record in its stored json form, see ListRawTriplineRecords.
+type RawTriplineEntry struct {
+	Record []byte
+	Path   string
+}
+
+// Handle to a transaction on the tripline database, the record operations are executed within the transaction.
+// Several handles can be open at the same time, bolt allows many read transactions but a single write transaction.
+// A handle should not be shared between goroutines.
+type TriplineTx struct {
+	boltTx *bolt.Tx
+	// The filesets are stored as "namespace/fileset", empty for the filesets without namespace.
+	namespace string
+	// The nodes of the fileset hash trees that were modified, see EnableTreeRoot.
+	treeDirty map[string]map[byte]bool
+	// The settings of the database when the transaction started.
+	txSettings
+}
+
+// The settings of the database that a transaction takes when it starts.
+type txSettings struct {
+	// The keys of the hashed paths of the database, nil without path key.
+	keyring *pathKeyring
+	// The prefix of the reserved bucket names of the database.
+	reservedPrefix string
+	// The records of at least this size are stored compressed, see OpenOptions.
+	compressThreshold int
+}
+
+// Separates the namespace from the fileset name in the bucket names.
+const namespaceSeparator = "/"
+
+// Check the prefix of the names of the reserved buckets, the buckets of the signatures, the snapshots, the fileset
+// settings, the verification history and the tree roots.
+func checkPrefix(prefix string) error {
+	if len(prefix) == 0 || strings.Contains(prefix, namespaceSeparator) {
+		return fmt.Errorf(err460, prefix)
+	}
+	return nil
+}
+
+// A reserved name is the name of one of the reserved buckets, it cannot be used for a fileset or a namespace.
+// Only the reserved names themselves are refused, other names with the prefix can be used.
+func isReservedName(prefix string, name string) bool {
+	switch name {
+	case prefix + sigbucket, prefix + snapbucket, prefix + metabucket, prefix + historybucket, prefix + treebucket:
+		return true
+	}
+	return false
+}
+
+// A reserved name of the database cannot be used for a fileset or a namespace, see isReservedName.
+func (db *TriplineDb) IsReservedFileset(name string) bool {
+	return isReservedName(db.txDefaults.reservedPrefix, name)
+}
+
+// The name of the reserved bucket with the prefix of the database.
+func (tx *TriplineTx) reserved(bucket string) []byte {
+	return []byte(tx.reservedPrefix + bucket)
+}
+
+// The database settings. The name starts with the namespace separator, it is never a fileset or a namespace.
+var settingsbucket = namespaceSeparator + "settings"
+
+const reservedPrefixKey = "reservedPrefix"
+
+// Refuse a database that was created with another reserved prefix, its reserved buckets would be listed and
+// modified as filesets. The prefix is recorded when a database is opened for writing the first time, a database of
+// an older version that has buckets but no recorded prefix uses the default prefix.
+func checkReservedPrefix(boltDb *bolt.DB, dbPath string, prefix string) error {
+	stored, recorded := "", false
+	err := boltDb.View(func(tx *bolt.Tx) error {
+		if bkt := tx.Bucket([]byte(settingsbucket)); bkt != nil {
+			if v := bkt.Get([]byte(reservedPrefixKey)); v != nil {
+				stored, recorded = string(v), true
+				return nil
+			}
+		}
+		stored = prefix
+		if name, _ := tx.Cursor().First(); name != nil {
+			stored = defaultReservedPrefix
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if stored != prefix {
+		return fmt.Errorf(err470, dbPath, stored)
+	}
+	if recorded || boltDb.IsReadOnly() {
+		return nil
+	}
+	return boltDb.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists([]byte(settingsbucket))
+		if err != nil {
+			return err
+		}
+		return bkt.Put([]byte(reservedPrefixKey), []byte(stored))
+	})
+}
+
+// Check the reserved prefix against the prefix of the open database, e.g. for a database that was opened by the
+// caller. The prefix of the database was checked when it was opened, see checkReservedPrefix.
+func (db *TriplineDb) CheckReservedPrefix(prefix string) error {
+	if prefix != db.txDefaults.reservedPrefix {
+		return fmt.Errorf(err470, db.boltDb.Path(), db.txDefaults.reservedPrefix)
+	}
+	return nil
+}
+
+// A fileset name with the namespace separator would address a fileset of another namespace, e.g. "a/b" is fileset
+// "b" of namespace "a". Such a name cannot be used for a fileset.
+func HasNamespaceSeparator(name string) bool {
+	return strings.Contains(name, namespaceSeparator)
+}
+
+// The bucket name of a fileset, it is also the key of the fileset in the reserved buckets.
+// A name with the namespace separator has no key, bolt refuses to create the nil bucket or key and finds nothing.
+func (tx *TriplineTx) key(fileset string) []byte {
+	if HasNamespaceSeparator(fileset) {
+		return nil
+	}
+	if len(tx.namespace) == 0 {
+		return []byte(fileset)
+	}
+	return []byte(tx.namespace + namespaceSeparator + fileset)
+}
+
+// The tripline database.
+// For compatibility the database also manages a single current transaction started with Begin, the record
+// operations of the embedded handle apply to that transaction. Use BeginTx for independent transactions.
 type TriplineDb struct {
 	boltDb *bolt.DB
-	boltTx *bolt.Tx
+	TriplineTx
+	// Path of the database file that has to be removed on close, empty for persistent databases.
+	tempPath string
+	// Path of the lease file that is removed on close, empty if there is none.
+	leasePath string
+	// The settings of the transactions that are started next, the path key is set by SetPathKey and the others
+	// by OpenOptions. The current transaction has a copy.
+	txDefaults txSettings
+	// The path and the options of the open, the file is opened again with them by Reacquire.
+	openPath string
+	openOpts OpenOptions
 }
 
+// The file mode of new databases, only the user can read the baselines.
+const DefaultDbMode os.FileMode = 0600
+
 // Open the Tripline database in the default location.
 // Normally it is the users home directory.
 func OpenDefaultTriplineDb() (*TriplineDb, error) {
-	// Construct the path to the tripline database to be
-	// ${HOME}/.tripline
-	home, err := os.UserHomeDir()
+	dbPath, err := DefaultTriplineDbPath()
 	if err != nil {
 		return nil, err
 	}
-	dbPath := path.Join(home, dbname)
 	// Open/create the database.
 	return OpenTriplineDb(dbPath)
 }
 
+// The path of the Tripline database in the default location, ${HOME}/.tripline
+func DefaultTriplineDbPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(home, dbname), nil
+}
+
 // Open the Tripline database in the default location.
 // Normally it is the users home directory.
 func OpenTriplineDb(dbPath string) (*TriplineDb, error) {
-	// Open/create the bolt database.
-	db, err := bolt.Open(dbPath, 0600, nil)
+	return OpenTriplineDbMode(dbPath, DefaultDbMode)
+}
+
+// Open the Tripline database, a new database is created with the file mode.
+func OpenTriplineDbMode(dbPath string, mode os.FileMode) (*TriplineDb, error) {
+	return OpenTriplineDbWith(dbPath, &OpenOptions{Mode: mode})
+}
+
+// Options of opening a Tripline database.
+type OpenOptions struct {
+	// File mode of a new database, DefaultDbMode if zero.
+	Mode os.FileMode
+	// Open an existing database read only, see OpenTriplineDbReadOnly. The read only processes share the lock of the
+	// database, they only wait for a process that opened it for writing.
+	ReadOnly bool
+	// Prefix of the names of the reserved buckets, e.g. "_signatures" with the default prefix "_". The prefix is
+	// recorded in the database, a database with another prefix is refused, see checkReservedPrefix.
+	ReservedPrefix string
+	// How long to wait for another process that uses the database, 0 to wait until it is released.
+	LockWait time.Duration
+	// Ends the wait for the database lock when it is done, e.g. on an interrupt. The wait is not interrupted if nil.
+	Context context.Context
+	// The command that is recorded in the lease, e.g. "add".
+	LeaseCommand string
+	// Reports the holder of the lock while waiting for it, the standard logger if nil.
+	Logger *log.Logger
+	// Records of at least this number of bytes are stored compressed, 0 stores them as plain json. The existing
+	// records are not converted, both forms can be read.
+	CompressThreshold int
+}
+
+// Open the Tripline database with the options.
+func OpenTriplineDbWith(dbPath string, opts *OpenOptions) (*TriplineDb, error) {
+	settings := *opts
+	if settings.Mode == 0 {
+		settings.Mode = DefaultDbMode
+	}
+	if len(settings.ReservedPrefix) == 0 {
+		settings.ReservedPrefix = defaultReservedPrefix
+	}
+	err := checkPrefix(settings.ReservedPrefix)
+	if err != nil {
+		return nil, err
+	}
+	var tripDb *TriplineDb
+	if settings.ReadOnly {
+		tripDb, err = openReadOnly(dbPath, &settings)
+	} else {
+		tripDb, err = openWritable(dbPath, &settings)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tripDb.txDefaults.reservedPrefix = settings.ReservedPrefix
+	tripDb.txDefaults.compressThreshold = settings.CompressThreshold
+	tripDb.openPath = dbPath
+	tripDb.openOpts = settings
+	return tripDb, nil
+}
+
+// The mode of a new database is set explicitly so it is not restricted by the umask, the mode of an existing
+// database is left alone.
+func openWritable(dbPath string, opts *OpenOptions) (result *TriplineDb, err error) {
+	mode := opts.Mode
+	_, err = os.Stat(dbPath)
+	created := os.IsNotExist(err)
+	if !created {
+		err = checkHeader(dbPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// Open/create the bolt database, a corrupted file can make bolt panic.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(err270, dbPath, fmt.Errorf("%v", r))
+		}
+	}()
+	db, err := openLocked(dbPath, mode, opts)
+	if err == bolt.ErrInvalid || err == bolt.ErrChecksum || err == bolt.ErrVersionMismatch {
+		return nil, fmt.Errorf(err270, dbPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if created {
+		err = os.Chmod(dbPath, mode)
+		if err != nil {
+			_ = db.Close()
+			return nil, err
+		}
+	}
+	err = checkReservedPrefix(db, dbPath, opts.ReservedPrefix)
 	if err != nil {
+		_ = db.Close()
 		return nil, err
 	}
-	return &TriplineDb{db, nil}, nil
+	// The lease is informational, the database can be used without it, e.g. in a read only directory.
+	leasePath := ""
+	if writeLease(dbPath, mode, opts.LeaseCommand) == nil {
+		leasePath = dbPath + leaseSuffix
+	}
+	return &TriplineDb{boltDb: db, leasePath: leasePath}, nil
+}
+
+// Open an existing Tripline database read only, e.g. a baseline on other media. Several processes can read it.
+// A database that is opened for writing, e.g. the default database of this process, is waited for.
+func OpenTriplineDbReadOnly(dbPath string) (*TriplineDb, error) {
+	return OpenTriplineDbWith(dbPath, &OpenOptions{ReadOnly: true})
 }
 
+func openReadOnly(dbPath string, opts *OpenOptions) (result *TriplineDb, err error) {
+	err = checkHeader(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	// A corrupted file can make bolt panic, like in openWritable.
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(err270, dbPath, fmt.Errorf("%v", r))
+		}
+	}()
+	db, err := openLocked(dbPath, 0400, opts)
+	if err == bolt.ErrInvalid || err == bolt.ErrChecksum || err == bolt.ErrVersionMismatch {
+		return nil, fmt.Errorf(err270, dbPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = checkReservedPrefix(db, dbPath, opts.ReservedPrefix)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	return &TriplineDb{boltDb: db}, nil
+}
+
+// Open a throwaway Tripline database.
+// The database is backed by a temporary file in the temp directory which is removed when the database is closed.
+// It is meant for tests and for one-off verifications that do not need to keep a baseline.
+func OpenEphemeralTriplineDb() (*TriplineDb, error) {
+	f, err := ioutil.TempFile("", "tripline-*.db")
+	if err != nil {
+		return nil, err
+	}
+	tempPath := f.Name()
+	// Bolt opens the file itself, we only need a unique name.
+	err = f.Close()
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return nil, err
+	}
+	db, err := OpenTriplineDb(tempPath)
+	if err != nil {
+		_ = os.Remove(tempPath)
+		return nil, err
+	}
+	db.tempPath = tempPath
+	return db, nil
+}
+
+// Start the current transaction of the database.
 func (db *TriplineDb) Begin(write bool) error {
 	if db.boltTx != nil {
 		return fmt.Errorf(err090)
 	}
-	tx, err := db.boltDb.Begin(write)
+	tx, err := db.BeginTx(write)
 	if err != nil {
 		return err
 	}
-	db.boltTx = tx
+	db.TriplineTx = *tx
 	return nil
 }
 
-func (db *TriplineDb) Commit() error {
-	if db.boltTx == nil {
+// Start an independent transaction, it does not affect the current transaction of the database.
+func (db *TriplineDb) BeginTx(write bool) (*TriplineTx, error) {
+	tx, err := db.boltDb.Begin(write)
+	if err != nil {
+		return nil, err
+	}
+	return db.newTx(tx), nil
+}
+
+// The handle of a bolt transaction with the settings of the database.
+func (db *TriplineDb) newTx(tx *bolt.Tx) *TriplineTx {
+	return &TriplineTx{boltTx: tx, namespace: db.namespace, txSettings: db.txDefaults}
+}
+
+// Scope the filesets of the following transactions to the namespace, so several independent projects can share
+// a database. The reserved buckets are shared, the filesets are identified by their namespaced name.
+// An empty namespace selects the filesets without namespace.
+func (db *TriplineDb) SetNamespace(namespace string) error {
+	if db.IsReservedFileset(namespace) || HasNamespaceSeparator(namespace) {
+		return fmt.Errorf(err260, namespace)
+	}
+	db.namespace = namespace
+	return nil
+}
+
+func (tx *TriplineTx) Commit() (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
 		return fmt.Errorf(err080)
 	}
-	err := db.boltTx.Commit()
+	if tx.boltTx.Writable() {
+		err = tx.flushTreeRoots()
+		if err != nil {
+			_ = tx.boltTx.Rollback()
+			tx.boltTx = nil
+			return err
+		}
+	}
+	err = tx.boltTx.Commit()
 	// Whatever the outcome, remove the transaction
-	db.boltTx = nil
+	tx.boltTx = nil
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (db *TriplineDb) Rollback() error {
-	if db.boltTx == nil {
+func (tx *TriplineTx) Rollback() error {
+	if tx.boltTx == nil {
 		return fmt.Errorf(err080)
 	}
-	err := db.boltTx.Rollback()
+	err := tx.boltTx.Rollback()
 	// Whatever the outcome, remove the transaction.
-	db.boltTx = nil
+	tx.boltTx = nil
 	if err != nil {
 		return err
 	}
@@ -129,14 +545,65 @@ func (db *TriplineDb) Rollback() error {
 }
 
 // Close the tripline database.
-// It is necessary to close the database.
+// It is necessary to close the database. An ephemeral database is removed.
+// The independent transactions have to be finished before, closing waits for them.
 func (db *TriplineDb) Close() error {
 	if db.boltTx != nil {
 		return fmt.Errorf(err100)
 	}
+	if len(db.leasePath) > 0 {
+		// Removed while the lock is still held, it cannot remove the lease of the next holder.
+		_ = os.Remove(db.leasePath)
+	}
+	if db.boltDb != nil {
+		err := db.boltDb.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if len(db.tempPath) > 0 {
+		return os.Remove(db.tempPath)
+	}
+	return nil
+}
+
+// Release the database file so other processes can use it, e.g. between the verifications of a long running watch.
+// The settings of the database are kept, Reacquire opens the file again. There cannot be a current transaction.
+// A released database can only be reacquired or closed.
+func (db *TriplineDb) Release() error {
+	if db.boltTx != nil {
+		return fmt.Errorf(err100)
+	}
+	if db.boltDb == nil {
+		return nil
+	}
+	if len(db.leasePath) > 0 {
+		_ = os.Remove(db.leasePath)
+		db.leasePath = ""
+	}
+	err := db.boltDb.Close()
+	db.boltDb = nil
+	return err
+}
+
+// Open the file of a released database again with the options of the open, it waits for the lock like the open.
+// Other processes can have modified the filesets in the meantime. A database that was not released is left alone.
+func (db *TriplineDb) Reacquire() error {
 	if db.boltDb != nil {
-		return db.boltDb.Close()
+		return nil
+	}
+	var reopened *TriplineDb
+	var err error
+	if db.openOpts.ReadOnly {
+		reopened, err = openReadOnly(db.openPath, &db.openOpts)
+	} else {
+		reopened, err = openWritable(db.openPath, &db.openOpts)
+	}
+	if err != nil {
+		return err
 	}
+	db.boltDb = reopened.boltDb
+	db.leasePath = reopened.leasePath
 	return nil
 }
 
@@ -146,21 +613,66 @@ func (db *TriplineDb) Close() error {
 func (db *TriplineDb) HasTriplineRecord(path, fileset string) (bool, error) {
 	var hasTriplineRecord = false
 	err := db.boltDb.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket([]byte(fileset))
+		viewTx := db.newTx(tx)
+		bkt := tx.Bucket(db.key(fileset))
 		if bkt == nil {
 			return fmt.Errorf(err020, fileset)
 		}
-		hasTriplineRecord = nil != bkt.Get([]byte(path))
+		keys, err := viewTx.pathKeys(fileset)
+		if err != nil {
+			return err
+		}
+		hasTriplineRecord = nil != bkt.Get(keys.recordKey(path))
 		return nil
 	})
 	return hasTriplineRecord, err
 }
 
+// Check if the fileset exists in the tripline database.
+func (tx *TriplineTx) HasFileset(fileset string) (bool, error) {
+	if tx.boltTx == nil {
+		return false, fmt.Errorf(err080)
+	}
+	return tx.boltTx.Bucket(tx.key(fileset)) != nil, nil
+}
+
+// Fetch the record associated with the path in the fileset.
+// Returns nil if the fileset or the record does not exist.
+func (tx *TriplineTx) GetTriplineRecord(path, fileset string) (result *TriplineRecord, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
+	if bkt == nil {
+		return nil, nil
+	}
+	keys, err := tx.pathKeys(fileset)
+	if err != nil {
+		return nil, err
+	}
+	v := bkt.Get(keys.recordKey(path))
+	if v == nil {
+		return nil, nil
+	}
+	v, err = decodeValue(v)
+	if err != nil {
+		return nil, fmt.Errorf(err070, err)
+	}
+	rec := &TriplineRecord{}
+	err = json.Unmarshal(v, rec)
+	if err != nil {
+		return nil, fmt.Errorf(err070, err)
+	}
+	rec.EncPath = ""
+	return rec, nil
+}
+
 // Add a new record to the tripline database.
 // Returns an error if the record already exists, except if the overwrite flag is set, in that case the existing record will
 // be overwritten. The fileset is automatically created if it does not yet exists.
-func (db *TriplineDb) AddTriplineRecord(path string, rec *TriplineRecord, fileset string, overwrite bool) error {
-	if db.boltTx == nil || !db.boltTx.Writable() {
+func (tx *TriplineTx) AddTriplineRecord(path string, rec *TriplineRecord, fileset string, overwrite bool) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
 		return fmt.Errorf(err085)
 	}
 	// Create a json version of the record.
@@ -168,24 +680,74 @@ func (db *TriplineDb) AddTriplineRecord(path string, rec *TriplineRecord, filese
 	if err != nil {
 		return fmt.Errorf(err030, err)
 	}
+	return tx.putRecord(path, jsn, fileset, overwrite)
+}
+
+// Add a record in its stored json form, e.g. a record from an export, see ListRawTriplineRecords. The bytes are
+// stored as is so the fileset hash is reproduced, a re-marshalled record can differ in the order of the check data
+// fields. The key of a record of a fileset with hashed paths is the keyed hash of the path and the record has the
+// encrypted path, the path key is not needed.
+func (tx *TriplineTx) AddRawTriplineRecord(key string, jsn []byte, fileset string, overwrite bool) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	// Only accept valid records.
+	rec := &TriplineRecord{}
+	err = json.Unmarshal(jsn, rec)
+	if err != nil {
+		return fmt.Errorf(err070, err)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if meta.HashPaths != (len(rec.EncPath) > 0) {
+		return fmt.Errorf(err480, key, fileset)
+	}
+	return tx.putStored([]byte(key), jsn, fileset, overwrite)
+}
+
+func (tx *TriplineTx) putRecord(path string, jsn []byte, fileset string, overwrite bool) (err error) {
+	defer recoverCorrupt(&err)
+	keys, err := tx.pathKeys(fileset)
+	if err != nil {
+		return err
+	}
+	// The records of a fileset with hashed paths are re-marshalled to add the encrypted path.
+	jsn, err = keys.sealRecord(path, jsn)
+	if err != nil {
+		return fmt.Errorf(err350, fileset, err)
+	}
+	return tx.putStored(keys.recordKey(path), jsn, fileset, overwrite)
+}
 
-	bkt, err := db.boltTx.CreateBucketIfNotExists([]byte(fileset))
+// Store the record under the bolt key, the fileset is created if it does not exist.
+func (tx *TriplineTx) putStored(key []byte, jsn []byte, fileset string, overwrite bool) error {
+	bkt, err := tx.boltTx.CreateBucketIfNotExists(tx.key(fileset))
 	if err != nil {
 		return fmt.Errorf(err010, fileset, err)
 	}
 
-	key := []byte(path)
 	// If the path already exists and we are not forcing, we have an error.
 	// By default we do not overwrite existing entries.
 	if (bkt.Get(key) != nil) && !overwrite {
 		return RecordExists
 	}
 
-	// Write the entry to the database.
-	err = bkt.Put(key, []byte(jsn))
+	// Write the entry to the database, large records are compressed.
+	value, err := encodeValue(jsn, tx.compressThreshold)
+	if err != nil {
+		return fmt.Errorf(err030, err)
+	}
+	err = bkt.Put(key, value)
 	if err != nil {
 		return fmt.Errorf(err040, err)
 	}
+	err = tx.updateTreeLeaf(fileset, key, value)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
 
 	return nil
 }
@@ -193,12 +755,13 @@ func (db *TriplineDb) AddTriplineRecord(path string, rec *TriplineRecord, filese
 // Delete a record from the tripline database.
 // Returns an error if the database does not contain the record, except when the skip flag is set, then the function
 // will always succeed.
-func (db *TriplineDb) DeleteTriplineRecord(path string, fileset string, skip bool) error {
-	if db.boltTx == nil || !db.boltTx.Writable() {
+func (tx *TriplineTx) DeleteTriplineRecord(path string, fileset string, skip bool) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
 		return fmt.Errorf(err085)
 	}
 
-	bkt := db.boltTx.Bucket([]byte(fileset))
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
 	if bkt == nil {
 		if skip {
 			return nil
@@ -207,7 +770,11 @@ func (db *TriplineDb) DeleteTriplineRecord(path string, fileset string, skip boo
 		}
 	}
 
-	key := []byte(path)
+	keys, err := tx.pathKeys(fileset)
+	if err != nil {
+		return err
+	}
+	key := keys.recordKey(path)
 
 	// If the path already exists and we are not forcing, we have an error.
 	// By default we do not overwrite existing entries.
@@ -215,62 +782,139 @@ func (db *TriplineDb) DeleteTriplineRecord(path string, fileset string, skip boo
 		return fmt.Errorf(err050, path, fileset)
 	}
 
-	err := bkt.Delete(key)
+	err = bkt.Delete(key)
 	if err != nil {
 		return fmt.Errorf(err060, err)
 	}
+	err = tx.updateTreeLeaf(fileset, key, nil)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
 	return nil
 }
 
 // List the contents of a fileset.
 // Returns an error if the fileset does not exist.
-func (db *TriplineDb) ListTriplineRecords(fileset string) ([]TriplineEntry, error) {
-	return db.QueryTriplineRecords(fileset, "")
+func (tx *TriplineTx) ListTriplineRecords(fileset string) ([]TriplineEntry, error) {
+	return tx.QueryTriplineRecords(fileset, "")
 }
 
 // List the contents of a fileset, return the entries that match the given path prefix.
 // Returns an error if the fileset does not exist.
 // This is an easy way to query the subdirectories an files when the prefix is a directory path.
-func (db *TriplineDb) QueryTriplineRecords(fileset string, pathPrefix string) ([]TriplineEntry, error) {
-	if db.boltTx == nil {
+func (tx *TriplineTx) QueryTriplineRecords(fileset string, pathPrefix string) (result []TriplineEntry, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
 		return nil, fmt.Errorf(err080)
 	}
 
-	result := make([]TriplineEntry, 0)
+	result = make([]TriplineEntry, 0)
 
 	// Dig up the bucket
-	bkt := db.boltTx.Bucket([]byte(fileset))
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
 	if bkt == nil {
 		return nil, fmt.Errorf(err020, fileset)
 	}
+	keys, err := tx.pathKeys(fileset)
+	if err != nil {
+		return nil, err
+	}
 	// Loop over the bucket
 	c := bkt.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
 		p := string(k)
-		if strings.HasPrefix(p, pathPrefix) {
+		// The hashed paths have no prefix relation, all the records are decrypted.
+		if keys != nil || MatchesPathPrefix(p, pathPrefix) {
 			entry := &TriplineEntry{}
 			entry.Path = p
-			err := json.Unmarshal(v, &entry.Record)
+			v, err := decodeValue(v)
 			if err != nil {
 				return nil, fmt.Errorf(err070, err)
 			}
+			err = json.Unmarshal(v, &entry.Record)
+			if err != nil {
+				return nil, fmt.Errorf(err070, err)
+			}
+			if keys != nil {
+				entry.Path, err = keys.openPath(entry.Record.EncPath)
+				if err != nil {
+					return nil, fmt.Errorf(err350, fileset, err)
+				}
+				entry.Record.EncPath = ""
+				if !MatchesPathPrefix(entry.Path, pathPrefix) {
+					continue
+				}
+			}
 			result = append(result, *entry)
 		}
 	}
+	if keys != nil {
+		// The same order as the plain paths.
+		sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	}
+	return result, nil
+}
+
+// List the contents of a fileset with the records in their stored json form, e.g. for an export that reproduces the
+// fileset hash when it is imported, see AddRawTriplineRecord. The records of a fileset with hashed paths are listed by
+// the keyed hash of their path and keep their encrypted path, the encryption is randomized and a re-encrypted record
+// would not reproduce the hash. The path key is not needed.
+// Returns an error if the fileset does not exist.
+func (tx *TriplineTx) ListRawTriplineRecords(fileset string) (result []RawTriplineEntry, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
+	if bkt == nil {
+		return nil, fmt.Errorf(err020, fileset)
+	}
+	result = make([]RawTriplineEntry, 0)
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		v, err := decodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf(err070, err)
+		}
+		// The bolt values are only valid during the transaction.
+		result = append(result, RawTriplineEntry{append([]byte{}, v...), string(k)})
+	}
 	return result, nil
 }
 
+// A path matches the prefix if it is the prefix itself or a path below it, "/a" matches "/a" and "/a/b" but not
+// "/ab". A prefix that ends with a separator matches the paths below it, the empty prefix matches all the paths.
+func MatchesPathPrefix(path string, prefix string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	if len(path) == len(prefix) || len(prefix) == 0 || os.IsPathSeparator(prefix[len(prefix)-1]) {
+		return true
+	}
+	return os.IsPathSeparator(path[len(prefix)])
+}
+
 // List the filesets in the tripline database.
-func (db *TriplineDb) ListFilesets() ([]string, error) {
-	if db.boltTx == nil {
+func (tx *TriplineTx) ListFilesets() (result []string, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
 		return nil, fmt.Errorf(err080)
 	}
-	result := make([]string, 0)
-	err := db.boltTx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+	result = make([]string, 0)
+	prefix := ""
+	if len(tx.namespace) > 0 {
+		prefix = tx.namespace + namespaceSeparator
+	}
+	err = tx.boltTx.ForEach(func(name []byte, _ *bolt.Bucket) error {
 		bucketName := string(name)
-		// Bucket names starting with underscores are reserved names for internal use.
-		// Example _signatures bucket to store the fileset signatures.
-		if !strings.HasPrefix(bucketName, "_") {
+		// The reserved buckets are for internal use, e.g. the _signatures bucket stores the fileset signatures.
+		if isReservedName(tx.reservedPrefix, bucketName) {
+			return nil
+		}
+		// Only the filesets of the namespace.
+		if len(prefix) > 0 && strings.HasPrefix(bucketName, prefix) {
+			result = append(result, strings.TrimPrefix(bucketName, prefix))
+		} else if len(prefix) == 0 && !strings.Contains(bucketName, namespaceSeparator) {
 			result = append(result, bucketName)
 		}
 		return nil
@@ -281,35 +925,69 @@ func (db *TriplineDb) ListFilesets() ([]string, error) {
 	return result, nil
 }
 
-// Delete a fileset from teh tripline database.
+// Delete a fileset from teh tripline database, with its settings, signature, signature snapshots and verification
+// history. A new fileset with the same name does not inherit them.
 // Returns an error if the fileset does not exist.
-func (db *TriplineDb) DeleteFileset(fileset string) error {
-	if db.boltTx == nil || !db.boltTx.Writable() {
+func (tx *TriplineTx) DeleteFileset(fileset string) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
 		return fmt.Errorf(err085)
 	}
 
-	bkt := db.boltTx.Bucket([]byte(fileset))
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
 	if bkt == nil {
 		return fmt.Errorf(err020, fileset)
 	}
-	return db.boltTx.DeleteBucket([]byte(fileset))
+	if metaBkt := tx.boltTx.Bucket(tx.reserved(metabucket)); metaBkt != nil {
+		err := metaBkt.Delete(tx.key(fileset))
+		if err != nil {
+			return fmt.Errorf(err240, fileset, err)
+		}
+	}
+	if signaturesBkt := tx.boltTx.Bucket(tx.reserved(sigbucket)); signaturesBkt != nil {
+		err := signaturesBkt.Delete(tx.key(fileset))
+		if err != nil {
+			return fmt.Errorf(err490, fileset, err)
+		}
+	}
+	for _, bucket := range []string{snapbucket, historybucket} {
+		err := tx.deleteNestedBucket(bucket, fileset)
+		if err != nil {
+			return fmt.Errorf(err490, fileset, err)
+		}
+	}
+	err = tx.deleteTree(fileset)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	return tx.boltTx.DeleteBucket(tx.key(fileset))
 }
 
-// Copy the contents of an existing fileset to a new fileset with a new name.
+// Delete the bucket of a fileset in one of the internal buckets that keep a nested bucket per fileset.
+func (tx *TriplineTx) deleteNestedBucket(bucket string, fileset string) error {
+	parentBkt := tx.boltTx.Bucket(tx.reserved(bucket))
+	if parentBkt == nil || parentBkt.Bucket(tx.key(fileset)) == nil {
+		return nil
+	}
+	return parentBkt.DeleteBucket(tx.key(fileset))
+}
+
+// Copy the contents of an existing fileset to a new fileset with a new name, including its settings and signature.
 // The existing fileset must exist, the new fileset should not yet exist.
-func (db *TriplineDb) CopyFileset(src, target string) error {
-	if db.boltTx == nil || !db.boltTx.Writable() {
+func (tx *TriplineTx) CopyFileset(src, target string) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
 		return fmt.Errorf(err085)
 	}
 
 	// Dig up the source bucket
-	srcBkt := db.boltTx.Bucket([]byte(src))
+	srcBkt := tx.boltTx.Bucket(tx.key(src))
 	if srcBkt == nil {
 		return fmt.Errorf(err020, src)
 	}
 
 	// Create target bucket
-	targetBkt, err := db.boltTx.CreateBucket([]byte(target))
+	targetBkt, err := tx.boltTx.CreateBucket(tx.key(target))
 	if err != nil {
 		return fmt.Errorf(err110, target, err)
 	}
@@ -322,118 +1000,558 @@ func (db *TriplineDb) CopyFileset(src, target string) error {
 			return fmt.Errorf(err120, target, err)
 		}
 	}
+
+	// The copy has the same settings.
+	if metaBkt := tx.boltTx.Bucket(tx.reserved(metabucket)); metaBkt != nil {
+		if meta := metaBkt.Get(tx.key(src)); meta != nil {
+			err := metaBkt.Put(tx.key(target), meta)
+			if err != nil {
+				return fmt.Errorf(err120, target, err)
+			}
+		}
+	}
+	// The signature is over the contents, it is valid for the copy as well.
+	if signaturesBkt := tx.boltTx.Bucket(tx.reserved(sigbucket)); signaturesBkt != nil {
+		if signature := signaturesBkt.Get(tx.key(src)); signature != nil {
+			err := signaturesBkt.Put(tx.key(target), signature)
+			if err != nil {
+				return fmt.Errorf(err120, target, err)
+			}
+		}
+	}
+	err = tx.copyTree(src, target)
+	if err != nil {
+		return fmt.Errorf(err120, target, err)
+	}
 	return nil
 }
 
-// Create a signature of the fileset contents and store it in a special _signatures bucket.
-func (db *TriplineDb) SignFileset(fileset string, password string, update bool) error {
-	if db.boltTx == nil || !db.boltTx.Writable() {
+// Rename a fileset, the records are copied to the new name and the old fileset is deleted in the same transaction.
+// The signature, the signature snapshots and the verification history move with the fileset.
+// The fileset must exist, the new name should not yet exist.
+func (tx *TriplineTx) RenameFileset(src, target string) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
 		return fmt.Errorf(err085)
 	}
+	if tx.boltTx.Bucket(tx.key(target)) != nil {
+		return fmt.Errorf(err440, target)
+	}
+
+	err = tx.CopyFileset(src, target)
+	if err != nil {
+		return err
+	}
+	for _, bucket := range []string{snapbucket, historybucket} {
+		err := tx.moveNestedBucket(bucket, src, target)
+		if err != nil {
+			return fmt.Errorf(err450, src, err)
+		}
+	}
+	return tx.DeleteFileset(src)
+}
+
+// Move the bucket of a fileset in one of the internal buckets that keep a nested bucket per fileset.
+func (tx *TriplineTx) moveNestedBucket(bucket string, src, target string) error {
+	parentBkt := tx.boltTx.Bucket(tx.reserved(bucket))
+	if parentBkt == nil {
+		return nil
+	}
+	srcBkt := parentBkt.Bucket(tx.key(src))
+	if srcBkt == nil {
+		return nil
+	}
+	targetBkt, err := parentBkt.CreateBucket(tx.key(target))
+	if err != nil {
+		return err
+	}
+	err = srcBkt.ForEach(func(k, v []byte) error {
+		return targetBkt.Put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	return parentBkt.DeleteBucket(tx.key(src))
+}
+
+// Create a signature of the fileset contents and store it in a special _signatures bucket.
+func (tx *TriplineTx) SignFileset(fileset string, password string, update bool) (*SignatureInfo, error) {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return nil, fmt.Errorf(err085)
+	}
 
 	// Fetch the signature bucket. Or create it if it does not yet exists.
-	signaturesBkt, err := db.boltTx.CreateBucketIfNotExists([]byte(sigbucket))
+	signaturesBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(sigbucket))
 	if err != nil {
-		return fmt.Errorf(err130, err)
+		return nil, fmt.Errorf(err130, err)
 	}
 
 	// Fetch the signature.
 	// The user has to explicitly overwrite the signature using the --overwrite option.
-	oldSignature := signaturesBkt.Get([]byte(fileset))
+	oldSignature := signaturesBkt.Get(tx.key(fileset))
 	if oldSignature != nil && !update {
-		return fmt.Errorf(err140, fileset)
+		return nil, fmt.Errorf(err140, fileset)
 	}
 
-	// Dig up the fileset bucket.
-	srcBkt := db.boltTx.Bucket([]byte(fileset))
-	if srcBkt == nil {
-		return fmt.Errorf(err020, fileset)
+	info, err := tx.CreateFilesetSignature(fileset, password)
+	if err != nil {
+		return nil, err
 	}
 
-	// Calculate fileset bucket hash.
-	hash, err := calcBucketHash(srcBkt)
+	// Store the signature in the _signatures bucket.
+	err = signaturesBkt.Put(tx.key(fileset), info.Signature)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	log.Printf("hash: %x", hash)
+	return info, nil
+}
 
-	// Calculate the signature using the filest bucket contents.
-	signature, err := crypto.Encrypt([]byte(password), hash)
+// Store a signature that was created before, e.g. a detached signature that was verified against the fileset.
+// An existing signature is replaced.
+func (tx *TriplineTx) StoreFilesetSignature(fileset string, signature []byte) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	signaturesBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(sigbucket))
 	if err != nil {
-		return fmt.Errorf(err150, fileset, err)
+		return fmt.Errorf(err130, err)
 	}
-	log.Printf("signature: %x", signature)
-
-	// Store the signature in the _signatures bucket.
-	signaturesBkt.Put([]byte(fileset), signature)
-	return nil
+	return signaturesBkt.Put(tx.key(fileset), signature)
 }
 
-// Verify if the validity of the existing fileset signature.
-// First we decrypt the signature and compare the hash that was calculated at the time of signing to the current hash.
-// If any intermediary steps fail the process fails, it might be the result of tampering.
-func (db *TriplineDb) VerifyFilesetSignature(fileset string, password string) error {
-	if db.boltTx == nil {
-		return fmt.Errorf(err080)
+// Create a signature of the fileset contents without storing it.
+// The signature contains the encryption nonce and key derivation salt, it is self contained.
+func (tx *TriplineTx) CreateFilesetSignature(fileset string, password string) (*SignatureInfo, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
 	}
 
 	// Dig up the fileset bucket.
-	srcBkt := db.boltTx.Bucket([]byte(fileset))
+	srcBkt := tx.boltTx.Bucket(tx.key(fileset))
 	if srcBkt == nil {
-		return fmt.Errorf(err020, fileset)
+		return nil, fmt.Errorf(err020, fileset)
 	}
 
-	// Calculate the actual bucket hash.
-	hash, err := calcBucketHash(srcBkt)
+	// Calculate fileset bucket hash.
+	hash, err := tx.filesetHash(fileset, srcBkt, CurrentHashVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Calculate the signature using the filest bucket contents.
+	signature, err := crypto.Encrypt([]byte(password), signedPayload(CurrentHashVersion, hash))
 	if err != nil {
-		return fmt.Errorf(err160, fileset, err)
+		return nil, fmt.Errorf(err150, fileset, err)
+	}
+	return &SignatureInfo{Fileset: fileset, Hash: hash, Version: CurrentHashVersion, Signature: signature}, nil
+}
+
+// List the filesets that have a stored signature.
+func (tx *TriplineTx) ListSignedFilesets() ([]string, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	result := make([]string, 0)
+	signaturesBkt := tx.boltTx.Bucket(tx.reserved(sigbucket))
+	if signaturesBkt == nil {
+		return result, nil
+	}
+	prefix := ""
+	if len(tx.namespace) > 0 {
+		prefix = tx.namespace + namespaceSeparator
+	}
+	err := signaturesBkt.ForEach(func(k, _ []byte) error {
+		fileset := string(k)
+		// Only the filesets of the namespace.
+		if len(prefix) > 0 && strings.HasPrefix(fileset, prefix) {
+			result = append(result, strings.TrimPrefix(fileset, prefix))
+		} else if len(prefix) == 0 && !strings.Contains(fileset, namespaceSeparator) {
+			result = append(result, fileset)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Fetch the stored signature of a fileset.
+func (tx *TriplineTx) FilesetSignature(fileset string) ([]byte, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
 	}
 
 	// Fetch the signature bucket.
 	// An attacker might have removed the bucket it might indicate tampering.
 	// If the user never created a signature, the bucket does not exist either.
-	signaturesBkt := db.boltTx.Bucket([]byte(sigbucket))
+	signaturesBkt := tx.boltTx.Bucket(tx.reserved(sigbucket))
 	if signaturesBkt == nil {
-		return fmt.Errorf(err170)
+		return nil, fmt.Errorf(err170)
 	}
 
 	// Fetch the signature.
 	// An attacker might have removed the fileset's signature. It might indicate tampering.
 	// The user might never have created a signature for the fileset.
-	oldSignature := signaturesBkt.Get([]byte(fileset))
-	if oldSignature == nil {
-		return fmt.Errorf(err180)
+	signature := signaturesBkt.Get(tx.key(fileset))
+	if signature == nil {
+		return nil, fmt.Errorf(err180)
+	}
+	// The slice is only valid during the transaction.
+	return append([]byte(nil), signature...), nil
+}
+
+// Verify if the validity of the existing fileset signature.
+// First we decrypt the signature and compare the hash that was calculated at the time of signing to the current hash.
+// If any intermediary steps fail the process fails, it might be the result of tampering.
+func (tx *TriplineTx) VerifyFilesetSignature(fileset string, password string) error {
+	signature, err := tx.FilesetSignature(fileset)
+	if err != nil {
+		return err
+	}
+	return tx.VerifyFilesetSignatureWith(fileset, password, signature)
+}
+
+// Verify the fileset against a signature that is provided by the caller, e.g. a detached signature.
+func (tx *TriplineTx) VerifyFilesetSignatureWith(fileset string, password string, signature []byte) error {
+	_, err := tx.verifySignature(fileset, password, signature)
+	return err
+}
+
+// Verify the fileset against the signature, the hash is calculated with the version of the signature.
+// Returns the hash version of the signature.
+func (tx *TriplineTx) verifySignature(fileset string, password string, signature []byte) (int, error) {
+	if tx.boltTx == nil {
+		return 0, fmt.Errorf(err080)
+	}
+
+	// Dig up the fileset bucket.
+	srcBkt := tx.boltTx.Bucket(tx.key(fileset))
+	if srcBkt == nil {
+		return 0, fmt.Errorf(err020, fileset)
 	}
 
 	// The old hash cannot be reconstructed from the signature.
 	// An attacker might have replaced the signature with another one.
 	// The user might have forgotten the password.
-	plain, err := crypto.Decrypt([]byte(password), oldSignature)
+	plain, err := crypto.Decrypt([]byte(password), signature)
+	if err != nil {
+		return 0, fmt.Errorf(err190, err)
+	}
+	version, signedHash, err := parseSignedPayload(plain)
+	if err != nil {
+		return 0, fmt.Errorf(err190, err)
+	}
+
+	// Calculate the actual bucket hash.
+	hash, err := tx.filesetHash(fileset, srcBkt, version)
 	if err != nil {
-		return fmt.Errorf(err190, err)
+		return 0, fmt.Errorf(err160, fileset, err)
 	}
 
 	// Compare the old hash from the signature with the newly calculated one.
 	// The fileset might be tampered.
 	// The user might have changed the fileset without creating a new signature.
-	if bytes.Compare(plain, hash) != 0 {
-		return fmt.Errorf(err200)
+	// The lengths are checked explicitly, the comparison takes the same time wherever the hashes differ.
+	if len(signedHash) != len(hash) {
+		return 0, fmt.Errorf(err190, fmt.Errorf(err430, len(signedHash), len(hash)))
+	}
+	if subtle.ConstantTimeCompare(signedHash, hash) != 1 {
+		return 0, fmt.Errorf(err200)
+	}
+	return version, nil
+}
+
+// Replace the stored signature of the fileset by a signature with the current hash version. The stored signature
+// is verified first, a fileset that fails the verification is not re-signed. Returns the hash version of the stored
+// signature, the signature is not replaced if it already has the current version.
+func (tx *TriplineTx) ResignFileset(fileset string, password string) (int, error) {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return 0, fmt.Errorf(err085)
+	}
+	signature, err := tx.FilesetSignature(fileset)
+	if err != nil {
+		return 0, fmt.Errorf(err320, fileset, err)
+	}
+	version, err := tx.verifySignature(fileset, password, signature)
+	if err != nil {
+		return 0, fmt.Errorf(err320, fileset, err)
 	}
+	if version == CurrentHashVersion {
+		return version, nil
+	}
+	_, err = tx.SignFileset(fileset, password, true)
+	if err != nil {
+		return 0, fmt.Errorf(err320, fileset, err)
+	}
+	return version, nil
+}
+
+// Calculate the sha256 hash of the fileset contents, the same hash that is protected by the new signatures.
+func (tx *TriplineTx) FilesetHash(fileset string) ([]byte, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
+	if bkt == nil {
+		return nil, fmt.Errorf(err020, fileset)
+	}
+	hash, err := tx.filesetHash(fileset, bkt, CurrentHashVersion)
+	if err != nil {
+		return nil, fmt.Errorf(err160, fileset, err)
+	}
+	return hash, nil
+}
+
+// Calculate the sha256 hash of each record in the fileset.
+// Returns a map from path to hex encoded hash.
+func (tx *TriplineTx) FilesetRecordHashes(fileset string) (result map[string]string, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	bkt := tx.boltTx.Bucket(tx.key(fileset))
+	if bkt == nil {
+		return nil, fmt.Errorf(err020, fileset)
+	}
+	keys, err := tx.pathKeys(fileset)
+	if err != nil {
+		return nil, err
+	}
+	result = make(map[string]string)
+	c := bkt.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		// The hashes do not depend on the compression.
+		jsn, err := decodeValue(v)
+		if err != nil {
+			return nil, fmt.Errorf(err070, err)
+		}
+		p := string(k)
+		if keys != nil {
+			rec := &TriplineRecord{}
+			err := json.Unmarshal(jsn, rec)
+			if err != nil {
+				return nil, fmt.Errorf(err070, err)
+			}
+			p, err = keys.openPath(rec.EncPath)
+			if err != nil {
+				return nil, fmt.Errorf(err350, fileset, err)
+			}
+		}
+		result[p] = fmt.Sprintf("%x", sha256.Sum256(jsn))
+	}
+	return result, nil
+}
+
+// Store a snapshot of the record hashes of the fileset.
+// The snapshots are kept in the _snapshots bucket, one nested bucket per fileset with a key per snapshot.
+// Returns the timestamp that identifies the snapshot.
+func (tx *TriplineTx) SaveFilesetSnapshot(fileset string) (string, error) {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return "", fmt.Errorf(err085)
+	}
+	hashes, err := tx.FilesetRecordHashes(fileset)
+	if err != nil {
+		return "", err
+	}
+	jsn, err := json.Marshal(hashes)
+	if err != nil {
+		return "", fmt.Errorf(err220, fileset, err)
+	}
+	snapshotsBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(snapbucket))
+	if err != nil {
+		return "", fmt.Errorf(err220, fileset, err)
+	}
+	filesetBkt, err := snapshotsBkt.CreateBucketIfNotExists(tx.key(fileset))
+	if err != nil {
+		return "", fmt.Errorf(err220, fileset, err)
+	}
+	ts := time.Now().UTC().Format(snapFormat)
+	err = filesetBkt.Put([]byte(ts), jsn)
+	if err != nil {
+		return "", fmt.Errorf(err220, fileset, err)
+	}
+	return ts, nil
+}
+
+// List the timestamps of the snapshots of a fileset, oldest first.
+func (tx *TriplineTx) ListFilesetSnapshots(fileset string) ([]string, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	result := make([]string, 0)
+	snapshotsBkt := tx.boltTx.Bucket(tx.reserved(snapbucket))
+	if snapshotsBkt == nil {
+		return result, nil
+	}
+	filesetBkt := snapshotsBkt.Bucket(tx.key(fileset))
+	if filesetBkt == nil {
+		return result, nil
+	}
+	err := filesetBkt.ForEach(func(k, _ []byte) error {
+		result = append(result, string(k))
+		return nil
+	})
+	return result, err
+}
+
+// Fetch a snapshot of the record hashes of a fileset.
+func (tx *TriplineTx) GetFilesetSnapshot(fileset string, ts string) (map[string]string, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	var jsn []byte
+	if snapshotsBkt := tx.boltTx.Bucket(tx.reserved(snapbucket)); snapshotsBkt != nil {
+		if filesetBkt := snapshotsBkt.Bucket(tx.key(fileset)); filesetBkt != nil {
+			jsn = filesetBkt.Get([]byte(ts))
+		}
+	}
+	if jsn == nil {
+		return nil, fmt.Errorf(err230, ts, fileset)
+	}
+	result := make(map[string]string)
+	err := json.Unmarshal(jsn, &result)
+	if err != nil {
+		return nil, fmt.Errorf(err230, ts, fileset)
+	}
+	return result, nil
+}
+
+// Fetch the settings of a fileset, the default settings if none were stored.
+func (tx *TriplineTx) GetFilesetMeta(fileset string) (*FilesetMeta, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	meta := &FilesetMeta{}
+	metaBkt := tx.boltTx.Bucket(tx.reserved(metabucket))
+	if metaBkt == nil {
+		return meta, nil
+	}
+	jsn := metaBkt.Get(tx.key(fileset))
+	if jsn == nil {
+		return meta, nil
+	}
+	err := json.Unmarshal(jsn, meta)
+	if err != nil {
+		return nil, fmt.Errorf(err240, fileset, err)
+	}
+	return meta, nil
+}
 
-	log.Printf("Integrity fileset %q is ok.", fileset)
+// Store the settings of a fileset in the _meta bucket.
+func (tx *TriplineTx) SaveFilesetMeta(fileset string, meta *FilesetMeta) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	jsn, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf(err240, fileset, err)
+	}
+	metaBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(metabucket))
+	if err != nil {
+		return fmt.Errorf(err240, fileset, err)
+	}
+	err = metaBkt.Put(tx.key(fileset), jsn)
+	if err != nil {
+		return fmt.Errorf(err240, fileset, err)
+	}
+	return nil
+}
+
+// Append a verification to the history of the fileset, the timestamp of the run is set.
+// The history is kept in the _verifyhistory bucket, one nested bucket per fileset with a key per run.
+func (tx *TriplineTx) AppendVerifyRun(fileset string, run *VerifyRun) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	run.Timestamp = time.Now().UTC().Format(snapFormat)
+	jsn, err := json.Marshal(run)
+	if err != nil {
+		return fmt.Errorf(err250, fileset, err)
+	}
+	historyBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(historybucket))
+	if err != nil {
+		return fmt.Errorf(err250, fileset, err)
+	}
+	filesetBkt, err := historyBkt.CreateBucketIfNotExists(tx.key(fileset))
+	if err != nil {
+		return fmt.Errorf(err250, fileset, err)
+	}
+	err = filesetBkt.Put([]byte(run.Timestamp), jsn)
+	if err != nil {
+		return fmt.Errorf(err250, fileset, err)
+	}
 	return nil
 }
 
+// List the verification history of a fileset, oldest first.
+func (tx *TriplineTx) ListVerifyRuns(fileset string) ([]VerifyRun, error) {
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	result := make([]VerifyRun, 0)
+	historyBkt := tx.boltTx.Bucket(tx.reserved(historybucket))
+	if historyBkt == nil {
+		return result, nil
+	}
+	filesetBkt := historyBkt.Bucket(tx.key(fileset))
+	if filesetBkt == nil {
+		return result, nil
+	}
+	err := filesetBkt.ForEach(func(_, v []byte) error {
+		run := VerifyRun{}
+		err := json.Unmarshal(v, &run)
+		if err != nil {
+			return fmt.Errorf(err250, fileset, err)
+		}
+		result = append(result, run)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
 // Calculate sha256 of the contents of a bucket. Both keys and values are taken into account.
-func calcBucketHash(srcBkt *bolt.Bucket) ([]byte, error) {
+// The version selects how the keys and values are fed to the hash, see HashVersion2. From version 3 on the hash
+// starts with a header and the policy of the fileset, see HashVersion3.
+func calcBucketHash(srcBkt *bolt.Bucket, version int, policy []byte) (result []byte, err error) {
+	defer recoverCorrupt(&err)
+	if version < HashVersion1 || version > CurrentHashVersion {
+		return nil, fmt.Errorf(err310, version)
+	}
 	h := sha256.New()
+	if version >= HashVersion3 {
+		err := writeFramed(h, []byte(hashHeader))
+		if err == nil {
+			err = writeFramed(h, policy)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	write := func(b []byte) error {
+		_, err := h.Write(b)
+		return err
+	}
+	if version >= HashVersion2 {
+		write = func(b []byte) error {
+			return writeFramed(h, b)
+		}
+	}
 	c := srcBkt.Cursor()
 	for k, v := c.First(); k != nil; k, v = c.Next() {
-		_, err := h.Write(k)
+		err := write(k)
+		if err != nil {
+			return nil, err
+		}
+		// The hash does not depend on the compression, the signatures remain valid.
+		v, err := decodeValue(v)
 		if err != nil {
 			return nil, err
 		}
-		_, err = h.Write(v)
+		err = write(v)
 		if err != nil {
 			return nil, err
 		}
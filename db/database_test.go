@@ -0,0 +1,365 @@
+package db_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Add an empty file record for each of the paths.
+func addTestRecords(t *testing.T, tripDb *db.TriplineDb, fileset string, paths ...string) {
+	t.Helper()
+	for _, path := range paths {
+		rec := &db.TriplineRecord{Type: "regular", Checks: []string{}, Data: map[string]interface{}{}}
+		err := tripDb.AddTriplineRecord(path, rec, fileset, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestMatchesPathPrefix(t *testing.T) {
+	tests := []struct {
+		path   string
+		prefix string
+		want   bool
+	}{
+		{"/a", "/a", true},
+		{"/a/b", "/a", true},
+		{"/a/b", "/a/", true},
+		{"/ab", "/a", false},
+		{"/ab/c", "/a", false},
+		{"/a", "/ab", false},
+		{"/a", "", true},
+		{"/home/user/documents-backup", "/home/user/doc", false},
+	}
+	for _, test := range tests {
+		got := db.MatchesPathPrefix(test.path, test.prefix)
+		if got != test.want {
+			t.Errorf("MatchesPathPrefix(%q, %q) = %v, want %v", test.path, test.prefix, got, test.want)
+		}
+	}
+}
+
+func TestQueryTriplineRecordsPathBoundary(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "test", "/a", "/a/x", "/ab", "/ab/y")
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		// A directory matches itself and the paths below it, not its siblings with a longer name.
+		{"/a", []string{"/a", "/a/x"}},
+		{"/ab", []string{"/ab", "/ab/y"}},
+		// The prefix equals a stored key of a file.
+		{"/a/x", []string{"/a/x"}},
+		{"/a/", []string{"/a/x"}},
+		{"/a/xy", []string{}},
+		{"", []string{"/a", "/a/x", "/ab", "/ab/y"}},
+	}
+	for _, test := range tests {
+		entries, err := tripDb.QueryTriplineRecords("test", test.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			got = append(got, entry.Path)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("query %q = %v, want %v", test.prefix, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("query %q = %v, want %v", test.prefix, got, test.want)
+				break
+			}
+		}
+	}
+}
+
+func TestCopyFilesetSignature(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signatures takes seconds")
+	}
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "src", "/a", "/a/x")
+
+	_, err := tripDb.SignFileset("src", "secret", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.CopyFileset("src", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.VerifyFilesetSignature("copy", "secret")
+	if err != nil {
+		t.Fatalf("verify the signature of the copy: %v", err)
+	}
+
+	// The signature is over the contents, a change of the copy invalidates it.
+	addTestRecords(t, tripDb, "copy", "/b")
+	err = tripDb.VerifyFilesetSignature("copy", "secret")
+	if err == nil {
+		t.Fatal("the signature of the modified copy verified")
+	}
+}
+
+func TestCopyFilesetUnsigned(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "src", "/a")
+
+	err := tripDb.CopyFileset("src", "copy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tripDb.FilesetSignature("copy")
+	if err == nil {
+		t.Fatal("the copy of an unsigned fileset has a signature")
+	}
+}
+
+func TestRenameFileset(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "src", "/a", "/a/x")
+	addTestRecords(t, tripDb, "other", "/b")
+	err := tripDb.SaveFilesetMeta("src", &db.FilesetMeta{Resolve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.AppendVerifyRun("src", &db.VerifyRun{Entries: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An existing fileset is not replaced.
+	err = tripDb.RenameFileset("src", "other")
+	if err == nil || err.Error() != "(db/440) fileset \"other\" exists" {
+		t.Errorf("rename to an existing fileset: got error %v, want the exists error", err)
+	}
+
+	// The records, the settings and the history move to the new name.
+	err = tripDb.RenameFileset("src", "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exists, err := tripDb.HasFileset("src")
+	if err != nil || exists {
+		t.Errorf("the renamed fileset still exists: %v", err)
+	}
+	entries, err := tripDb.ListTriplineRecords("target")
+	if err != nil || len(entries) != 2 {
+		t.Errorf("renamed fileset has %d records: %v", len(entries), err)
+	}
+	meta, err := tripDb.GetFilesetMeta("target")
+	if err != nil || !meta.Resolve {
+		t.Errorf("renamed fileset lost its settings: %+v, %v", meta, err)
+	}
+	runs, err := tripDb.ListVerifyRuns("target")
+	if err != nil || len(runs) != 1 {
+		t.Errorf("renamed fileset has %d verify runs: %v", len(runs), err)
+	}
+	runs, err = tripDb.ListVerifyRuns("src")
+	if err != nil || len(runs) != 0 {
+		t.Errorf("the old name kept %d verify runs: %v", len(runs), err)
+	}
+}
+
+func TestDeleteFilesetSnapshots(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "test", "/a")
+	_, err := tripDb.SaveFilesetSnapshot("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.AppendVerifyRun("test", &db.VerifyRun{Entries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A new fileset with the same name starts without the snapshots and the history of the deleted one.
+	err = tripDb.DeleteFileset("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, tripDb, "test", "/b")
+	snapshots, err := tripDb.ListFilesetSnapshots("test")
+	if err != nil || len(snapshots) != 0 {
+		t.Errorf("the new fileset has the snapshots %v: %v", snapshots, err)
+	}
+	runs, err := tripDb.ListVerifyRuns("test")
+	if err != nil || len(runs) != 0 {
+		t.Errorf("the new fileset has %d verify runs: %v", len(runs), err)
+	}
+}
+
+func TestFilesetNamespaceSeparator(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	addTestRecords(t, tripDb, "b", "/a")
+
+	// A name with the separator does not reach the fileset "b" of the namespace "a", nor create a bucket.
+	err := tripDb.SetNamespace("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, tripDb, "b", "/x")
+	err = tripDb.SetNamespace("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec := &db.TriplineRecord{Type: "regular", Checks: []string{}, Data: map[string]interface{}{}}
+	if tripDb.AddTriplineRecord("/y", rec, "a/b", false) == nil {
+		t.Error("record added to fileset \"a/b\"")
+	}
+	if found, _ := tripDb.GetTriplineRecord("/x", "a/b"); found != nil {
+		t.Error("fileset \"a/b\" reads the fileset \"b\" of namespace \"a\"")
+	}
+	filesets, err := tripDb.ListFilesets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesets) != 1 || filesets[0] != "b" {
+		t.Errorf("filesets %v, want [b]", filesets)
+	}
+}
+
+func TestTransactionSettings(t *testing.T) {
+	tripDb, err := db.OpenTriplineDbWith(filepath.Join(t.TempDir(), "tripline.db"), &db.OpenOptions{ReservedPrefix: "#"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tripDb.Close()
+
+	// The current transaction and the independent ones use the prefix of the database.
+	err = tripDb.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addTestRecords(t, tripDb, "a", "/x")
+	err = tripDb.SaveFilesetMeta("a", &db.FilesetMeta{Resolve: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.Commit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := tripDb.BeginTx(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	filesets, err := tx.ListFilesets()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filesets) != 1 || filesets[0] != "a" {
+		t.Errorf("filesets %v, want [a] without the reserved buckets", filesets)
+	}
+	if !tripDb.IsReservedFileset("#meta") || tripDb.IsReservedFileset("_meta") {
+		t.Error("the reserved names do not use the prefix of the database")
+	}
+}
+
+func TestReservedPrefixRecorded(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tripline.db")
+	tripDb, err := db.OpenTriplineDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The reserved buckets of the default prefix would be filesets with another prefix.
+	_, err = db.OpenTriplineDbWith(dbPath, &db.OpenOptions{ReservedPrefix: "#"})
+	if err == nil || err.Error() != fmt.Sprintf("(db/470) database %q uses the reserved prefix \"_\"", dbPath) {
+		t.Errorf("open with another prefix: got error %v, want the reserved prefix error", err)
+	}
+	_, err = db.OpenTriplineDbWith(dbPath, &db.OpenOptions{ReservedPrefix: "#", ReadOnly: true})
+	if err == nil {
+		t.Error("read only open with another prefix succeeded")
+	}
+
+	// A new database records the prefix it is opened with.
+	otherPath := filepath.Join(t.TempDir(), "tripline.db")
+	tripDb, err = db.OpenTriplineDbWith(otherPath, &db.OpenOptions{ReservedPrefix: "#"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tripDb.IsReservedFileset("#signatures") || tripDb.IsReservedFileset("_signatures") {
+		t.Error("the reserved names do not use the prefix of the database")
+	}
+	err = tripDb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.OpenTriplineDb(otherPath)
+	if err == nil {
+		t.Error("open with the default prefix of a database with prefix \"#\" succeeded")
+	}
+}
+
+func TestOpenLockedInterrupted(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tripline.db")
+	tripDb, err := db.OpenTriplineDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tripDb.Close()
+
+	// The wait for the lock ends with the lock wait or with the context.
+	logger := log.New(ioutil.Discard, "", 0)
+	_, err = db.OpenTriplineDbWith(dbPath, &db.OpenOptions{LockWait: 50 * time.Millisecond, Logger: logger})
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/330)") {
+		t.Errorf("open a locked database: got error %v, want the in use error", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	_, err = db.OpenTriplineDbWith(dbPath, &db.OpenOptions{Context: ctx, Logger: logger})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("open a locked database until the context is done: got error %v, want the context error", err)
+	}
+}
+
+func TestOpenReadOnlyShared(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tripline.db")
+	tripDb, err := db.OpenTriplineDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The readers share the database, a writer waits for them.
+	logger := log.New(ioutil.Discard, "", 0)
+	opts := &db.OpenOptions{ReadOnly: true, LockWait: 50 * time.Millisecond, Logger: logger}
+	reader, err := db.OpenTriplineDbWith(dbPath, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	other, err := db.OpenTriplineDbWith(dbPath, opts)
+	if err != nil {
+		t.Fatalf("open a second reader: %v", err)
+	}
+	defer other.Close()
+	_, err = db.OpenTriplineDbWith(dbPath, &db.OpenOptions{LockWait: 50 * time.Millisecond, Logger: logger})
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/330)") {
+		t.Errorf("open a database with readers for writing: got error %v, want the in use error", err)
+	}
+}
@@ -0,0 +1,31 @@
+// Package dbtest provides the database fixture of the tests.
+package dbtest
+
+import (
+	"github.com/branscha/tripline/db"
+	"testing"
+)
+
+// Open an ephemeral database, it is removed when the test ends.
+// With begin a write transaction is started as well, it is rolled back when the test ends.
+func Open(t *testing.T, begin bool) *db.TriplineDb {
+	t.Helper()
+	tripDb, err := db.OpenEphemeralTriplineDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if begin {
+		err = tripDb.Begin(true)
+		if err != nil {
+			_ = tripDb.Close()
+			t.Fatal(err)
+		}
+	}
+	t.Cleanup(func() {
+		if begin {
+			_ = tripDb.Rollback()
+		}
+		_ = tripDb.Close()
+	})
+	return tripDb
+}
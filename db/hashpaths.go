@@ -0,0 +1,179 @@
+package db
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/crypto"
+	"sync"
+)
+
+const (
+	err340 = "(db/340) fileset %q stores hashed paths, the path key is required"
+	err350 = "(db/350) hashed path in fileset %q:%w"
+	err360 = "(db/360) fileset %q stores plain paths, only a new fileset can store hashed paths"
+	err370 = "(db/370) wrong path key for fileset %q"
+	err480 = "(db/480) record %q does not match the path style of fileset %q"
+)
+
+// The secret from which the keys of the hashed paths are derived and the derived keys per fileset salt, the
+// derivation is expensive. The transactions of a database share it.
+type pathKeyring struct {
+	mutex  sync.Mutex
+	secret []byte
+	cache  map[string]*pathKeys
+}
+
+// Set the secret of the filesets with hashed paths, see EnableHashedPaths. It applies to the transactions that are
+// started afterwards.
+func (db *TriplineDb) SetPathKey(secret string) {
+	db.txDefaults.keyring = &pathKeyring{secret: []byte(secret), cache: make(map[string]*pathKeys)}
+}
+
+// The keys of a fileset with hashed paths. The record keys are the HMAC of the paths so the paths cannot be read
+// from the database, the records contain the encrypted path so the fileset can still be listed with the key.
+type pathKeys struct {
+	mac []byte
+	enc []byte
+}
+
+// Derive the keys from the secret and the salt of the fileset.
+func derivePathKeys(secret []byte, salt []byte) (*pathKeys, []byte, error) {
+	key, salt, err := crypto.DeriveKey(secret, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	subKey := func(label string) []byte {
+		h := hmac.New(sha256.New, key)
+		h.Write([]byte(label))
+		return h.Sum(nil)
+	}
+	return &pathKeys{mac: subKey("tripline path hash"), enc: subKey("tripline path encryption")}, salt, nil
+}
+
+// The bolt key of the path, the path itself for a fileset with plain paths.
+func (k *pathKeys) recordKey(path string) []byte {
+	if k == nil {
+		return []byte(path)
+	}
+	return []byte(k.hash(path))
+}
+
+func (k *pathKeys) hash(value string) string {
+	h := hmac.New(sha256.New, k.mac)
+	h.Write([]byte(value))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// The value stored in the fileset meta to recognize a wrong secret.
+func (k *pathKeys) check() string {
+	return k.hash("")
+}
+
+func (k *pathKeys) sealPath(path string) (string, error) {
+	sealed, err := crypto.Seal(k.enc, []byte(path))
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (k *pathKeys) openPath(sealed string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return "", err
+	}
+	path, err := crypto.Open(k.enc, data)
+	if err != nil {
+		return "", err
+	}
+	return string(path), nil
+}
+
+// Store the record with the encrypted path, the records of a fileset with plain paths are stored as is.
+func (k *pathKeys) sealRecord(path string, jsn []byte) ([]byte, error) {
+	if k == nil {
+		return jsn, nil
+	}
+	rec := &TriplineRecord{}
+	err := json.Unmarshal(jsn, rec)
+	if err != nil {
+		return nil, err
+	}
+	rec.EncPath, err = k.sealPath(path)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(rec)
+}
+
+// Fetch the keys of the fileset, nil if the fileset stores plain paths.
+func (tx *TriplineTx) pathKeys(fileset string) (*pathKeys, error) {
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return nil, err
+	}
+	if !meta.HashPaths {
+		return nil, nil
+	}
+	if tx.keyring == nil {
+		return nil, fmt.Errorf(err340, fileset)
+	}
+	tx.keyring.mutex.Lock()
+	defer tx.keyring.mutex.Unlock()
+	keys, ok := tx.keyring.cache[meta.PathSalt]
+	if !ok {
+		salt, err := hex.DecodeString(meta.PathSalt)
+		if err != nil {
+			return nil, fmt.Errorf(err350, fileset, err)
+		}
+		keys, _, err = derivePathKeys(tx.keyring.secret, salt)
+		if err != nil {
+			return nil, fmt.Errorf(err350, fileset, err)
+		}
+		tx.keyring.cache[meta.PathSalt] = keys
+	}
+	if !hmac.Equal([]byte(keys.check()), []byte(meta.PathCheck)) {
+		return nil, fmt.Errorf(err370, fileset)
+	}
+	return keys, nil
+}
+
+// Store the paths of the fileset as keyed hashes, the paths themselves are encrypted in the records. The keys are
+// derived from the secret of the database, see SetPathKey, and a salt of the fileset. Only a fileset without records
+// can be switched, a fileset that already stores hashed paths only has its key checked.
+func (tx *TriplineTx) EnableHashedPaths(fileset string) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if meta.HashPaths {
+		_, err := tx.pathKeys(fileset)
+		return err
+	}
+	if bkt := tx.boltTx.Bucket(tx.key(fileset)); bkt != nil {
+		if k, _ := bkt.Cursor().First(); k != nil {
+			return fmt.Errorf(err360, fileset)
+		}
+	}
+	if tx.keyring == nil {
+		return fmt.Errorf(err340, fileset)
+	}
+	tx.keyring.mutex.Lock()
+	defer tx.keyring.mutex.Unlock()
+	keys, salt, err := derivePathKeys(tx.keyring.secret, nil)
+	if err != nil {
+		return fmt.Errorf(err350, fileset, err)
+	}
+	meta.HashPaths = true
+	meta.PathSalt = hex.EncodeToString(salt)
+	meta.PathCheck = keys.check()
+	tx.keyring.cache[meta.PathSalt] = keys
+	return tx.SaveFilesetMeta(fileset, meta)
+}
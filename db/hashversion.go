@@ -0,0 +1,96 @@
+package db
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+)
+
+const (
+	err310 = "(db/310) unsupported hash version %d"
+	err320 = "(db/320) re-sign fileset %q:%w"
+	err430 = "(db/430) signed hash has %d bytes, expected %d"
+)
+
+// Versions of the fileset hash that is signed.
+const (
+	// The keys and the values are concatenated, the boundaries are ambiguous, e.g. "ab"+"c" and "a"+"bc".
+	HashVersion1 = 1
+	// Each key and value is preceded by its length.
+	HashVersion2 = 2
+	// The hash starts with a header naming the version and the policy of the fileset, the settings that change how
+	// the records are verified. The records themselves include their list of checks.
+	HashVersion3 = 3
+	// The version of the new signatures and fingerprints.
+	CurrentHashVersion = HashVersion3
+)
+
+// The header of the hash from version 3 on, it separates the fileset hashes from other uses of the signing key.
+const hashHeader = "tripline fileset hash v3"
+
+// The settings of a fileset that are covered by the hash from version 3 on, in their canonical json form.
+// The update time is left out, it changes when the records change and an import sets its own.
+type filesetPolicy struct {
+	Resolve bool `json:"resolve"`
+	// Absent for the filesets with plain paths, their hashes are the same as before hashed paths existed.
+	HashPaths bool `json:"hashPaths,omitempty"`
+	// The saved queries select the records and checks of a verification, a query that is changed after the signing
+	// breaks the signature. Absent for the filesets without queries.
+	Queries map[string]*SavedQuery `json:"queries,omitempty"`
+}
+
+// Build the plaintext of a signature. The version is part of the encrypted payload so it cannot be altered
+// without the password. Version 1 signatures contain the hash only.
+func signedPayload(version int, hash []byte) []byte {
+	if version == HashVersion1 {
+		return hash
+	}
+	return append([]byte{byte(version)}, hash...)
+}
+
+// Split the decrypted plaintext of a signature in the hash version and the hash.
+func parseSignedPayload(plain []byte) (int, []byte, error) {
+	switch len(plain) {
+	case sha256.Size:
+		return HashVersion1, plain, nil
+	case sha256.Size + 1:
+		version := int(plain[0])
+		if version <= HashVersion1 || version > CurrentHashVersion {
+			return 0, nil, fmt.Errorf(err310, version)
+		}
+		return version, plain[1:], nil
+	default:
+		// A truncated or padded value is rejected before it is compared.
+		return 0, nil, fmt.Errorf(err430, len(plain), sha256.Size+1)
+	}
+}
+
+// Write a length framed value to the hash, the length is a big endian uint64.
+func writeFramed(w io.Writer, value []byte) error {
+	var length [8]byte
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	if _, err := w.Write(length[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(value)
+	return err
+}
+
+// Calculate the hash of the fileset with the version.
+func (tx *TriplineTx) filesetHash(fileset string, srcBkt *bolt.Bucket, version int) ([]byte, error) {
+	var policy []byte
+	if version >= HashVersion3 {
+		meta, err := tx.GetFilesetMeta(fileset)
+		if err != nil {
+			return nil, err
+		}
+		policy, err = json.Marshal(&filesetPolicy{Resolve: meta.Resolve, HashPaths: meta.HashPaths, Queries: meta.Queries})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return calcBucketHash(srcBkt, version, policy)
+}
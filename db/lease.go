@@ -0,0 +1,106 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io/ioutil"
+	"log"
+	"os"
+	"time"
+)
+
+const (
+	err330 = "(db/330) database %q in use by %s"
+	err335 = "(db/335) wait for database %q:%w"
+)
+
+const (
+	msg330 = "Waiting for database %q, in use by %s."
+)
+
+// Bolt locks the database file for the process that opened it. A process that opens it for writing holds an
+// exclusive lock, the processes that open it read only share the lock, see OpenOptions.ReadOnly. A fileset is
+// therefore never read while another process is modifying it, the readers do not wait for each other, and the lock
+// of a process that died is released by the operating system. The lease file next to the database tells the waiting
+// processes which writer holds the lock, it is informational only.
+const leaseSuffix = ".lease"
+
+// Time after which a waiting process reports the holder of the lock.
+const leaseNotice = 200 * time.Millisecond
+
+// The lock is waited for in slices of this duration, the context of the open is checked between them.
+const lockPoll = 100 * time.Millisecond
+
+// The holder of the database lock.
+type lease struct {
+	Pid     int    `json:"pid"`
+	Command string `json:"command,omitempty"`
+	Since   string `json:"since"`
+}
+
+// Open the bolt database, waiting for the lock. The holder is reported when the lock is not acquired immediately.
+// The wait ends with an error when the context of the options is done, e.g. on an interrupt.
+// See OpenOptions for the lock wait and the logger.
+func openLocked(dbPath string, mode os.FileMode, opts *OpenOptions) (*bolt.DB, error) {
+	start := time.Now()
+	noticed := false
+	for {
+		wait := lockPoll
+		if opts.LockWait > 0 {
+			left := opts.LockWait - time.Since(start)
+			if left <= 0 {
+				return nil, lockedError(dbPath, bolt.ErrTimeout)
+			}
+			if left < wait {
+				wait = left
+			}
+		}
+		db, err := bolt.Open(dbPath, mode, &bolt.Options{Timeout: wait, ReadOnly: opts.ReadOnly})
+		if err != bolt.ErrTimeout {
+			return db, err
+		}
+		if opts.Context != nil && opts.Context.Err() != nil {
+			return nil, fmt.Errorf(err335, dbPath, opts.Context.Err())
+		}
+		if !noticed && time.Since(start) >= leaseNotice && (opts.LockWait == 0 || opts.LockWait > leaseNotice) {
+			logger := opts.Logger
+			if logger == nil {
+				logger = log.New(log.Writer(), log.Prefix(), log.Flags())
+			}
+			logger.Printf(msg330, dbPath, leaseHolder(dbPath))
+			noticed = true
+		}
+	}
+}
+
+// Report a timeout with the holder of the lock.
+func lockedError(dbPath string, err error) error {
+	if err == bolt.ErrTimeout {
+		return fmt.Errorf(err330, dbPath, leaseHolder(dbPath))
+	}
+	return err
+}
+
+// Describe the holder of the database lock.
+func leaseHolder(dbPath string) string {
+	jsn, err := ioutil.ReadFile(dbPath + leaseSuffix)
+	if err != nil {
+		return "another process"
+	}
+	l := &lease{}
+	if json.Unmarshal(jsn, l) != nil {
+		return "another process"
+	}
+	return fmt.Sprintf("pid %d (%s) since %s", l.Pid, l.Command, l.Since)
+}
+
+// Record this process as the holder of the lock. A lease that is left behind by a process that died is replaced.
+func writeLease(dbPath string, mode os.FileMode, command string) error {
+	l := &lease{Pid: os.Getpid(), Command: command, Since: time.Now().Format(time.RFC3339)}
+	jsn, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(dbPath+leaseSuffix, jsn, mode)
+}
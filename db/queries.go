@@ -0,0 +1,86 @@
+package db
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	err420 = "(db/420) unknown query %q in fileset %q"
+)
+
+// A named selection of the records of a fileset, so a subset that is verified regularly does not have to be
+// specified each time. The queries are stored in the fileset meta.
+type SavedQuery struct {
+	// The path prefixes of the records, all records if empty.
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Glob patterns the paths should match, and the patterns that exclude paths.
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+	// The recorded checks that are run, all checks if empty.
+	Checks []string `json:"checks,omitempty"`
+}
+
+// Store a query under the name, it replaces the query with the same name.
+func (tx *TriplineTx) SaveQuery(fileset string, name string, query *SavedQuery) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	if tx.boltTx.Bucket(tx.key(fileset)) == nil {
+		return fmt.Errorf(err020, fileset)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if meta.Queries == nil {
+		meta.Queries = make(map[string]*SavedQuery)
+	}
+	meta.Queries[name] = query
+	return tx.SaveFilesetMeta(fileset, meta)
+}
+
+// Fetch the query with the name.
+// Returns an error if the fileset has no query with that name.
+func (tx *TriplineTx) GetQuery(fileset string, name string) (*SavedQuery, error) {
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return nil, err
+	}
+	query, ok := meta.Queries[name]
+	if !ok {
+		return nil, fmt.Errorf(err420, name, fileset)
+	}
+	return query, nil
+}
+
+// List the names of the queries of the fileset in alphabetical order.
+func (tx *TriplineTx) ListQueries(fileset string) ([]string, error) {
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]string, 0, len(meta.Queries))
+	for name := range meta.Queries {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
+
+// Delete the query with the name.
+// Returns an error if the fileset has no query with that name.
+func (tx *TriplineTx) DeleteQuery(fileset string, name string) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if _, ok := meta.Queries[name]; !ok {
+		return fmt.Errorf(err420, name, fileset)
+	}
+	delete(meta.Queries, name)
+	return tx.SaveFilesetMeta(fileset, meta)
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"fmt"
+	"github.com/boltdb/bolt"
+	"os"
+	"time"
+)
+
+const (
+	err290 = "(db/290) repair %q:%w"
+	err300 = "(db/300) repair target %q exists"
+)
+
+// Outcome of a repair, the buckets that could not be read completely are lost or partially recovered.
+type RepairResult struct {
+	Buckets int
+	Keys    int
+	Lost    []string
+}
+
+// Copy everything that can still be read from a damaged database to a new database.
+// Bolt has no recovery of its own, the buckets are copied one by one and a bucket that makes bolt panic is
+// reported as lost, the records that were read before are kept. The damaged database is not modified.
+// The meta pages of the damaged database have to be intact, otherwise bolt cannot open it and the filesets have to
+// be restored from an export.
+func Repair(src string, dst string) (result *RepairResult, err error) {
+	if _, err := os.Stat(dst); err == nil {
+		return nil, fmt.Errorf(err300, dst)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf(err290, src, fmt.Errorf("%v", r))
+		}
+	}()
+	srcDb, err := bolt.Open(src, 0400, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf(err290, src, err)
+	}
+	defer srcDb.Close()
+	dstDb, err := bolt.Open(dst, DefaultDbMode, nil)
+	if err != nil {
+		return nil, fmt.Errorf(err290, src, err)
+	}
+	defer dstDb.Close()
+
+	result = &RepairResult{}
+	var names [][]byte
+	err = srcDb.View(func(srcTx *bolt.Tx) error {
+		return srcTx.ForEach(func(name []byte, _ *bolt.Bucket) error {
+			names = append(names, append([]byte{}, name...))
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf(err290, src, err)
+	}
+	for _, name := range names {
+		err = dstDb.Update(func(dstTx *bolt.Tx) error {
+			return srcDb.View(func(srcTx *bolt.Tx) error {
+				dstBkt, err := dstTx.CreateBucketIfNotExists(name)
+				if err != nil {
+					return err
+				}
+				if !copyBucket(srcTx.Bucket(name), dstBkt, result) {
+					result.Lost = append(result.Lost, string(name))
+				}
+				return nil
+			})
+		})
+		if err != nil {
+			return nil, fmt.Errorf(err290, src, err)
+		}
+		result.Buckets++
+	}
+	return result, nil
+}
+
+// Copy the keys and the nested buckets, the result is false if the bucket could not be read completely.
+func copyBucket(src *bolt.Bucket, dst *bolt.Bucket, result *RepairResult) (complete bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			complete = false
+		}
+	}()
+	complete = true
+	c := src.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		if v == nil {
+			nested, err := dst.CreateBucketIfNotExists(k)
+			if err != nil || !copyBucket(src.Bucket(k), nested, result) {
+				complete = false
+			}
+			continue
+		}
+		if dst.Put(k, v) != nil {
+			complete = false
+			continue
+		}
+		result.Keys++
+	}
+	return complete
+}
@@ -0,0 +1,407 @@
+package db
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"github.com/branscha/tripline/crypto"
+	"hash"
+	"sort"
+)
+
+const (
+	// The hash trees of the filesets that maintain a tree root, one nested bucket per fileset. A reserved bucket, the
+	// name is prefixed.
+	treebucket = "treeroots"
+)
+
+const (
+	err380 = "(db/380) tree root %q:%w"
+	err390 = "(db/390) fileset %q does not maintain a tree root"
+	err400 = "(db/400) tree root of fileset %q is not signed"
+	err410 = "(db/410) tree root of fileset %q changed since it was signed"
+	err415 = "(db/415) stored tree root of fileset %q does not match its records"
+)
+
+// The tree has two levels below the root. The leaves are the hashes of the records, they are grouped in 256 nodes by
+// the first byte of the hash of their key. A modified record only changes its leaf, its node and the root, the
+// other nodes are reused. The nested bucket of a fileset stores the leaves with the leafPrefix followed by the hash
+// of the key, and the nodes with the nodePrefix followed by the node byte.
+const (
+	leafPrefix = 'L'
+	nodePrefix = 'N'
+)
+
+// The domain separation of the leaf, node and root hashes.
+const (
+	leafTag = 0
+	nodeTag = 1
+	rootTag = 2
+)
+
+// The tree root of a fileset.
+type TreeRootInfo struct {
+	// The hex encoded root of the records.
+	Root string
+	// The root that was signed, empty if it was never signed.
+	SignedRoot string
+}
+
+// Maintain a tree root of the records of the fileset. The tree is built from the existing records once, the
+// modifications of the records update it incrementally, the root is recalculated when the transaction is committed.
+// A fileset that already maintains a tree root is left as is.
+func (tx *TriplineTx) EnableTreeRoot(fileset string) (err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if len(meta.TreeRoot) > 0 {
+		return nil
+	}
+	treeBkt, err := tx.treeBucket(fileset, true)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	if bkt := tx.boltTx.Bucket(tx.key(fileset)); bkt != nil {
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			err := tx.putLeaf(fileset, treeBkt, k, v)
+			if err != nil {
+				return fmt.Errorf(err380, fileset, err)
+			}
+		}
+	}
+	for node := 0; node < 256; node++ {
+		tx.markTreeNode(fileset, byte(node))
+	}
+	// The root is stored right away, it marks the fileset as maintaining one.
+	meta.TreeRoot, err = tx.updateTreeRoot(fileset, treeBkt)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	return tx.SaveFilesetMeta(fileset, meta)
+}
+
+// Fetch the tree root of the fileset, including the modifications of the transaction.
+// Returns nil if the fileset does not maintain a tree root.
+func (tx *TriplineTx) TreeRoot(fileset string) (result *TreeRootInfo, err error) {
+	defer recoverCorrupt(&err)
+	if tx.boltTx == nil {
+		return nil, fmt.Errorf(err080)
+	}
+	if tx.boltTx.Writable() {
+		err := tx.flushTreeRoots()
+		if err != nil {
+			return nil, err
+		}
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return nil, err
+	}
+	if len(meta.TreeRoot) == 0 {
+		return nil, nil
+	}
+	return &TreeRootInfo{Root: meta.TreeRoot, SignedRoot: meta.TreeRootSigned}, nil
+}
+
+// Sign the current tree root of the fileset, it replaces the previous signature.
+func (tx *TriplineTx) SignTreeRoot(fileset string, password string) error {
+	if tx.boltTx == nil || !tx.boltTx.Writable() {
+		return fmt.Errorf(err085)
+	}
+	info, err := tx.TreeRoot(fileset)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf(err390, fileset)
+	}
+	root, err := hex.DecodeString(info.Root)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	signature, err := crypto.Encrypt([]byte(password), root)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	meta.TreeRootSigned = info.Root
+	meta.TreeRootSignature = base64.StdEncoding.EncodeToString(signature)
+	return tx.SaveFilesetMeta(fileset, meta)
+}
+
+// Check that the signature of the tree root covers the current records of the fileset. The root is rebuilt from the
+// records, a record that was modified without updating the tree does not match the signature. The stored root has to
+// match the rebuilt one as well.
+func (tx *TriplineTx) VerifyTreeRoot(fileset string, password string) (err error) {
+	defer recoverCorrupt(&err)
+	info, err := tx.TreeRoot(fileset)
+	if err != nil {
+		return err
+	}
+	if info == nil {
+		return fmt.Errorf(err390, fileset)
+	}
+	meta, err := tx.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if len(meta.TreeRootSignature) == 0 {
+		return fmt.Errorf(err400, fileset)
+	}
+	signature, err := base64.StdEncoding.DecodeString(meta.TreeRootSignature)
+	if err != nil {
+		return fmt.Errorf(err190, err)
+	}
+	signed, err := crypto.Decrypt([]byte(password), signature)
+	if err != nil {
+		return fmt.Errorf(err190, err)
+	}
+	computed, err := tx.computeTreeRoot(fileset)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	root, err := hex.DecodeString(computed)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	if !hmac.Equal(signed, root) {
+		return fmt.Errorf(err410, fileset)
+	}
+	if computed != info.Root {
+		return fmt.Errorf(err415, fileset)
+	}
+	return nil
+}
+
+// Rebuild the tree root of the fileset from its records in memory, like putLeaf and updateTreeRoot do in the tree
+// bucket. The stored leaves and nodes are not used.
+// Returns the hex encoded root.
+func (tx *TriplineTx) computeTreeRoot(fileset string) (string, error) {
+	leaves := make(map[string][]byte)
+	if bkt := tx.boltTx.Bucket(tx.key(fileset)); bkt != nil {
+		c := bkt.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			leaf, err := leafHash(k, v)
+			if err != nil {
+				return "", err
+			}
+			leaves[string(leafKey(k))] = leaf
+		}
+	}
+	// The leaves are hashed in the order of their keys, like the cursor of the tree bucket returns them.
+	keys := make([]string, 0, len(leaves))
+	for k := range leaves {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var nodes [256]hash.Hash
+	for _, k := range keys {
+		node := k[1]
+		if nodes[node] == nil {
+			nodes[node] = sha256.New()
+			nodes[node].Write([]byte{nodeTag, node})
+		}
+		nodes[node].Write([]byte(k[1:]))
+		nodes[node].Write(leaves[k])
+	}
+
+	h := sha256.New()
+	h.Write([]byte{rootTag})
+	for node, nodeHash := range nodes {
+		if nodeHash != nil {
+			h.Write([]byte{byte(node)})
+			h.Write(nodeHash.Sum(nil))
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Fetch the nested bucket with the hash tree of the fileset, nil if it has none and create is not set.
+func (tx *TriplineTx) treeBucket(fileset string, create bool) (*bolt.Bucket, error) {
+	if !create {
+		treesBkt := tx.boltTx.Bucket(tx.reserved(treebucket))
+		if treesBkt == nil {
+			return nil, nil
+		}
+		return treesBkt.Bucket(tx.key(fileset)), nil
+	}
+	treesBkt, err := tx.boltTx.CreateBucketIfNotExists(tx.reserved(treebucket))
+	if err != nil {
+		return nil, err
+	}
+	return treesBkt.CreateBucketIfNotExists(tx.key(fileset))
+}
+
+// Update the tree after a record was stored or deleted, a nil value deletes the leaf.
+// Filesets without a tree are left alone.
+func (tx *TriplineTx) updateTreeLeaf(fileset string, key []byte, value []byte) error {
+	treeBkt, err := tx.treeBucket(fileset, false)
+	if err != nil || treeBkt == nil {
+		return err
+	}
+	if value == nil {
+		leaf := leafKey(key)
+		tx.markTreeNode(fileset, leaf[1])
+		return treeBkt.Delete(leaf)
+	}
+	return tx.putLeaf(fileset, treeBkt, key, value)
+}
+
+func (tx *TriplineTx) putLeaf(fileset string, treeBkt *bolt.Bucket, key []byte, value []byte) error {
+	digest, err := leafHash(key, value)
+	if err != nil {
+		return err
+	}
+	leaf := leafKey(key)
+	tx.markTreeNode(fileset, leaf[1])
+	return treeBkt.Put(leaf, digest)
+}
+
+// The hash of the leaf of a stored record.
+func leafHash(key []byte, value []byte) ([]byte, error) {
+	// The leaves do not depend on the compression.
+	jsn, err := decodeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	h.Write([]byte{leafTag})
+	err = writeFramed(h, key)
+	if err == nil {
+		err = writeFramed(h, jsn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// The key of the leaf of a record, the hash of the record key spreads the leaves evenly over the nodes.
+func leafKey(key []byte) []byte {
+	hash := sha256.Sum256(key)
+	return append([]byte{leafPrefix}, hash[:]...)
+}
+
+// Remember the node that has to be recalculated when the transaction is committed.
+func (tx *TriplineTx) markTreeNode(fileset string, node byte) {
+	if tx.treeDirty == nil {
+		tx.treeDirty = make(map[string]map[byte]bool)
+	}
+	nodes, ok := tx.treeDirty[fileset]
+	if !ok {
+		nodes = make(map[byte]bool)
+		tx.treeDirty[fileset] = nodes
+	}
+	nodes[node] = true
+}
+
+// Recalculate the roots of the filesets that were modified in the transaction.
+func (tx *TriplineTx) flushTreeRoots() error {
+	for fileset := range tx.treeDirty {
+		treeBkt, err := tx.treeBucket(fileset, false)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, err)
+		}
+		if treeBkt == nil {
+			// The fileset was deleted.
+			delete(tx.treeDirty, fileset)
+			continue
+		}
+		root, err := tx.updateTreeRoot(fileset, treeBkt)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, err)
+		}
+		meta, err := tx.GetFilesetMeta(fileset)
+		if err != nil {
+			return err
+		}
+		meta.TreeRoot = root
+		err = tx.SaveFilesetMeta(fileset, meta)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Recalculate the modified nodes and the root of the fileset tree.
+// Returns the hex encoded root.
+func (tx *TriplineTx) updateTreeRoot(fileset string, treeBkt *bolt.Bucket) (string, error) {
+	c := treeBkt.Cursor()
+	for node := range tx.treeDirty[fileset] {
+		h := sha256.New()
+		h.Write([]byte{nodeTag, node})
+		prefix := []byte{leafPrefix, node}
+		leaves := 0
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+			h.Write(k[1:])
+			h.Write(v)
+			leaves++
+		}
+		var err error
+		if leaves == 0 {
+			err = treeBkt.Delete([]byte{nodePrefix, node})
+		} else {
+			err = treeBkt.Put([]byte{nodePrefix, node}, h.Sum(nil))
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	delete(tx.treeDirty, fileset)
+
+	h := sha256.New()
+	h.Write([]byte{rootTag})
+	prefix := []byte{nodePrefix}
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		h.Write(k[1:])
+		h.Write(v)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Remove the hash tree of a deleted fileset.
+func (tx *TriplineTx) deleteTree(fileset string) error {
+	delete(tx.treeDirty, fileset)
+	treesBkt := tx.boltTx.Bucket(tx.reserved(treebucket))
+	if treesBkt == nil || treesBkt.Bucket(tx.key(fileset)) == nil {
+		return nil
+	}
+	return treesBkt.DeleteBucket(tx.key(fileset))
+}
+
+// Copy the hash tree of a fileset to the copy of the fileset, the records and their keys are the same.
+func (tx *TriplineTx) copyTree(src, target string) error {
+	srcBkt, err := tx.treeBucket(src, false)
+	if err != nil || srcBkt == nil {
+		return err
+	}
+	targetBkt, err := tx.treeBucket(target, true)
+	if err != nil {
+		return err
+	}
+	err = srcBkt.ForEach(func(k, v []byte) error {
+		return targetBkt.Put(k, v)
+	})
+	if err != nil {
+		return err
+	}
+	// The pending node updates of the source apply to the copy as well.
+	for node := range tx.treeDirty[src] {
+		tx.markTreeNode(target, node)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package db
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyTreeRootTampered(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signatures takes seconds")
+	}
+	tripDb, err := OpenEphemeralTriplineDb()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tripDb.Close()
+	err = tripDb.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tripDb.Rollback()
+	for _, path := range []string{"/a", "/a/x"} {
+		rec := &TriplineRecord{Type: "regular", Checks: []string{"size"}, Data: map[string]interface{}{"size": "1"}}
+		err := tripDb.AddTriplineRecord(path, rec, "test", false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = tripDb.EnableTreeRoot("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.SignTreeRoot("test", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.VerifyTreeRoot("test", "secret")
+	if err != nil {
+		t.Fatalf("verify the signed tree root: %v", err)
+	}
+
+	// The stored root has to match the records.
+	meta, err := tripDb.GetFilesetMeta("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := meta.TreeRoot
+	meta.TreeRoot = strings.Repeat("0", len(stored))
+	err = tripDb.SaveFilesetMeta("test", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.VerifyTreeRoot("test", "secret")
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/415)") {
+		t.Errorf("verify a modified stored root: got error %v, want the stored root error", err)
+	}
+	meta.TreeRoot = stored
+	err = tripDb.SaveFilesetMeta("test", meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A record that is rewritten in the bucket without updating the tree does not match the signature.
+	bkt := tripDb.boltTx.Bucket(tripDb.key("test"))
+	k, _ := bkt.Cursor().First()
+	err = bkt.Put(k, []byte(`{"isDir":false,"type":"regular","checks":["size"],"data":{"size":"2"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.VerifyTreeRoot("test", "secret")
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/410)") {
+		t.Errorf("verify a tampered record: got error %v, want the changed tree root error", err)
+	}
+}
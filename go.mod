@@ -5,4 +5,5 @@ go 1.15
 require (
 	github.com/boltdb/bolt v1.3.1
 	golang.org/x/crypto v0.0.0-20201221181555-eec23a3978ad
+	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037
 )
@@ -0,0 +1,85 @@
+package proc
+
+import (
+	"errors"
+	"github.com/branscha/tripline/db"
+	"os"
+)
+
+// The number of prepared files per worker that can wait for their turn to be stored.
+const addQueuePerWorker = 4
+
+// A file whose checks are prepared by a worker of the add.
+type addJob struct {
+	key    string
+	fqn    string
+	fi     os.FileInfo
+	checks []string
+	rec    *db.TriplineRecord
+	// Set by the worker, the job is done when the channel is closed.
+	err  error
+	done chan struct{}
+}
+
+// Start the workers that prepare the checks of the files. The records are stored by the walk, the database
+// transaction is not shared with the workers.
+func (a *adder) startWorkers(n int) {
+	a.work = make(chan *addJob, n)
+	a.stop = make(chan struct{})
+	for i := 0; i < n; i++ {
+		a.workers.Add(1)
+		go func() {
+			defer a.workers.Done()
+			for job := range a.work {
+				select {
+				case <-a.stop:
+					job.err = errors.New("add stopped")
+				default:
+					job.err = a.prepareFile(job.fqn, job.fi, job.checks, job.rec)
+				}
+				close(job.done)
+			}
+		}()
+	}
+}
+
+// Stop the workers, the files that were not prepared yet are abandoned.
+func (a *adder) stopWorkers() {
+	close(a.stop)
+	close(a.work)
+	a.workers.Wait()
+}
+
+// Hand the file to the workers and store the records of the files that are ready.
+func (a *adder) submit(job *addJob) error {
+	job.done = make(chan struct{})
+	a.queue = append(a.queue, job)
+	a.work <- job
+	return a.drain(addQueuePerWorker * cap(a.work))
+}
+
+// Store the prepared records in the order the files were submitted, it waits until at most limit files are
+// outstanding. The first error in the order of the walk is returned, the files after it are not stored.
+func (a *adder) drain(limit int) error {
+	for len(a.queue) > 0 {
+		job := a.queue[0]
+		if len(a.queue) <= limit {
+			select {
+			case <-job.done:
+			default:
+				return nil
+			}
+		} else {
+			<-job.done
+		}
+		a.queue = a.queue[1:]
+		if job.err != nil {
+			return job.err
+		}
+		err := a.store(job.key, job.fqn, job.rec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
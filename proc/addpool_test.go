@@ -0,0 +1,65 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The files that fail the slowcheck check, with the time they take to fail. The first failure in the order of the walk
+// fails last.
+var slowCheckFailures = map[string]time.Duration{
+	"f05": 30 * time.Millisecond,
+	"f17": 10 * time.Millisecond,
+	"f30": 0,
+}
+
+// Type slowChecker fails for the files of slowCheckFailures, after their delay.
+type slowChecker struct{}
+
+func init() {
+	err := RegisterFileCheck("slowcheck", slowChecker{})
+	if err != nil {
+		panic(err)
+	}
+}
+
+func (c slowChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	delay, found := slowCheckFailures[filepath.Base(fqn)]
+	if !found {
+		return "ok", nil
+	}
+	time.Sleep(delay)
+	return nil, fmt.Errorf("slowcheck failed")
+}
+
+func (c slowChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	return nil
+}
+
+func TestAddFilesWorkersErrorOrder(t *testing.T) {
+	captureLog(t)
+	dir := t.TempDir()
+	for i := 0; i < 40; i++ {
+		writeTestFiles(t, dir, fmt.Sprintf("f%02d", i))
+	}
+	want := []string{".", "f00", "f01", "f02", "f03", "f04"}
+
+	// The workers finish the later files first, the reported error and the stored records follow the walk.
+	for _, jobs := range []int{1, 2, 8, 8, 8, 8, 8} {
+		tripDb := dbtest.Open(t, true)
+		opts := &AddOptions{Recursive: true, FileChecks: "size,slowcheck", DirChecks: "modtime", Jobs: jobs}
+		err := AddFiles([]string{dir}, "test", opts, tripDb)
+		if err == nil || !strings.Contains(err.Error(), filepath.Join(dir, "f05")) {
+			t.Fatalf("jobs %d: got error %v, want the error of f05", jobs, err)
+		}
+		got := recordedPaths(t, tripDb, "test", dir)
+		if !equalPaths(got, want) {
+			t.Errorf("jobs %d: recorded %v, want %v", jobs, got, want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"os"
+)
+
+const (
+	err900 = "(proc/900) augment fileset %q:%w"
+	err920 = "(proc/920) compute pending checks of fileset %q:%w"
+)
+
+const (
+	msg900 = "%d of %d records augmented"
+	msg910 = "cannot prepare %s:%v"
+	msg920 = "%d of %d pending records computed"
+)
+
+// Add checks to the existing records of a fileset without touching the data that was already recorded.
+// Only the records that lack one of the checks are updated, the missing checks are prepared against the current
+// state of the filesystem. Directories only receive the checks that apply to directories and vice versa.
+// Records of files that cannot be read are reported and left alone.
+func Augment(fileset string, checks string, ro *ReadOptions, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	checkNames, err := ParseChecks(checks)
+	if err != nil {
+		return err
+	}
+
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return fmt.Errorf(err900, fileset, err)
+	}
+
+	augmented := 0
+	for _, entry := range entries {
+		if err := ro.interrupted(); err != nil {
+			return err
+		}
+		rec := entry.Record
+		validChecks := fileChecks
+		if rec.IsDir {
+			validChecks = dirChecks
+		}
+
+		var missing []string
+		for _, checkName := range checkNames {
+			if _, valid := validChecks[checkName]; !valid {
+				continue
+			}
+			if _, found := rec.Data[checkName]; found || isPending(&rec, checkName) {
+				// The pending checks are computed by ComputePending.
+				continue
+			}
+			missing = append(missing, checkName)
+		}
+		if len(missing) == 0 {
+			continue
+		}
+
+		if !prepareRecordChecks(entry.Path, &rec, missing, validChecks, ro) {
+			continue
+		}
+		rec.Checks = append(rec.Checks, missing...)
+
+		err := tripDb.AddTriplineRecord(entry.Path, &rec, fileset, true)
+		if err != nil {
+			return fmt.Errorf(err900, fileset, err)
+		}
+		augmented++
+	}
+	ro.logger().Printf(msg900, augmented, len(entries))
+	if augmented == 0 {
+		return nil
+	}
+	return touchFileset(fileset, tripDb)
+}
+
+// Compute the data of the checks that were deferred when the files were added, see AddOptions.DeferContent.
+// Records of files that cannot be read are reported and remain pending.
+func ComputePending(fileset string, ro *ReadOptions, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return fmt.Errorf(err920, fileset, err)
+	}
+
+	computed := 0
+	pending := 0
+	for _, entry := range entries {
+		if err := ro.interrupted(); err != nil {
+			return err
+		}
+		rec := entry.Record
+		if len(rec.Pending) == 0 {
+			continue
+		}
+		pending++
+		validChecks := fileChecks
+		if rec.IsDir {
+			validChecks = dirChecks
+		}
+		if !prepareRecordChecks(entry.Path, &rec, rec.Pending, validChecks, ro) {
+			continue
+		}
+		rec.Pending = nil
+
+		err := tripDb.AddTriplineRecord(entry.Path, &rec, fileset, true)
+		if err != nil {
+			return fmt.Errorf(err920, fileset, err)
+		}
+		computed++
+	}
+	ro.logger().Printf(msg920, computed, pending)
+	if computed == 0 {
+		return nil
+	}
+	return touchFileset(fileset, tripDb)
+}
+
+// Recorded owner or group of a file that belongs to the user that added it, it matches the verifying user.
+const selfOwner = "$SELF"
+
+// The add options of a record that can be recovered from its recorded data. The precision is recorded with the
+// modification time, the self owner option left the "$SELF" owner. The checks of the record are prepared again with
+// these options, the record keeps the form it was added in.
+func recordOptions(rec *db.TriplineRecord, ro *ReadOptions) *AddOptions {
+	opts := &AddOptions{}
+	if ro != nil {
+		opts.ReadOptions = *ro
+	}
+	if _, precision := modTimeRepr(rec.Data["modtime"]); precision != "nanosecond" {
+		opts.ModTimePrecision = precision
+	}
+	if owner, ok := rec.Data["ownership"].(map[string]interface{}); ok {
+		opts.SelfOwner = owner["User"] == selfOwner || owner["Group"] == selfOwner
+	}
+	return opts
+}
+
+// Prepare the checks of a record against the current state of the filesystem, the data is added to the record.
+// The checkers get the add options of the record, see recordOptions.
+// Problems with the file are reported, the result is false if the record should be left alone.
+func prepareRecordChecks(recordedPath string, rec *db.TriplineRecord, checkNames []string, validChecks map[string]FileChecker, ro *ReadOptions) bool {
+	path, err := expandHome(recordedPath)
+	if err != nil {
+		ro.logger().Printf(msg910, recordedPath, err)
+		return false
+	}
+	stat := os.Stat
+	if recordedAsLink(rec) || hasSymlinkCheck(checkNames) {
+		stat = os.Lstat
+	}
+	fi, err := stat(path)
+	if err != nil {
+		ro.logger().Printf(msg910, recordedPath, err)
+		return false
+	}
+	if fi.IsDir() != rec.IsDir {
+		ro.logger().Printf(msg910, recordedPath, "file type changed")
+		return false
+	}
+
+	if rec.Data == nil {
+		rec.Data = make(map[string]interface{})
+	}
+	opts := recordOptions(rec, ro)
+	// The content hashes are calculated in a single pass over the file.
+	var digests map[string]string
+	if !rec.IsDir {
+		digests, err = hashChecks(path, checkNames, validChecks, ro)
+		if err != nil {
+			ro.logger().Printf(msg910, recordedPath, fmt.Sprintf("content:%v", err))
+			return false
+		}
+	}
+	for _, checkName := range checkNames {
+		if digest, found := digests[checkName]; found {
+			rec.Data[checkName] = digest
+			continue
+		}
+		checkData, err := addChecker(validChecks[checkName], opts).PrepareCheck(path, fi)
+		if err != nil {
+			ro.logger().Printf(msg910, recordedPath, fmt.Sprintf("%s:%v", checkName, err))
+			return false
+		}
+		rec.Data[checkName] = checkData
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// The shields.io endpoint format, see https://shields.io/endpoint.
+type badge struct {
+	SchemaVersion int    `json:"schemaVersion"`
+	Label         string `json:"label"`
+	Message       string `json:"message"`
+	Color         string `json:"color"`
+}
+
+// Write the outcome of the verification as a shields.io endpoint badge. Failures are red, a clean result on a
+// baseline that was not updated for longer than the stale duration is yellow and a clean result is green.
+// A stale duration of 0 disables the staleness, as does an unknown update time.
+func (r *VerifyReport) WriteBadge(w io.Writer, staleAfter time.Duration) error {
+	b := &badge{SchemaVersion: 1, Label: "tripline", Message: "clean", Color: "green"}
+	if fails := r.Failures(); fails > 0 {
+		b.Message = fmt.Sprintf("%d failures", fails)
+		b.Color = "red"
+	} else if staleAfter > 0 && r.stale(staleAfter) {
+		b.Message = "clean, stale baseline"
+		b.Color = "yellow"
+	}
+	jsn, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(jsn, '\n'))
+	return err
+}
+
+// Check if the baseline was updated longer than the duration ago.
+func (r *VerifyReport) stale(staleAfter time.Duration) bool {
+	updatedAt, err := time.Parse(baselineFormat, r.UpdatedAt)
+	if err != nil {
+		return false
+	}
+	return time.Since(updatedAt) > staleAfter
+}
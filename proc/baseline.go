@@ -0,0 +1,70 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"time"
+)
+
+const (
+	err410 = "(proc/410) baseline age %q:%w"
+)
+
+const (
+	msg410 = "baseline %q updated %s, signed %s"
+)
+
+// Format of the update timestamps of the filesets, the same as the signature snapshots.
+const baselineFormat = "2006-01-02T15:04:05.000000000Z"
+
+// Record the time the records of the fileset were modified.
+func touchFileset(fileset string, tripDb *db.TriplineDb) error {
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	meta.UpdatedAt = time.Now().UTC().Format(baselineFormat)
+	return tripDb.SaveFilesetMeta(fileset, meta)
+}
+
+// Print when the fileset was last updated and signed, so a stale baseline is noticed before the results are
+// interpreted. It is advisory, the filesets of older versions have no update time.
+// Returns the update time, empty if it is unknown.
+func logBaselineAge(fileset string, logger *log.Logger, tripDb *db.TriplineDb) (string, error) {
+	exists, err := tripDb.HasFileset(fileset)
+	if err != nil || !exists {
+		// An unknown fileset is reported by the verification.
+		return "", err
+	}
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return "", fmt.Errorf(err410, fileset, err)
+	}
+	snapshots, err := tripDb.ListFilesetSnapshots(fileset)
+	if err != nil {
+		return "", fmt.Errorf(err410, fileset, err)
+	}
+	signed := ""
+	if len(snapshots) > 0 {
+		signed = snapshots[len(snapshots)-1]
+	}
+	logger.Printf(msg410, fileset, describeAge(meta.UpdatedAt), describeAge(signed))
+	return meta.UpdatedAt, nil
+}
+
+// Describe a timestamp with its age, e.g. "2021-03-01 (183 days ago)".
+func describeAge(ts string) string {
+	if len(ts) == 0 {
+		return "unknown"
+	}
+	t, err := time.Parse(baselineFormat, ts)
+	if err != nil {
+		return ts
+	}
+	age := time.Since(t)
+	if age < 24*time.Hour {
+		return fmt.Sprintf("%s (%s ago)", t.Format(displayFormat), age.Truncate(time.Minute))
+	}
+	return fmt.Sprintf("%s (%d days ago)", t.Format(displayFormat), int(age.Hours()/24))
+}
@@ -0,0 +1,92 @@
+package proc
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Type VerifyCache remembers the outcome of the checks during a run that verifies several filesets, a path that is
+// recorded in more than one of them is only read and hashed once for the same check and recorded data.
+// The filesystem is assumed to be stable during the run, nothing is invalidated. A nil cache disables the caching.
+type VerifyCache struct {
+	mu      sync.Mutex
+	digests map[string]cachedDigests
+	results map[string]error
+	hits    int
+	misses  int
+}
+
+type cachedDigests struct {
+	digests map[string]string
+	err     error
+}
+
+func NewVerifyCache() *VerifyCache {
+	return &VerifyCache{digests: make(map[string]cachedDigests), results: make(map[string]error)}
+}
+
+// The number of checks that were answered from the cache and the number that were executed.
+func (c *VerifyCache) Stats() (int, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Calculate the digests of the hash checks, see hashChecks.
+func (c *VerifyCache) hashChecks(fqn string, checks []string, checkers map[string]FileChecker, ro *ReadOptions) (map[string]string, error) {
+	if c == nil {
+		return hashChecks(fqn, checks, checkers, ro)
+	}
+	key := fqn + "\x00" + strings.Join(checks, ",")
+	c.mu.Lock()
+	cached, found := c.digests[key]
+	c.mu.Unlock()
+	if found {
+		c.count(true)
+		return cached.digests, cached.err
+	}
+	digests, err := hashChecks(fqn, checks, checkers, ro)
+	c.mu.Lock()
+	c.digests[key] = cachedDigests{digests, err}
+	c.mu.Unlock()
+	c.count(false)
+	return digests, err
+}
+
+// Execute a check, the outcome depends on the path, the check and the recorded data.
+func (c *VerifyCache) executeCheck(checkName string, checker FileChecker, fqn string, data interface{}, fi os.FileInfo) error {
+	if c == nil {
+		return checker.ExecuteCheck(fqn, data, fi)
+	}
+	jsn, err := json.Marshal(data)
+	if err != nil {
+		// Not comparable, it is not cached.
+		return checker.ExecuteCheck(fqn, data, fi)
+	}
+	key := checkName + "\x00" + fqn + "\x00" + string(jsn)
+	c.mu.Lock()
+	result, found := c.results[key]
+	c.mu.Unlock()
+	if found {
+		c.count(true)
+		return result
+	}
+	result = checker.ExecuteCheck(fqn, data, fi)
+	c.mu.Lock()
+	c.results[key] = result
+	c.mu.Unlock()
+	c.count(false)
+	return result
+}
+
+func (c *VerifyCache) count(hit bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
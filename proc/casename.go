@@ -0,0 +1,65 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Type caseNameChecker verifies that the name of the file in its directory has exactly the same case as recorded.
+// On case insensitive filesystems a file renamed from "Config.yaml" to "config.yaml" can still be opened using the
+// old name, so the other checks do not notice the rename.
+type caseNameChecker struct{}
+
+func (d caseNameChecker) PrepareCheck(fqn string, _ os.FileInfo) (interface{}, error) {
+	// Record the name as it appears in the directory listing, not the name we used to open the file.
+	name, err := dirEntryName(fqn, filepath.Base(fqn))
+	if err != nil {
+		return nil, err
+	}
+	if len(name) == 0 {
+		return nil, fmt.Errorf("not found in directory")
+	}
+	return name, nil
+}
+
+func (d caseNameChecker) ExecuteCheck(fqn string, data interface{}, _ os.FileInfo) error {
+	expectedName, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+
+	actualName, err := dirEntryName(fqn, expectedName)
+	if err != nil {
+		return err
+	}
+	if len(actualName) == 0 {
+		return fmt.Errorf("not found in directory")
+	}
+	if expectedName != actualName {
+		return fmt.Errorf("expected %s actual %s", expectedName, actualName)
+	}
+	return nil
+}
+
+// Look up the name in the parent directory of the file, ignoring case.
+// An exact match has precedence, there can be several names that only differ in case on case sensitive filesystems.
+// Returns the empty string if there is no match.
+func dirEntryName(fqn string, name string) (string, error) {
+	children, err := ioutil.ReadDir(filepath.Dir(fqn))
+	if err != nil {
+		return "", err
+	}
+	result := ""
+	for _, child := range children {
+		if child.Name() == name {
+			return name, nil
+		}
+		if strings.EqualFold(child.Name(), name) {
+			result = child.Name()
+		}
+	}
+	return result, nil
+}
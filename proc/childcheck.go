@@ -9,12 +9,12 @@ import (
 
 type childChecker struct{}
 
-func (d childChecker) prepareCheck(fqn string, _ os.FileInfo) (interface{}, error) {
+func (d childChecker) PrepareCheck(fqn string, _ os.FileInfo) (interface{}, error) {
 	childList, err := childList(fqn)
 	return childList, err
 }
 
-func (d childChecker) executeCheck(fqn string, data interface{}, _ os.FileInfo) error {
+func (d childChecker) ExecuteCheck(fqn string, data interface{}, _ os.FileInfo) error {
 	expectedChildList, ok := data.([]interface{})
 	if !ok {
 		return fmt.Errorf("corrupt child data")
@@ -0,0 +1,79 @@
+package proc
+
+import (
+	"errors"
+	"github.com/branscha/tripline/db"
+	"os"
+	"path/filepath"
+)
+
+// Walk the subtrees of the recorded directories and report the files and directories that are not recorded.
+// It is the recursive counterpart of the child check, driven from the filesystem. The contents of an unrecorded
+// directory are not reported separately. Recorded subdirectories are walked when their own entry is processed.
+func (v *verifier) checkClosedWorld(section *VerifySection, entries []db.TriplineEntry) error {
+	if v.recorded == nil {
+		err := v.loadRecorded()
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if !entry.Record.IsDir {
+			continue
+		}
+		root, err := expandHome(entry.Path)
+		if err != nil {
+			return err
+		}
+		root = translatePath(root, v.opts.PathMaps)
+		if _, err := os.Stat(root); err != nil {
+			// The missing directory is reported by the basic check.
+			continue
+		}
+
+		err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if err := v.opts.interrupted(); err != nil {
+				return err
+			}
+			if path == root {
+				return nil
+			}
+			if v.recorded[path] {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			v.add(section, path, closedWorldCheck, errors.New("unexpected file"))
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Collect the filesystem paths of all the records in the fileset.
+func (v *verifier) loadRecorded() error {
+	all, err := v.tripDb.ListTriplineRecords(v.fileset)
+	if err != nil {
+		return err
+	}
+	v.recorded = make(map[string]bool)
+	for _, entry := range all {
+		path, err := expandHome(entry.Path)
+		if err != nil {
+			return err
+		}
+		v.recorded[translatePath(path, v.opts.PathMaps)] = true
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package proc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	err550 = "(proc/550) content limit %d out of range 1..%d"
+	msg560 = "content of %s not stored, %d bytes exceeds the content limit of %d bytes"
+)
+
+// The largest content limit, the recorded contents are kept in memory during the verification.
+const maxContentLimit = 64 * 1024 * 1024
+
+// Files up to this number of bytes are stored by the content check, unless the add options set another limit.
+const defaultContentLimit = 64 * 1024
+
+// Recorded by the content check instead of the contents of a file larger than the content limit, followed by the
+// size of the file. The base64 encoding of the contents has no colon.
+const tooLargeMarker = "toolarge:"
+
+// The number of bytes before and after the first difference that are shown by the content check.
+const contentWindow = 8
+
+// Check the size of the largest file whose contents are stored by the content check, in bytes.
+func checkContentLimit(size int64) error {
+	if size < 1 || size > maxContentLimit {
+		return fmt.Errorf(err550, size, maxContentLimit)
+	}
+	return nil
+}
+
+// Type contentChecker stores a compressed copy of the file contents and reports the offset of the first byte that
+// differs with a hexdump of the bytes around it. It tells where a small configuration file changed, the digests
+// only tell that it changed. A file larger than the content limit is recorded with its size instead of its contents,
+// the verification reports it as not compared and a digest has to detect its changes.
+type contentChecker struct {
+	// Files up to this number of bytes are stored, the default if 0. See AddOptions.ContentLimit.
+	limit int64
+	// How the file is opened for the comparison and where the messages go, see ReadOptions.
+	readOpts *ReadOptions
+}
+
+func (c contentChecker) withOptions(opts *AddOptions) FileChecker {
+	return contentChecker{limit: opts.ContentLimit, readOpts: &opts.ReadOptions}
+}
+
+func (c contentChecker) withVerifyOptions(opts *VerifyOptions) FileChecker {
+	return contentChecker{readOpts: &opts.ReadOptions}
+}
+
+func (c contentChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	limit := c.limit
+	if limit == 0 {
+		limit = defaultContentLimit
+	}
+	if fi.Size() > limit {
+		c.readOpts.logger().Printf(msg560, fqn, fi.Size(), limit)
+		return tooLargeMarker + strconv.FormatInt(fi.Size(), 10), nil
+	}
+	return inlineContent(fqn)
+}
+
+func (c contentChecker) ExecuteCheck(fqn string, data interface{}, _ os.FileInfo) error {
+	if data == nil {
+		// Recorded by a version without content storage, there is nothing to compare.
+		return nil
+	}
+	encoded, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	if len(encoded) == 0 {
+		// Recorded by a version without the marker, the file was too large when it was added.
+		return &notComparedError{"content not stored, the file exceeded the content limit"}
+	}
+	if strings.HasPrefix(encoded, tooLargeMarker) {
+		return &notComparedError{fmt.Sprintf("content not stored, %s bytes exceeded the content limit",
+			strings.TrimPrefix(encoded, tooLargeMarker))}
+	}
+	recorded, err := decodeInline(encoded)
+	if err != nil {
+		return fmt.Errorf("data corrupt")
+	}
+
+	f, err := c.readOpts.open(fqn)
+	if err != nil {
+		return fmt.Errorf("open file")
+	}
+	defer f.Close()
+	// A longer file only needs to be read up to the window after the recorded contents.
+	current, err := ioutil.ReadAll(io.LimitReader(f, int64(len(recorded))+contentWindow+1))
+	if err != nil {
+		return fmt.Errorf("read file")
+	}
+	if bytes.Equal(recorded, current) {
+		return nil
+	}
+	offset := 0
+	for offset < len(recorded) && offset < len(current) && recorded[offset] == current[offset] {
+		offset++
+	}
+	return fmt.Errorf("differs at byte %d, %w", offset,
+		&mismatchError{hexWindow(recorded, offset), hexWindow(current, offset)})
+}
+
+// Hexdump of the bytes around the offset, the byte at the offset is bracketed. A file that ends before the offset
+// shows "[eof]".
+func hexWindow(content []byte, offset int) string {
+	start := offset - contentWindow
+	if start < 0 {
+		start = 0
+	}
+	end := offset + contentWindow + 1
+	if end > len(content) {
+		end = len(content)
+	}
+	var buf bytes.Buffer
+	for i := start; i < end; i++ {
+		if i > start {
+			buf.WriteByte(' ')
+		}
+		if i == offset {
+			fmt.Fprintf(&buf, "[%02x]", content[i])
+		} else {
+			fmt.Fprintf(&buf, "%02x", content[i])
+		}
+	}
+	if offset >= len(content) {
+		if buf.Len() > 0 {
+			buf.WriteByte(' ')
+		}
+		buf.WriteString("[eof]")
+	}
+	return buf.String()
+}
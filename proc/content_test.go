@@ -0,0 +1,84 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The failed results of the check, by path.
+func failedResults(report *VerifyReport, check string) map[string]CheckResult {
+	results := make(map[string]CheckResult)
+	for _, section := range report.Sections {
+		for _, result := range section.Results {
+			if result.Check == check && result.Status == StatusFailed {
+				results[result.Path] = result
+			}
+		}
+	}
+	return results
+}
+
+func TestContentCheck(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	logger, out := newTestLogger()
+	dir := t.TempDir()
+	writeTestFile(t, dir, "small", "0123456789abcdefghij")
+	writeTestFile(t, dir, "large", "0123456789abcdefghijklmnopqrstuvwxyz")
+	opts := &AddOptions{Recursive: true, FileChecks: "content", DirChecks: "child", ContentLimit: 32,
+		ReadOptions: ReadOptions{Log: logger}}
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := filepath.Join(dir, "large")
+	if !strings.Contains(out.String(), "content of "+large+" not stored") {
+		t.Errorf("add logged %q, want the large file skipped", out.String())
+	}
+
+	// The first difference is reported with the bytes around it, the large file is not compared.
+	writeTestFile(t, dir, "small", "0123456789abXdefghij")
+	writeTestFile(t, dir, "large", "0123456789abXdefghijklmnopqrstuvwxyz")
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{ReadOptions: ReadOptions{Log: logger}}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := failedResults(report, "content")
+	if _, found := failed[large]; found || len(failed) != 1 {
+		t.Fatalf("failed content checks %v, want only the small file", failed)
+	}
+	notCompared := false
+	for _, result := range report.Sections[0].Results {
+		if result.Path == large && result.Check == "content" {
+			notCompared = result.Status == StatusNotCompared && strings.Contains(result.Detail, "36 bytes exceeded")
+		}
+	}
+	if !notCompared || report.NotCompared() != 1 {
+		t.Errorf("results %v, want the content check of the large file not compared", report.Sections[0].Results)
+	}
+	result := failed[filepath.Join(dir, "small")]
+	if !strings.Contains(result.Detail, "differs at byte 12") {
+		t.Errorf("detail %q, want the offset of the first difference", result.Detail)
+	}
+	if result.Expected != "34 35 36 37 38 39 61 62 [63] 64 65 66 67 68 69 6a" ||
+		result.Actual != "34 35 36 37 38 39 61 62 [58] 64 65 66 67 68 69 6a" {
+		t.Errorf("expected %q actual %q, want the bytes around the difference", result.Expected, result.Actual)
+	}
+
+	opts = &AddOptions{FileChecks: "content", DirChecks: "child", ContentLimit: maxContentLimit + 1}
+	err = AddFiles([]string{dir}, "other", opts, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/550)") {
+		t.Errorf("add with a content limit out of range: got error %v, want the range error", err)
+	}
+}
+
+func TestHexWindow(t *testing.T) {
+	content := []byte("abc")
+	if got := hexWindow(content, 1); got != "61 [62] 63" {
+		t.Errorf("window of a difference got %q", got)
+	}
+	if got := hexWindow(content, 3); got != "61 62 63 [eof]" {
+		t.Errorf("window of a shorter file got %q", got)
+	}
+}
@@ -0,0 +1,59 @@
+// +build aix linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package proc
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// Recorded value for files that are not device nodes.
+const noDevNode = "none"
+
+// Type devNodeChecker verifies the major and minor numbers of character and block devices.
+// A device node that is replaced by another device or by a regular file is reported.
+type devNodeChecker struct{}
+
+func init() {
+	fileChecks["devnode"] = devNodeChecker{}
+}
+
+func (d devNodeChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return devNode(fi)
+}
+
+func (d devNodeChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	expectedNode, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	actualNode, err := devNode(fi)
+	if err != nil {
+		return err
+	}
+	if expectedNode != actualNode {
+		return fmt.Errorf("expected %s actual %s", expectedNode, actualNode)
+	}
+	return nil
+}
+
+// Describe the device node as "c major:minor" or "b major:minor".
+func devNode(fi os.FileInfo) (string, error) {
+	kind := ""
+	switch {
+	case fi.Mode()&os.ModeCharDevice != 0:
+		kind = "c"
+	case fi.Mode()&os.ModeDevice != 0:
+		kind = "b"
+	default:
+		return noDevNode, nil
+	}
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("syscall")
+	}
+	rdev := uint64(sys.Rdev)
+	return fmt.Sprintf("%s %d:%d", kind, unix.Major(rdev), unix.Minor(rdev)), nil
+}
@@ -0,0 +1,177 @@
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+)
+
+const (
+	err470 = "(proc/470) read export %q:%w"
+	err475 = "(proc/475) read fileset %q:%w"
+)
+
+const (
+	msg480 = "%d added, %d removed, %d changed"
+)
+
+// Kinds of record changes between two exports.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// A record that differs between two exports.
+type RecordChange struct {
+	Path   string `json:"path"`
+	Change string `json:"change"`
+	// The checks whose recorded data differs, "checks" if the list of checks differs and "type" if the file type
+	// differs. Only for changed records.
+	Checks []string `json:"checks,omitempty"`
+}
+
+// Compare two exported filesets, e.g. the baselines in a repository before and after a change. No database is used.
+// The changes are sorted by path.
+func DiffExports(oldFile string, newFile string) ([]RecordChange, error) {
+	oldRecords, err := readExport(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	newRecords, err := readExport(newFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return diffRecords(oldRecords, newRecords), nil
+}
+
+// Compare two filesets of the database, e.g. a baseline and a later capture, without touching the filesystem.
+// The records only in the first fileset are reported as removed, the records only in the second one as added.
+// The changes are sorted by path.
+func DiffSets(a string, b string, tripDb *db.TriplineDb) ([]RecordChange, error) {
+	if err := checkFileset(a, tripDb); err != nil {
+		return nil, err
+	}
+	if err := checkFileset(b, tripDb); err != nil {
+		return nil, err
+	}
+	oldRecords, err := filesetRecords(a, tripDb)
+	if err != nil {
+		return nil, err
+	}
+	newRecords, err := filesetRecords(b, tripDb)
+	if err != nil {
+		return nil, err
+	}
+	return diffRecords(oldRecords, newRecords), nil
+}
+
+// The records of a fileset by path.
+func filesetRecords(fileset string, tripDb *db.TriplineDb) (map[string]*db.TriplineRecord, error) {
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return nil, fmt.Errorf(err475, fileset, err)
+	}
+	records := make(map[string]*db.TriplineRecord)
+	for i := range entries {
+		records[entries[i].Path] = &entries[i].Record
+	}
+	return records, nil
+}
+
+// The changes between the old and the new records, sorted by path.
+func diffRecords(oldRecords map[string]*db.TriplineRecord, newRecords map[string]*db.TriplineRecord) []RecordChange {
+	changes := make([]RecordChange, 0)
+	for path, oldRec := range oldRecords {
+		newRec, found := newRecords[path]
+		if !found {
+			changes = append(changes, RecordChange{Path: path, Change: ChangeRemoved})
+			continue
+		}
+		if checks := changedChecks(oldRec, newRec); len(checks) > 0 {
+			changes = append(changes, RecordChange{Path: path, Change: ChangeChanged, Checks: checks})
+		}
+	}
+	for path := range newRecords {
+		if _, found := oldRecords[path]; !found {
+			changes = append(changes, RecordChange{Path: path, Change: ChangeAdded})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes
+}
+
+// Write the changes as a reviewable summary, a line per record followed by the totals.
+// The added records are marked with "+", the removed ones with "-" and the changed ones with "~".
+func WriteDiff(w io.Writer, changes []RecordChange) error {
+	marks := map[string]string{ChangeAdded: "+", ChangeRemoved: "-", ChangeChanged: "~"}
+	counts := make(map[string]int)
+	for _, change := range changes {
+		counts[change.Change]++
+		var err error
+		if change.Change == ChangeChanged {
+			_, err = fmt.Fprintf(w, "%s %s %v\n", marks[change.Change], change.Path, change.Checks)
+		} else {
+			_, err = fmt.Fprintf(w, "%s %s\n", marks[change.Change], change.Path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(w, msg480+"\n", counts[ChangeAdded], counts[ChangeRemoved], counts[ChangeChanged])
+	return err
+}
+
+// Read an export, the records by path.
+func readExport(file string) (map[string]*db.TriplineRecord, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, fmt.Errorf(err470, file, err)
+	}
+	defer f.Close()
+	entries, _, err := decodeExport(f)
+	if err != nil {
+		return nil, fmt.Errorf(err470, file, err)
+	}
+	records := make(map[string]*db.TriplineRecord)
+	for _, entry := range entries {
+		rec := &db.TriplineRecord{}
+		err := json.Unmarshal(entry.Record, rec)
+		if err != nil {
+			return nil, fmt.Errorf(err470, file, fmt.Errorf("%s:%w", entry.Path, err))
+		}
+		records[entry.Path] = rec
+	}
+	return records, nil
+}
+
+// The checks whose data differs between the records, sorted.
+func changedChecks(oldRec *db.TriplineRecord, newRec *db.TriplineRecord) []string {
+	checks := make([]string, 0)
+	if oldRec.IsDir != newRec.IsDir || oldRec.Type != newRec.Type {
+		checks = append(checks, "type")
+	}
+	if !reflect.DeepEqual(oldRec.Checks, newRec.Checks) {
+		checks = append(checks, "checks")
+	}
+	names := make(map[string]bool)
+	for name := range oldRec.Data {
+		names[name] = true
+	}
+	for name := range newRec.Data {
+		names[name] = true
+	}
+	dataChecks := make([]string, 0)
+	for name := range names {
+		if !reflect.DeepEqual(oldRec.Data[name], newRec.Data[name]) {
+			dataChecks = append(dataChecks, name)
+		}
+	}
+	sort.Strings(dataChecks)
+	return append(checks, dataChecks...)
+}
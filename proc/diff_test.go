@@ -0,0 +1,51 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDiffSets(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "changed", "removed", "same")
+	opts := &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "child"}
+	err := AddFiles([]string{dir}, "a", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "changed", "a longer content")
+	writeTestFiles(t, dir, "added")
+	err = os.Remove(filepath.Join(dir, "removed"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddFiles([]string{dir}, "b", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := DiffSets("a", "b", tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []RecordChange{
+		{Path: dir, Change: ChangeChanged, Checks: []string{"child"}},
+		{Path: filepath.Join(dir, "added"), Change: ChangeAdded},
+		{Path: filepath.Join(dir, "changed"), Change: ChangeChanged, Checks: []string{"size"}},
+		{Path: filepath.Join(dir, "removed"), Change: ChangeRemoved},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("changes %+v, want %+v", changes, want)
+	}
+
+	_, err = DiffSets("a", "missing", tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/475)") {
+		t.Errorf("diff with an unknown fileset: got error %v, want the read error", err)
+	}
+}
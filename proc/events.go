@@ -0,0 +1,35 @@
+package proc
+
+// Event types.
+const (
+	EventProgress = "progress"
+	EventResult   = "result"
+)
+
+// Event is a live notification of the progress or of a check result, meant for user interfaces.
+// It is distinct from the human readable output.
+type Event struct {
+	Type   string `json:"type"`
+	Done   int    `json:"done,omitempty"`
+	Total  int    `json:"total,omitempty"`
+	Path   string `json:"path,omitempty"`
+	Check  string `json:"check,omitempty"`
+	Ok     *bool  `json:"ok,omitempty"`
+	Detail string `json:"detail,omitempty"`
+	// The kind of the recorded path that is missing, "file" or "dir".
+	Missing string `json:"missing,omitempty"`
+	// How a file with a failed content check changed, "replaced" or "modified".
+	Change string `json:"change,omitempty"`
+	// The changed lines of a file with inline contents.
+	Diff []string `json:"diff,omitempty"`
+}
+
+// EventSink receives the events of an operation.
+type EventSink func(e *Event)
+
+// Send the event to the sink, if there is one.
+func (sink EventSink) emit(e *Event) {
+	if sink != nil {
+		sink(e)
+	}
+}
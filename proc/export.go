@@ -0,0 +1,219 @@
+package proc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"io"
+	"log"
+	"reflect"
+)
+
+const (
+	err380 = "(proc/380) import fileset %q:%w"
+	err560 = "(proc/560) export fileset %q:%w"
+	err570 = "(proc/570) import fileset %q, the fileset is not empty, use overwrite to replace its records"
+	err580 = "(proc/580) export version %d is not supported, this version of tripline reads version %d"
+	err590 = "(proc/590) import fileset %q, the export does not match the settings of the fileset"
+	err595 = "(proc/595) import fileset %q, the export has no fileset settings, export the fileset again"
+)
+
+const (
+	msg380 = "%d records imported"
+)
+
+// A record in an exported fileset. The record is kept in its stored json form so an import reproduces the fileset
+// hash and the signatures remain valid. The path of a fileset with hashed paths is the keyed hash of the path, the
+// record has the encrypted path.
+type exportEntry struct {
+	Path   string          `json:"path"`
+	Record json.RawMessage `json:"record"`
+}
+
+// The version of the export document, it is incremented when the format changes. Version 2 added the settings of
+// the fileset, the saved queries are an optional part of them.
+const exportVersion = 2
+
+// The settings of an exported fileset that its records depend on. The resolve and hashed paths settings and the saved
+// queries are part of the fileset hash, the path style and the salt of the hashed paths are needed to read the
+// records.
+type exportMeta struct {
+	Resolve      bool                      `json:"resolve,omitempty"`
+	HomeRelative bool                      `json:"homeRelative,omitempty"`
+	HashPaths    bool                      `json:"hashPaths,omitempty"`
+	PathSalt     string                    `json:"pathSalt,omitempty"`
+	PathCheck    string                    `json:"pathCheck,omitempty"`
+	Queries      map[string]*db.SavedQuery `json:"queries,omitempty"`
+}
+
+func newExportMeta(meta *db.FilesetMeta) exportMeta {
+	exported := exportMeta{meta.Resolve, meta.HomeRelative, meta.HashPaths, meta.PathSalt, meta.PathCheck, nil}
+	if len(meta.Queries) > 0 {
+		// A fileset whose last query was deleted equals one without queries.
+		exported.Queries = meta.Queries
+	}
+	return exported
+}
+
+// A fileset with one of the settings cannot be imported from an export without the settings.
+func (m exportMeta) isDefault() bool {
+	return m.equal(exportMeta{})
+}
+
+// Check if the settings are the same, the queries are compared by value.
+func (m exportMeta) equal(other exportMeta) bool {
+	return reflect.DeepEqual(m, other)
+}
+
+// An exported fileset, the records with the version of the format. The version comes first so a reader can detect
+// the format before it reads the records.
+type exportDocument struct {
+	Version int           `json:"version"`
+	Fileset string        `json:"fileset"`
+	Meta    *exportMeta   `json:"meta,omitempty"`
+	Records []exportEntry `json:"records"`
+}
+
+// Write all the records of a fileset as an indented json export document, e.g. to keep a baseline under version
+// control or to move it to another machine. The import reproduces the fileset with its settings, its signatures
+// remain valid. The records of a fileset with hashed paths are exported as stored, the path key is not needed.
+func ExportSet(fileset string, w io.Writer, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	entries, err := tripDb.ListRawTriplineRecords(fileset)
+	if err != nil {
+		return fmt.Errorf(err560, fileset, err)
+	}
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return fmt.Errorf(err560, fileset, err)
+	}
+	exported := newExportMeta(meta)
+	doc := exportDocument{exportVersion, fileset, &exported, make([]exportEntry, 0, len(entries))}
+	for _, entry := range entries {
+		doc.Records = append(doc.Records, exportEntry{entry.Path, entry.Record})
+	}
+	jsn, err := json.MarshalIndent(&doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf(err560, fileset, err)
+	}
+	_, err = w.Write(append(jsn, '\n'))
+	if err != nil {
+		return fmt.Errorf(err560, fileset, err)
+	}
+	return nil
+}
+
+// Read the records of an export and the settings of the fileset. This is an export document or a plain json array of
+// records, the format of list --json. The settings are nil if the export has none, a version 1 document or an array.
+func decodeExport(r io.Reader) ([]exportEntry, *exportMeta, error) {
+	var raw json.RawMessage
+	err := json.NewDecoder(r).Decode(&raw)
+	if err != nil {
+		return nil, nil, err
+	}
+	var entries []exportEntry
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '[' {
+		err = json.Unmarshal(raw, &entries)
+		return entries, nil, err
+	}
+	var doc exportDocument
+	err = json.Unmarshal(raw, &doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	// A document of a later version might have records that are not understood, it is not read partially.
+	if doc.Version < 1 || doc.Version > exportVersion {
+		return nil, nil, fmt.Errorf(err580, doc.Version, exportVersion)
+	}
+	return doc.Records, doc.Meta, nil
+}
+
+// Marshal the records to a json array, the whole array is indented in pretty mode so the result is still a single
+// json document.
+func marshalExport(entries []exportEntry, pretty bool) ([]byte, error) {
+	if pretty {
+		return json.MarshalIndent(entries, "", "  ")
+	}
+	return json.Marshal(entries)
+}
+
+// Read an exported fileset, see decodeExport, and add the records to the fileset.
+// The fileset is created if it does not exist. A fileset with records is only imported into if the overwrite flag is
+// set, the imported records then replace the existing ones with the same path and the other records are kept.
+// The settings of the export become the settings of a fileset without records, the settings of a fileset with
+// records must match them. An export without settings is only imported into a fileset with the default settings.
+func ImportSet(fileset string, r io.Reader, overwrite bool, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	exists, err := tripDb.HasFileset(fileset)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	empty := true
+	if exists {
+		existing, err := tripDb.ListRawTriplineRecords(fileset)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, err)
+		}
+		empty = len(existing) == 0
+	}
+	if !empty && !overwrite {
+		return fmt.Errorf(err570, fileset)
+	}
+
+	entries, imported, err := decodeExport(r)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return fmt.Errorf(err380, fileset, err)
+	}
+	switch {
+	case imported == nil:
+		if !newExportMeta(meta).isDefault() {
+			return fmt.Errorf(err595, fileset)
+		}
+		// The records of a home relative fileset cannot be told apart from a fileset with absolute paths.
+		for _, entry := range entries {
+			if isHomeRelative(entry.Path) {
+				return fmt.Errorf(err595, fileset)
+			}
+		}
+	case !empty:
+		if !newExportMeta(meta).equal(*imported) {
+			return fmt.Errorf(err590, fileset)
+		}
+	case exists || len(entries) > 0:
+		meta.Resolve, meta.HomeRelative = imported.Resolve, imported.HomeRelative
+		meta.HashPaths, meta.PathSalt, meta.PathCheck = imported.HashPaths, imported.PathSalt, imported.PathCheck
+		meta.Queries = imported.Queries
+		err = tripDb.SaveFilesetMeta(fileset, meta)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, err)
+		}
+	}
+	for _, entry := range entries {
+		// An indented export is compacted to the stored form.
+		var jsn bytes.Buffer
+		err := json.Compact(&jsn, entry.Record)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, err)
+		}
+		err = tripDb.AddRawTriplineRecord(entry.Path, jsn.Bytes(), fileset, overwrite)
+		if err != nil {
+			return fmt.Errorf(err380, fileset, fmt.Errorf("%s:%w", entry.Path, err))
+		}
+	}
+	loggerOrStd(logger).Printf(msg380, len(entries))
+	if len(entries) == 0 {
+		return nil
+	}
+	return touchFileset(fileset, tripDb)
+}
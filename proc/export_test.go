@@ -0,0 +1,165 @@
+package proc
+
+import (
+	"bytes"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Open a database with the path key, the transaction takes the key when it starts.
+func openKeyedDb(t *testing.T, key string) *db.TriplineDb {
+	t.Helper()
+	tripDb := dbtest.Open(t, false)
+	if len(key) > 0 {
+		tripDb.SetPathKey(key)
+	}
+	err := tripDb.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cleanups run in reverse, the transaction ends before the database is closed.
+	t.Cleanup(func() { _ = tripDb.Rollback() })
+	return tripDb
+}
+
+// Add the directory to a new fileset, sign it and export it.
+func exportSignedSet(t *testing.T, tripDb *db.TriplineDb, dir string, opts *AddOptions) *bytes.Buffer {
+	t.Helper()
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := newTestLogger()
+	_, err = SignSet("test", "secret", false, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	err = ExportSet("test", &export, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &export
+}
+
+func TestExportImportSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signature takes seconds")
+	}
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "a/x", "y")
+
+	tests := []struct {
+		name string
+		opts AddOptions
+	}{
+		{"resolve", AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime", Resolve: true}},
+		{"hash paths", AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime", HashPaths: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			srcDb := openKeyedDb(t, "path key")
+			logger, _ := newTestLogger()
+			export := exportSignedSet(t, srcDb, dir, &test.opts)
+			want, err := srcDb.GetFilesetMeta("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// The import needs neither the path key nor the password, the signature remains valid.
+			tripDb := openKeyedDb(t, "")
+			err = ImportSet("test", export, false, logger, tripDb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := tripDb.GetFilesetMeta("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !newExportMeta(got).equal(newExportMeta(want)) {
+				t.Errorf("imported settings %+v, want %+v", newExportMeta(got), newExportMeta(want))
+			}
+			signature, err := srcDb.FilesetSignature("test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = tripDb.StoreFilesetSignature("test", signature)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = VerifySetSignature("test", "secret", tripDb)
+			if err != nil {
+				t.Errorf("signature of the import: %v", err)
+			}
+			err = tripDb.Commit()
+			if err != nil {
+				t.Fatal(err)
+			}
+			tripDb.SetPathKey("path key")
+			err = tripDb.Begin(false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := recordedPaths(t, tripDb, "test", dir), recordedPaths(t, srcDb, "test", dir); !equalPaths(got, want) {
+				t.Errorf("imported %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestImportSettingsMismatch(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	err := AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "modtime", Resolve: true}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddFiles([]string{dir}, "plain", &AddOptions{FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var export bytes.Buffer
+	err = ExportSet("plain", &export, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The imported fileset keeps its style, the next add in the same style succeeds.
+	var resolved bytes.Buffer
+	err = ExportSet("test", &resolved, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ImportSet("copy", &resolved, false, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, dir, "z")
+	opts := &AddOptions{FileChecks: "size", DirChecks: "modtime", Resolve: true}
+	err = AddFiles([]string{filepath.Join(dir, "z")}, "copy", opts, tripDb)
+	if err != nil {
+		t.Errorf("add to the imported fileset: %v", err)
+	}
+
+	// The records of a fileset with other settings are not mixed in.
+	err = ImportSet("test", &export, true, logger, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/590)") {
+		t.Errorf("import with other settings: got error %v, want the settings error", err)
+	}
+	// A version 1 export has no settings, it cannot restore them.
+	v1 := `{"version": 1, "fileset": "test", "records": []}`
+	err = ImportSet("test", strings.NewReader(v1), true, logger, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/595)") {
+		t.Errorf("import of a version 1 export: got error %v, want the missing settings error", err)
+	}
+	v1 = `{"version": 1, "fileset": "home", "records": [{"path": "~/x", "record": {"checks": []}}]}`
+	err = ImportSet("home", strings.NewReader(v1), false, logger, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/595)") {
+		t.Errorf("import of a home relative version 1 export: got error %v, want the missing settings error", err)
+	}
+}
@@ -0,0 +1,14 @@
+// +build !aix,!linux,!darwin,!dragonfly,!freebsd,!openbsd,!netbsd,!solaris
+
+package proc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// The identity of a directory, its path with the symbolic links resolved. There are no inode numbers on this
+// platform.
+func fileIdentity(fqn string, fi os.FileInfo) (string, error) {
+	return filepath.EvalSymlinks(fqn)
+}
@@ -0,0 +1,19 @@
+// +build aix linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// The identity of a directory, the device and the inode number. A directory that is reached through several
+// symbolic links has a single identity.
+func fileIdentity(fqn string, fi os.FileInfo) (string, error) {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("syscall")
+	}
+	return fmt.Sprintf("%d:%d", uint64(sys.Dev), uint64(sys.Ino)), nil
+}
@@ -0,0 +1,29 @@
+package proc
+
+import (
+	"os"
+)
+
+// Classify the type of a file based on its mode.
+// A regular file that is swapped for a device or a named pipe is still "not a directory", the classification
+// distinguishes between these.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsRegular():
+		return "regular"
+	case mode.IsDir():
+		return "dir"
+	case mode&os.ModeSymlink != 0:
+		return "symlink"
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "chardevice"
+	case mode&os.ModeDevice != 0:
+		return "blockdevice"
+	default:
+		return "irregular"
+	}
+}
@@ -0,0 +1,84 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	err600 = "(proc/600) fsck fileset %q:%w"
+)
+
+const (
+	msg600 = "%s:fsck:%s"
+)
+
+// Check the consistency of the records in a fileset without looking at the filesystem.
+// Reports records with unknown checks or missing check data. When a root directory is provided, the records that
+// are located outside of the root are reported as well, a fileset should not reach beyond its intended scope.
+// Returns the number of problems found.
+func Fsck(fileset string, root string, logger *log.Logger, tripDb *db.TriplineDb) (int, error) {
+	logger = loggerOrStd(logger)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return 0, err
+	}
+
+	if len(root) > 0 {
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			return 0, fmt.Errorf(err040, root, err)
+		}
+		root = absRoot
+	}
+
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return 0, fmt.Errorf(err600, fileset, err)
+	}
+
+	problems := 0
+	for _, entry := range entries {
+		if len(root) > 0 {
+			path, err := expandHome(entry.Path)
+			if err != nil {
+				return 0, fmt.Errorf(err600, fileset, err)
+			}
+			if !isUnder(path, root) {
+				logger.Printf(msg600, entry.Path, fmt.Sprintf("outside root %q", root))
+				problems++
+			}
+		}
+
+		checks := fileChecks
+		if entry.Record.IsDir {
+			checks = dirChecks
+		}
+		for _, checkName := range entry.Record.Checks {
+			_, available := platformChecks[checkName]
+			if _, found := checks[checkName]; !found && !available {
+				logger.Printf(msg600, entry.Path, fmt.Sprintf("unknown check %q", checkName))
+				problems++
+				continue
+			}
+			if _, found := entry.Record.Data[checkName]; !found && !isPending(&entry.Record, checkName) {
+				logger.Printf(msg600, entry.Path, fmt.Sprintf("no data for check %q", checkName))
+				problems++
+			}
+		}
+	}
+	return problems, nil
+}
+
+// Check if the path is the root directory itself or is located below it.
+func isUnder(path string, root string) bool {
+	if path == root {
+		return true
+	}
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	return strings.HasPrefix(path, root)
+}
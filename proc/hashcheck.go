@@ -0,0 +1,52 @@
+package proc
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/blake2s"
+	"hash"
+	"os"
+)
+
+// The hash algorithms of the content digests, the name of the algorithm is the name of the check.
+var hashAlgorithms = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+	"blake2b": func() hash.Hash {
+		// Only fails on a key that is too long.
+		h, _ := blake2b.New512(nil)
+		return h
+	},
+	"blake2s": func() hash.Hash {
+		h, _ := blake2s.New256(nil)
+		return h
+	},
+}
+
+// Type hashChecker verifies a digest of the file contents with the algorithm it was created for.
+// The records list their checks by name, so a record is verified with the algorithm it was added with even when
+// the default checks change.
+type hashChecker struct {
+	algorithm string
+}
+
+func (d hashChecker) newHash() hash.Hash {
+	return hashAlgorithms[d.algorithm]()
+}
+
+func (d hashChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	digests, err := hashFile(fqn, []contentHasher{d}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return digests[0], nil
+}
+
+func (d hashChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	digests, err := hashFile(fqn, []contentHasher{d}, nil)
+	if err != nil {
+		return err
+	}
+	return compareDigest(data, digests[0])
+}
@@ -0,0 +1,141 @@
+package proc
+
+import (
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"time"
+)
+
+const (
+	err990 = "(proc/990) io buffer size %d out of range %d..%d"
+)
+
+// Range of the buffer size used to read the file contents, and the default, the buffer size of io.Copy.
+const (
+	minIOBufferSize     = 4 * 1024
+	maxIOBufferSize     = 16 * 1024 * 1024
+	defaultIOBufferSize = 32 * 1024
+)
+
+// How the files are read by a command and where its messages go. The zero value, or nil, reads with the default
+// buffer size and without retries, and logs to the standard logger.
+type ReadOptions struct {
+	// Size of the buffer used to read the file contents in bytes, see CheckIOBufferSize. The default if 0.
+	// A larger buffer can improve the throughput on fast storage with large files.
+	IOBufferSize int
+	// Number of times a stat or an open of a file is retried after a transient error, see CheckRetries.
+	Retries int
+	// Delay before the first retry, it doubles after each retry. The default if 0.
+	RetryDelay time.Duration
+	// Logger of the messages of the command, e.g. the skipped files and the successful retries. The standard logger
+	// if nil.
+	Log *log.Logger
+	// Stops the operation at the next file when it is set, see Interrupt. Never set if nil.
+	Interrupt *Interrupt
+}
+
+// Check the size of the buffer used to read the file contents, in bytes.
+func CheckIOBufferSize(size int64) error {
+	if size < minIOBufferSize || size > maxIOBufferSize {
+		return fmt.Errorf(err990, size, minIOBufferSize, maxIOBufferSize)
+	}
+	return nil
+}
+
+// The size of the buffer used to read the file contents.
+func (ro *ReadOptions) bufferSize() int {
+	if ro == nil || ro.IOBufferSize == 0 {
+		return defaultIOBufferSize
+	}
+	return ro.IOBufferSize
+}
+
+// The logger of the messages of the command.
+func (ro *ReadOptions) logger() *log.Logger {
+	if ro == nil {
+		return loggerOrStd(nil)
+	}
+	return loggerOrStd(ro.Log)
+}
+
+// Returns ErrInterrupted when the operation should stop.
+func (ro *ReadOptions) interrupted() error {
+	if ro == nil {
+		return nil
+	}
+	return ro.Interrupt.check()
+}
+
+// Implemented by the checks that hash the file contents.
+// The contents are read once for all the hash checks of a file, each hash receives a copy of the contents.
+type contentHasher interface {
+	newHash() hash.Hash
+}
+
+// Read the file once and feed the contents to the hashes of all the hashers.
+// Returns the hex encoded digests in the order of the hashers.
+func hashFile(fqn string, hashers []contentHasher, ro *ReadOptions) ([]string, error) {
+	f, err := ro.open(fqn)
+	if err != nil {
+		return nil, fmt.Errorf("open file")
+	}
+	defer f.Close()
+
+	hashes := make([]hash.Hash, len(hashers))
+	writers := make([]io.Writer, len(hashers))
+	for i, hasher := range hashers {
+		hashes[i] = hasher.newHash()
+		writers[i] = hashes[i]
+	}
+	// Hide the WriterTo implementation of the file, otherwise the buffer is not used.
+	reader := struct{ io.Reader }{f}
+	if _, err := io.CopyBuffer(io.MultiWriter(writers...), reader, make([]byte, ro.bufferSize())); err != nil {
+		return nil, fmt.Errorf("calculate hash")
+	}
+
+	digests := make([]string, len(hashes))
+	for i, h := range hashes {
+		digests[i] = fmt.Sprintf("%x", h.Sum(nil))
+	}
+	return digests, nil
+}
+
+// Calculate the digests of all the hash checks in the list in a single pass over the file.
+// Returns the digests by check name, nil if the list contains no hash checks.
+func hashChecks(fqn string, checks []string, checkers map[string]FileChecker, ro *ReadOptions) (map[string]string, error) {
+	names := make([]string, 0)
+	hashers := make([]contentHasher, 0)
+	for _, checkName := range checks {
+		if hasher, ok := checkers[checkName].(contentHasher); ok {
+			names = append(names, checkName)
+			hashers = append(hashers, hasher)
+		}
+	}
+	if len(hashers) == 0 {
+		return nil, nil
+	}
+
+	digests, err := hashFile(fqn, hashers, ro)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for i, checkName := range names {
+		result[checkName] = digests[i]
+	}
+	return result, nil
+}
+
+// Compare the recorded digest with the actual one.
+func compareDigest(data interface{}, actualHash string) error {
+	expectedHash, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	if expectedHash != actualHash {
+		return &mismatchError{expectedHash, actualHash}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package proc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// Create a file with random contents of the size.
+func writeRandomFile(tb testing.TB, dir string, name string, size int) string {
+	tb.Helper()
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fqn := filepath.Join(dir, name)
+	err = ioutil.WriteFile(fqn, content, 0600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return fqn
+}
+
+func TestHashFileBufferSizes(t *testing.T) {
+	dir := t.TempDir()
+	fqn := writeRandomFile(t, dir, "file", 100*1024+7)
+	content, err := ioutil.ReadFile(fqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf("%x", sha256.Sum256(content))
+
+	for _, size := range []int64{minIOBufferSize, 32 * 1024, 1024 * 1024} {
+		err := CheckIOBufferSize(size)
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests, err := hashFile(fqn, []contentHasher{hashChecker{"sha256"}}, &ReadOptions{IOBufferSize: int(size)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if digests[0] != want {
+			t.Errorf("buffer size %d: digest %s, want %s", size, digests[0], want)
+		}
+	}
+	for _, size := range []int64{minIOBufferSize - 1, maxIOBufferSize + 1} {
+		if CheckIOBufferSize(size) == nil {
+			t.Errorf("buffer size %d accepted", size)
+		}
+	}
+}
+
+// The throughput of the sha256 check by buffer size, the default is the buffer size of io.Copy. The file is read
+// from the page cache after the first iteration, so it measures the overhead of the reads rather than the disk.
+// Run it on the target storage with a file larger than the memory to see the effect of the disk, e.g.
+//
+//	go test -run - -bench HashFileBufferSize -benchtime 5x ./proc
+func BenchmarkHashFileBufferSize(b *testing.B) {
+	fqn := writeRandomFile(b, b.TempDir(), "file", 64*1024*1024)
+	for _, size := range []int{4 * 1024, 32 * 1024, 256 * 1024, 1024 * 1024, 4 * 1024 * 1024} {
+		b.Run(fmt.Sprintf("%dKB", size/1024), func(b *testing.B) {
+			ro := &ReadOptions{IOBufferSize: size}
+			b.SetBytes(64 * 1024 * 1024)
+			for i := 0; i < b.N; i++ {
+				_, err := hashFile(fqn, []contentHasher{hashChecker{"sha256"}}, ro)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
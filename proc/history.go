@@ -0,0 +1,74 @@
+package proc
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"sort"
+	"strings"
+)
+
+const (
+	err980 = "(proc/980) verify history fileset %q:%w"
+)
+
+const (
+	msg980 = "%s %d entries %d failures %s"
+)
+
+// Append the failure counts per check of the report to the verification history of the fileset.
+func RecordVerifyRun(fileset string, report *VerifyReport, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	run := &db.VerifyRun{Failures: make(map[string]int)}
+	for _, section := range report.Sections {
+		run.Entries += section.Entries
+		for _, result := range section.Results {
+			if result.Status == StatusFailed {
+				run.Failures[result.Check]++
+			}
+		}
+	}
+	err := tripDb.AppendVerifyRun(fileset, run)
+	if err != nil {
+		return fmt.Errorf(err980, fileset, err)
+	}
+	return nil
+}
+
+// Print the verification history of a fileset, oldest first.
+// Each run is printed as the timestamp, the number of entries, the number of failures and the failures per check.
+// In json mode each run is printed as a json object on a line of its own.
+func History(fileset string, asJSON bool, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	runs, err := tripDb.ListVerifyRuns(fileset)
+	if err != nil {
+		return fmt.Errorf(err980, fileset, err)
+	}
+	for _, run := range runs {
+		if asJSON {
+			jsn, err := json.Marshal(run)
+			if err != nil {
+				return fmt.Errorf(err980, fileset, err)
+			}
+			logger.Printf(msg090, jsn)
+			continue
+		}
+		checks := make([]string, 0, len(run.Failures))
+		total := 0
+		for check, count := range run.Failures {
+			checks = append(checks, fmt.Sprintf("%s=%d", check, count))
+			total += count
+		}
+		sort.Strings(checks)
+		logger.Println(strings.TrimSpace(fmt.Sprintf(msg980, run.Timestamp, run.Entries, total, strings.Join(checks, ","))))
+	}
+	return nil
+}
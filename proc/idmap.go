@@ -0,0 +1,49 @@
+package proc
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	err310 = "(proc/310) invalid id mapping %q, expected FROM:TO[:COUNT]"
+)
+
+// Translation of a range of recorded user or group ids to the ids on the verifying system, e.g. the subuid range
+// of a container. Count ids starting at From are mapped to the ids starting at To.
+type IdMap struct {
+	From  int
+	To    int
+	Count int
+}
+
+// Parse an id mapping of the form "FROM:TO" or "FROM:TO:COUNT", e.g. "100000:0:65536" to map a container range.
+func ParseIdMap(mapping string) (IdMap, error) {
+	parts := strings.Split(mapping, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return IdMap{}, fmt.Errorf(err310, mapping)
+	}
+	values := []int{0, 0, 1}
+	for i, part := range parts {
+		value, err := strconv.Atoi(part)
+		if err != nil || value < 0 {
+			return IdMap{}, fmt.Errorf(err310, mapping)
+		}
+		values[i] = value
+	}
+	if values[2] < 1 {
+		return IdMap{}, fmt.Errorf(err310, mapping)
+	}
+	return IdMap{values[0], values[1], values[2]}, nil
+}
+
+// Translate a recorded id using the first mapping that contains it, unmapped ids are left alone.
+func remapId(maps []IdMap, id int) int {
+	for _, m := range maps {
+		if id >= m.From && id < m.From+m.Count {
+			return m.To + id - m.From
+		}
+	}
+	return id
+}
@@ -0,0 +1,107 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	err540 = "(proc/540) ignore file %q line %d:%w"
+)
+
+// The name of the file with the exclusion rules of a directory and its subdirectories.
+const ignoreFile = ".triplineignore"
+
+// A rule of an ignore file. A glob without a separator matches the base name of the files and directories at any
+// depth below the directory of the ignore file, a glob with a separator is anchored, it matches the path relative
+// to the directory. A rule that ends in "/" only matches directories.
+type ignoreRule struct {
+	dir      string
+	glob     string
+	anchored bool
+	dirOnly  bool
+}
+
+func (r *ignoreRule) matches(fqn string, isDir bool) bool {
+	if r.dirOnly && !isDir {
+		return false
+	}
+	if !r.anchored {
+		ok, _ := filepath.Match(r.glob, filepath.Base(fqn))
+		return ok
+	}
+	rel, err := filepath.Rel(r.dir, fqn)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return false
+	}
+	ok, _ := filepath.Match(r.glob, filepath.ToSlash(rel))
+	return ok
+}
+
+// Parse the rules of an ignore file in the directory: a glob per line, blank lines and lines starting with "#" are
+// skipped. There is no negation.
+func parseIgnore(r io.Reader, dir string, name string) ([]ignoreRule, error) {
+	var rules []ignoreRule
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if len(text) == 0 || strings.HasPrefix(text, "#") {
+			continue
+		}
+		rule := ignoreRule{dir: dir}
+		if strings.HasSuffix(text, "/") {
+			rule.dirOnly = true
+			text = strings.TrimRight(text, "/")
+		}
+		// A leading separator anchors a glob that has no other separator, e.g. "/build".
+		rule.anchored = strings.Contains(text, "/")
+		rule.glob = strings.TrimLeft(text, "/")
+		if len(rule.glob) == 0 {
+			continue
+		}
+		if _, err := filepath.Match(rule.glob, ""); err != nil {
+			return nil, fmt.Errorf(err540, name, line, err)
+		}
+		rules = append(rules, rule)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf(err540, name, line, err)
+	}
+	return rules, nil
+}
+
+// The rules that apply below the directory, the rules of the parent directories followed by the rules of the
+// ignore file in the directory, if there is one.
+func readIgnore(dir string, inherited []ignoreRule) ([]ignoreRule, error) {
+	name := filepath.Join(dir, ignoreFile)
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return inherited, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf(err540, name, 0, err)
+	}
+	defer f.Close()
+	rules, err := parseIgnore(f, dir, name)
+	if err != nil {
+		return nil, err
+	}
+	// A new slice, the sibling directories share the inherited rules.
+	return append(append([]ignoreRule{}, inherited...), rules...), nil
+}
+
+// The first rule that matches the file or directory, nil if none matches.
+func matchingIgnoreRule(fqn string, isDir bool, rules []ignoreRule) *ignoreRule {
+	for i := range rules {
+		if rules[i].matches(fqn, isDir) {
+			return &rules[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseIgnore(t *testing.T) {
+	input := `
+# build output
+*.tmp
+
+build/
+  /cache
+docs/*.bak
+`
+	dir := filepath.FromSlash("/project")
+	rules, err := parseIgnore(strings.NewReader(input), dir, ".triplineignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []ignoreRule{
+		{dir: dir, glob: "*.tmp"},
+		{dir: dir, glob: "build", dirOnly: true},
+		{dir: dir, glob: "cache", anchored: true},
+		{dir: dir, glob: "docs/*.bak", anchored: true},
+	}
+	if len(rules) != len(want) {
+		t.Fatalf("parsed %+v, want %+v", rules, want)
+	}
+	for i := range want {
+		if rules[i] != want[i] {
+			t.Errorf("rule %d is %+v, want %+v", i, rules[i], want[i])
+		}
+	}
+}
+
+func TestParseIgnoreBadGlob(t *testing.T) {
+	_, err := parseIgnore(strings.NewReader("ok\n[\n"), "/project", ".triplineignore")
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("got error %v, want an error on line 2", err)
+	}
+}
+
+func TestIgnoreRuleMatches(t *testing.T) {
+	dir := filepath.FromSlash("/project")
+	rules, err := parseIgnore(strings.NewReader("*.tmp\nbuild/\n/cache\ndocs/*.bak\n"), dir, ".triplineignore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		path  string
+		isDir bool
+		want  string
+	}{
+		// A glob without a separator matches the base name at any depth.
+		{"/project/a.tmp", false, "*.tmp"},
+		{"/project/sub/deeper/b.tmp", false, "*.tmp"},
+		// A directory only rule does not match files.
+		{"/project/build", true, "build"},
+		{"/project/sub/build", true, "build"},
+		{"/project/build", false, ""},
+		// An anchored rule matches relative to the directory of the ignore file.
+		{"/project/cache", true, "cache"},
+		{"/project/sub/cache", true, ""},
+		{"/project/docs/old.bak", false, "docs/*.bak"},
+		{"/project/sub/docs/old.bak", false, ""},
+		// The rules do not apply outside the directory.
+		{"/other/cache", true, ""},
+		{"/project/a.txt", false, ""},
+	}
+	for _, test := range tests {
+		got := ""
+		if rule := matchingIgnoreRule(filepath.FromSlash(test.path), test.isDir, rules); rule != nil {
+			got = rule.glob
+		}
+		if got != test.want {
+			t.Errorf("%s matched %q, want %q", test.path, got, test.want)
+		}
+	}
+}
+
+func TestAddFilesIgnoreFiles(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir,
+		"keep.txt",
+		"skip.tmp",
+		"build/out.bin",
+		"sub/keep.txt",
+		"sub/skip.tmp",
+		"sub/local.log",
+		"sub/build/out.bin",
+		"other/local.log",
+	)
+	writeTestFile(t, dir, ".triplineignore", "# parent rules\n*.tmp\nbuild/\n")
+	writeTestFile(t, dir, "sub/.triplineignore", "*.log\n")
+
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The rules of the parent apply to the subdirectory, the rules of the subdirectory only below it.
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{".", ".triplineignore", "keep.txt", "other", "other/local.log", "sub", "sub/.triplineignore", "sub/keep.txt"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+}
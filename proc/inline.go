@@ -0,0 +1,158 @@
+package proc
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// The record data key of the compressed contents of a small file, see AddOptions.InlineUnder.
+// It is not a check, the content checks detect the change and the stored contents explain it.
+const inlineData = "inline"
+
+const (
+	// The number of diff lines that are reported for a single file.
+	maxDiffLines = 50
+	// The current contents of a file are not diffed if it grew beyond this size.
+	maxDiffSize = 1 << 20
+	// The line diff needs a table of this many cells at most.
+	maxDiffCells = 4 << 20
+)
+
+// Read and compress the contents of a file for inline storage in its record.
+func inlineContent(fqn string) (string, error) {
+	content, err := ioutil.ReadFile(fqn)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(content); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeInline(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return ioutil.ReadAll(zr)
+}
+
+// The changed lines between the recorded contents of a file and its current contents.
+// The old lines are prefixed with "-", the new lines with "+", both with their line number.
+func contentDiff(encoded string, fqn string) []string {
+	recorded, err := decodeInline(encoded)
+	if err != nil {
+		return []string{fmt.Sprintf("recorded content unreadable: %v", err)}
+	}
+	file, err := os.Open(fqn)
+	if err != nil {
+		return []string{fmt.Sprintf("current content unreadable: %v", err)}
+	}
+	defer file.Close()
+	current, err := ioutil.ReadAll(io.LimitReader(file, maxDiffSize+1))
+	if err != nil {
+		return []string{fmt.Sprintf("current content unreadable: %v", err)}
+	}
+	if len(current) > maxDiffSize {
+		return []string{"current content too large for a diff"}
+	}
+	if bytes.IndexByte(recorded, 0) >= 0 || bytes.IndexByte(current, 0) >= 0 {
+		return []string{"binary content differs"}
+	}
+	return lineDiff(splitLines(recorded), splitLines(current))
+}
+
+func splitLines(content []byte) []string {
+	if len(content) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
+
+// A line diff based on the longest common subsequence of the old and the new lines.
+func lineDiff(old, new []string) []string {
+	// The common head and tail are skipped, an edit usually touches a few lines only.
+	head := 0
+	for head < len(old) && head < len(new) && old[head] == new[head] {
+		head++
+	}
+	tail := 0
+	for tail < len(old)-head && tail < len(new)-head && old[len(old)-1-tail] == new[len(new)-1-tail] {
+		tail++
+	}
+	a, b := old[head:len(old)-tail], new[head:len(new)-tail]
+	if (len(a)+1)*(len(b)+1) > maxDiffCells {
+		return []string{fmt.Sprintf("too many changed lines for a diff, %d lines recorded, %d lines now", len(old), len(new))}
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var diff []string
+	changed := 0
+	emit := func(line string) {
+		changed++
+		if changed <= maxDiffLines {
+			diff = append(diff, line)
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case j == len(b) || (i < len(a) && lcs[i+1][j] >= lcs[i][j+1]):
+			emit(fmt.Sprintf("-%d: %s", head+i+1, a[i]))
+			i++
+		default:
+			emit(fmt.Sprintf("+%d: %s", head+j+1, b[j]))
+			j++
+		}
+	}
+	if changed > maxDiffLines {
+		diff = append(diff, fmt.Sprintf("... %d more changed lines", changed-maxDiffLines))
+	}
+	return diff
+}
+
+// Attach the diff of the contents to the first failed content check of a record with inline contents.
+func attachContentDiff(results []CheckResult, encoded string, fqn string) {
+	for i := range results {
+		if results[i].Status == StatusFailed && contentChecks[results[i].Check] && len(results[i].Actual) > 0 {
+			results[i].Diff = contentDiff(encoded, fqn)
+			return
+		}
+	}
+}
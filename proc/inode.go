@@ -0,0 +1,77 @@
+// +build aix linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package proc
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+)
+
+// Type inodeChecker verifies the device and the inode number of a file.
+// Editing a file in place keeps the inode, replacing it by renaming another file over it changes the inode, even when
+// the size and the timestamps were forged. Combined with a content check the verification tells the two apart, see
+// classifyContentChange. The files of a snapshot keep their inode numbers on another device, only the inode number is
+// compared for them.
+type inodeChecker struct {
+	// The translations of the recorded paths, see VerifyOptions.PathMaps.
+	pathMaps []PathMap
+}
+
+// Recorded inode, the numbers are strings like the recorded sizes so they keep their precision in json.
+// Older versions recorded the inode number only.
+type inodeData struct {
+	Dev string `json:"dev"`
+	Ino string `json:"ino"`
+}
+
+func init() {
+	fileChecks[inodeCheck] = inodeChecker{}
+}
+
+func (d inodeChecker) withVerifyOptions(opts *VerifyOptions) FileChecker {
+	return inodeChecker{pathMaps: opts.PathMaps}
+}
+
+func (d inodeChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return inodeNumbers(fi)
+}
+
+func (d inodeChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	actual, err := inodeNumbers(fi)
+	if err != nil {
+		return err
+	}
+	var expected inodeData
+	switch v := data.(type) {
+	case string:
+		// Without the device only the inode number is compared.
+		expected = inodeData{actual.Dev, v}
+	case map[string]interface{}:
+		dev, okDev := v["dev"].(string)
+		ino, okIno := v["ino"].(string)
+		if !okDev || !okIno {
+			return fmt.Errorf("data corrupt")
+		}
+		expected = inodeData{dev, ino}
+	default:
+		return fmt.Errorf("data corrupt")
+	}
+	if expected.Ino != actual.Ino {
+		return fmt.Errorf("file replaced (inode changed), %w", &mismatchError{expected.Ino, actual.Ino})
+	}
+	if expected.Dev != actual.Dev && !inSnapshot(fqn, d.pathMaps) {
+		return fmt.Errorf("file replaced (device changed), %w", &mismatchError{expected.Dev, actual.Dev})
+	}
+	return nil
+}
+
+// The device and the inode number of the file in decimal.
+func inodeNumbers(fi os.FileInfo) (*inodeData, error) {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil, fmt.Errorf("syscall")
+	}
+	return &inodeData{strconv.FormatUint(uint64(sys.Dev), 10), strconv.FormatUint(uint64(sys.Ino), 10)}, nil
+}
@@ -0,0 +1,103 @@
+// +build aix linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestInodeCheck(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "edited", "replaced")
+	opts := &AddOptions{Recursive: true, FileChecks: inodeCheck, DirChecks: "child"}
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An edit in place keeps the inode, a rename over the file replaces it.
+	writeTestFile(t, dir, "edited", "edited in place")
+	writeTestFiles(t, dir, "new")
+	err = os.Rename(filepath.Join(dir, "new"), filepath.Join(dir, "replaced"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed := failedResults(report, inodeCheck)
+	if _, found := failed[filepath.Join(dir, "edited")]; found || len(failed) != 1 {
+		t.Fatalf("failed inode checks %v, want only the replaced file", failed)
+	}
+	result := failed[filepath.Join(dir, "replaced")]
+	if !strings.HasPrefix(result.Detail, "file replaced (inode changed)") || result.Expected == result.Actual {
+		t.Errorf("result %+v, want the replaced file with both inode numbers", result)
+	}
+}
+
+func TestInodeCheckData(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	fqn := filepath.Join(dir, "x")
+	fi, err := os.Stat(fqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := inodeNumbers(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The inode number of an older version is compared without the device.
+	err = inodeChecker{}.ExecuteCheck(fqn, actual.Ino, fi)
+	if err != nil {
+		t.Errorf("check of an inode number without the device: %v", err)
+	}
+	err = inodeChecker{}.ExecuteCheck(fqn, map[string]interface{}{"dev": actual.Dev + "0", "ino": actual.Ino}, fi)
+	if err == nil || !strings.HasPrefix(err.Error(), "file replaced (device changed)") {
+		t.Errorf("check of another device: got error %v, want the device changed error", err)
+	}
+	err = inodeChecker{}.ExecuteCheck(fqn, map[string]interface{}{"ino": actual.Ino}, fi)
+	if err == nil || err.Error() != "data corrupt" {
+		t.Errorf("check of corrupt data: got error %v, want data corrupt", err)
+	}
+}
+
+func TestInodeCheckSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	fqn := filepath.Join(dir, "x")
+	fi, err := os.Stat(fqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := inodeNumbers(fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherDevice := map[string]interface{}{"dev": actual.Dev + "0", "ino": actual.Ino}
+
+	// The files of a snapshot keep their inode numbers on the device of the snapshot.
+	snapshot := verifyChecker(inodeChecker{}, &VerifyOptions{PathMaps: []PathMap{{From: "/srv", To: dir, Snapshot: true}}})
+	err = snapshot.ExecuteCheck(fqn, otherDevice, fi)
+	if err != nil {
+		t.Errorf("check of a snapshot file: %v", err)
+	}
+	err = snapshot.ExecuteCheck(fqn, map[string]interface{}{"dev": actual.Dev, "ino": actual.Ino + "0"}, fi)
+	if err == nil || !strings.HasPrefix(err.Error(), "file replaced (inode changed)") {
+		t.Errorf("check of a replaced snapshot file: got error %v, want the inode changed error", err)
+	}
+	// Another path mapping does not skip the device.
+	mapped := verifyChecker(inodeChecker{}, &VerifyOptions{PathMaps: []PathMap{{From: "/srv", To: dir}}})
+	err = mapped.ExecuteCheck(fqn, otherDevice, fi)
+	if err == nil || !strings.HasPrefix(err.Error(), "file replaced (device changed)") {
+		t.Errorf("check of a mapped file: got error %v, want the device changed error", err)
+	}
+}
@@ -0,0 +1,33 @@
+package proc
+
+import (
+	"errors"
+	"sync/atomic"
+)
+
+const (
+	err970 = "(proc/970) interrupted"
+)
+
+// Returned by the long running operations when they were interrupted.
+var ErrInterrupted = errors.New(err970)
+
+// Type Interrupt asks the running operation to stop at the next file, each run has its own. Set is safe to call
+// from another goroutine, e.g. a signal handler. The operation returns ErrInterrupted, the caller is responsible for
+// rolling back the transaction. A nil Interrupt is never set.
+type Interrupt struct {
+	// Accessed atomically.
+	set int32
+}
+
+// Ask the operation to stop.
+func (i *Interrupt) Set() {
+	atomic.StoreInt32(&i.set, 1)
+}
+
+func (i *Interrupt) check() error {
+	if i != nil && atomic.LoadInt32(&i.set) != 0 {
+		return ErrInterrupted
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package proc
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// Status of a path in the json rendering of the report.
+const (
+	PathOk      = "ok"
+	PathFailed  = "failed"
+	PathMissing = "missing"
+)
+
+// The outcome of the checks of a single path, the failed checks are listed.
+type PathResult struct {
+	Path   string        `json:"path"`
+	Status string        `json:"status"`
+	Failed []CheckResult `json:"failed,omitempty"`
+}
+
+// A section of the json rendering, the results grouped by path in the order they were verified.
+type jsonSection struct {
+	Fileset string       `json:"fileset"`
+	Prefix  string       `json:"prefix,omitempty"`
+	Entries int          `json:"entries"`
+	Paths   []PathResult `json:"paths"`
+}
+
+type jsonReport struct {
+	UpdatedAt string `json:"updatedAt,omitempty"`
+	Failures  int    `json:"failures"`
+	Pending   int    `json:"pending"`
+	// The checks that had nothing to compare, e.g. the contents of a file larger than the content limit.
+	NotCompared int            `json:"notCompared"`
+	Sections    []*jsonSection `json:"sections"`
+}
+
+// Render the report as a single json document, the status of each path with its failed checks.
+// A path is missing if the basic check reports it was not found, failed if any check failed and ok otherwise.
+func (r *VerifyReport) WriteJSON(w io.Writer) error {
+	doc := &jsonReport{UpdatedAt: r.UpdatedAt, Failures: r.Failures(), Pending: r.Pending(),
+		NotCompared: r.NotCompared(), Sections: make([]*jsonSection, 0)}
+	for _, section := range r.Sections {
+		js := &jsonSection{Fileset: section.Fileset, Prefix: section.Prefix, Entries: section.Entries, Paths: make([]PathResult, 0)}
+		index := make(map[string]int)
+		for _, result := range section.Results {
+			i, found := index[result.Path]
+			if !found {
+				i = len(js.Paths)
+				index[result.Path] = i
+				js.Paths = append(js.Paths, PathResult{Path: result.Path, Status: PathOk})
+			}
+			if result.Status != StatusFailed {
+				continue
+			}
+			path := &js.Paths[i]
+			path.Failed = append(path.Failed, result)
+			if len(result.Missing) > 0 {
+				path.Status = PathMissing
+			} else if path.Status == PathOk {
+				path.Status = PathFailed
+			}
+		}
+		doc.Sections = append(doc.Sections, js)
+	}
+	return json.NewEncoder(w).Encode(doc)
+}
@@ -0,0 +1,71 @@
+package proc
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A writer that always fails.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("disk full")
+}
+
+func TestVerifyReportWriteJSON(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "changed", "missing", "same")
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "child"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, dir, "changed", "a longer content")
+	err = os.Remove(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	err = report.WriteJSON(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc jsonReport
+	err = json.Unmarshal(out.Bytes(), &doc)
+	if err != nil {
+		t.Fatalf("invalid json %q: %v", out.String(), err)
+	}
+	if doc.Failures != report.Failures() || len(doc.Sections) != 1 {
+		t.Fatalf("report with %d failures and %d sections, want %d failures and 1 section", doc.Failures,
+			len(doc.Sections), report.Failures())
+	}
+	statuses := make(map[string]string)
+	for _, path := range doc.Sections[0].Paths {
+		statuses[path.Path] = path.Status
+	}
+	want := map[string]string{
+		filepath.Join(dir, "changed"): PathFailed,
+		filepath.Join(dir, "missing"): PathMissing,
+		filepath.Join(dir, "same"):    PathOk,
+	}
+	for path, status := range want {
+		if statuses[path] != status {
+			t.Errorf("status of %s is %q, want %q", path, statuses[path], status)
+		}
+	}
+
+	if err := report.WriteJSON(failingWriter{}); err == nil {
+		t.Error("write to a failing writer succeeded")
+	}
+}
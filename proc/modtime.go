@@ -9,25 +9,56 @@ import (
 const storageFormat = time.RFC3339Nano
 const displayFormat = time.RFC3339
 
-type modTimeChecker struct {}
+// Supported resolutions of the recorded modification times.
+// Some filesystems (FAT, older NFS) only store seconds, a nanosecond baseline recorded elsewhere never matches.
+var modTimePrecisions = map[string]time.Duration{
+	"second":      time.Second,
+	"millisecond": time.Millisecond,
+	"nanosecond":  time.Nanosecond,
+}
+
+// Recorded modification time with a precision other than nanoseconds.
+// Nanosecond modification times are recorded as a plain string.
+type modTimeData struct {
+	Time      string `json:"time"`
+	Precision string `json:"precision"`
+}
+
+type modTimeChecker struct {
+	// Resolution of the recorded modification times, nanosecond if empty. See AddOptions.ModTimePrecision.
+	precision string
+}
+
+func (d modTimeChecker) withOptions(opts *AddOptions) FileChecker {
+	return modTimeChecker{opts.ModTimePrecision}
+}
 
-func (d modTimeChecker) prepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
-	// Get the file modification time
-	mtime := fi.ModTime()
+func (d modTimeChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	precision := d.precision
+	if len(precision) == 0 {
+		precision = "nanosecond"
+	}
+	// Get the file modification time, truncated to the precision.
+	mtime := fi.ModTime().Truncate(modTimePrecisions[precision])
 	// Convert it to a string to preserve nano sec precision.
-	return mtime.Format(storageFormat), nil
+	if precision == "nanosecond" {
+		return mtime.Format(storageFormat), nil
+	}
+	// Record the precision so the verification can truncate the actual time in the same way.
+	return &modTimeData{mtime.Format(storageFormat), precision}, nil
 }
 
-func (d modTimeChecker) executeCheck(fqn string, data interface{}, fi os.FileInfo) error {
-	// Get the actual modification time
-	actualModTime := fi.ModTime()
-	actualModTimeRepr := actualModTime.Format(storageFormat)
-	// Get the recorded modification time from a string.
-	recordedModTimeRepr, ok := data.(string)
-	if !ok {
+func (d modTimeChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	// Get the recorded modification time from a string, or from a time/precision pair.
+	recordedModTimeRepr, precision := modTimeRepr(data)
+	resolution, ok := modTimePrecisions[precision]
+	if !ok || len(recordedModTimeRepr) == 0 {
 		// The data is not a string...
 		return fmt.Errorf("modtime not recorded")
 	}
+	// Get the actual modification time with the same precision as the recorded one.
+	actualModTime := fi.ModTime().Truncate(resolution)
+	actualModTimeRepr := actualModTime.Format(storageFormat)
 	// We only convert the string to a timestamp to verify that it is correct (and no tampering)
 	// We will continue using the string representation though.
 	recordedModTime, err := time.Parse(storageFormat, recordedModTimeRepr)
@@ -42,4 +73,18 @@ func (d modTimeChecker) executeCheck(fqn string, data interface{}, fi os.FileInf
 		return fmt.Errorf("expected '%v' actual '%v'", recordedModTime.Format(displayFormat), actualModTime.Format(displayFormat))
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// Extract the time representation and the precision from the recorded data.
+// Returns empty strings if the data is corrupt.
+func modTimeRepr(data interface{}) (string, string) {
+	switch recorded := data.(type) {
+	case string:
+		return recorded, "nanosecond"
+	case map[string]interface{}:
+		repr, _ := recorded["time"].(string)
+		precision, _ := recorded["precision"].(string)
+		return repr, precision
+	}
+	return "", ""
+}
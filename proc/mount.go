@@ -0,0 +1,59 @@
+// +build aix linux darwin dragonfly freebsd openbsd netbsd solaris
+
+package proc
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"syscall"
+)
+
+// Type mountChecker verifies the device of a directory, recorded as "major:minor".
+// A directory that becomes a mount point, or a mount that disappears and exposes the underlying directory, changes
+// the device while the directory still exists. The whole subtree is swapped in that case.
+// The directories of a snapshot are on the device of the snapshot, the check passes for them.
+type mountChecker struct {
+	// The translations of the recorded paths, see VerifyOptions.PathMaps.
+	pathMaps []PathMap
+}
+
+func init() {
+	dirChecks["mount"] = mountChecker{}
+}
+
+func (d mountChecker) withVerifyOptions(opts *VerifyOptions) FileChecker {
+	return mountChecker{pathMaps: opts.PathMaps}
+}
+
+func (d mountChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return deviceId(fi)
+}
+
+func (d mountChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	expectedDevice, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	if inSnapshot(fqn, d.pathMaps) {
+		return nil
+	}
+	actualDevice, err := deviceId(fi)
+	if err != nil {
+		return err
+	}
+	if expectedDevice != actualDevice {
+		return fmt.Errorf("expected device %s actual %s, mount point changed", expectedDevice, actualDevice)
+	}
+	return nil
+}
+
+// Describe the device containing the file as "major:minor".
+func deviceId(fi os.FileInfo) (string, error) {
+	sys, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", fmt.Errorf("syscall")
+	}
+	dev := uint64(sys.Dev)
+	return fmt.Sprintf("%d:%d", unix.Major(dev), unix.Minor(dev)), nil
+}
@@ -8,10 +8,10 @@ import (
 // Can be used as an example to start the development on a new checker.
 type noChecker struct {}
 
-func (d noChecker) prepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+func (d noChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
 	return nil, nil
 }
 
-func (d noChecker) executeCheck(fqn string, data interface{}, fi os.FileInfo) error {
+func (d noChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
 	return nil
 }
\ No newline at end of file
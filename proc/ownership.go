@@ -15,6 +15,9 @@ import (
 type ownership struct {
 	User string
 	Group string
+	// The numeric ids, used when the ids are remapped. Absent in records of older versions.
+	Uid *int `json:",omitempty"`
+	Gid *int `json:",omitempty"`
 }
 
 // userMap and groupMap caches UID and GID lookups for performance reasons.
@@ -45,7 +48,7 @@ func statUnix(fi os.FileInfo) (*ownership, error) {
 		groupMap.Store(gid, gname)
 	}
 
-	return &ownership{uname, gname}, nil
+	return &ownership{uname, gname, &uid, &gid}, nil
 }
 
 func statAtime(st *syscall.Stat_t) time.Time {
@@ -56,17 +59,46 @@ func statCtime(st *syscall.Stat_t) time.Time {
 	return time.Unix(st.Ctim.Unix())
 }
 
-type ownershipChecker struct {}
+type ownershipChecker struct {
+	// Record the ownership of the files of the current user as selfOwner, see AddOptions.SelfOwner.
+	self bool
+	// The remapping tables of the user and the group ids, see VerifyOptions.UidMaps.
+	uidMaps, gidMaps []IdMap
+}
+
+func init() {
+	fileChecks["ownership"] = ownershipChecker{}
+	dirChecks["ownership"] = ownershipChecker{}
+}
+
+func (d ownershipChecker) withOptions(opts *AddOptions) FileChecker {
+	return ownershipChecker{self: opts.SelfOwner}
+}
+
+func (d ownershipChecker) withVerifyOptions(opts *VerifyOptions) FileChecker {
+	return ownershipChecker{uidMaps: opts.UidMaps, gidMaps: opts.GidMaps}
+}
 
-func (d ownershipChecker) prepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+func (d ownershipChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
 	owner, err := statUnix(fi)
 	if err != nil {
 		return nil, fmt.Errorf("retreive ownership:%v", err)
 	}
+	if d.self {
+		// The numeric ids of the sentinels are meaningless on other systems.
+		if *owner.Uid == os.Getuid() {
+			owner.User = selfOwner
+			owner.Uid = nil
+		}
+		if *owner.Gid == os.Getgid() {
+			owner.Group = selfOwner
+			owner.Gid = nil
+		}
+	}
 	return owner, nil
 }
 
-func (d ownershipChecker) executeCheck(fqn string, data interface{}, fi os.FileInfo) error {
+func (d ownershipChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
 	expectedData, ok := data.(map[string]interface{})
 	if !ok {
 		return fmt.Errorf("data corrupt")
@@ -93,6 +125,27 @@ func (d ownershipChecker) executeCheck(fqn string, data interface{}, fi os.FileI
 	if err != nil {
 		return fmt.Errorf("retreive ownership:%v", err)
 	}
+	// The sentinels match the verifying user and its primary group.
+	if expectedOwner.User == selfOwner && *actualOwner.Uid == os.Getuid() {
+		actualOwner.User = selfOwner
+	}
+	if expectedOwner.Group == selfOwner && *actualOwner.Gid == os.Getgid() {
+		actualOwner.Group = selfOwner
+	}
+
+	// Compare the remapped numeric ids if there are remapping tables, the names differ between the systems.
+	uid, hasUid := expectedData["Uid"].(float64)
+	gid, hasGid := expectedData["Gid"].(float64)
+	if hasUid && hasGid && (len(d.uidMaps) > 0 || len(d.gidMaps) > 0) {
+		expectedUid := remapId(d.uidMaps, int(uid))
+		expectedGid := remapId(d.gidMaps, int(gid))
+		if (expectedUid != *actualOwner.Uid) || (expectedGid != *actualOwner.Gid) {
+			return fmt.Errorf("expected %d:%d actual %d:%d",
+				expectedUid, expectedGid,
+				*actualOwner.Uid, *actualOwner.Gid)
+		}
+		return nil
+	}
 
 	if (expectedOwner.User != actualOwner.User) || (expectedOwner.Group != actualOwner.Group) {
 		return fmt.Errorf("expected %s:%s actual %s:%s",
@@ -0,0 +1,150 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Prefix used for paths that are recorded relative to the home directory of the user.
+const homePrefix = "~"
+
+// Check if a recorded path is relative to the home directory, it starts with "~".
+func isHomeRelative(p string) bool {
+	return p == homePrefix || strings.HasPrefix(p, homePrefix+string(filepath.Separator))
+}
+
+// Replace the home directory prefix of an absolute path by "~".
+// Returns an error if the path is not located in the home directory of the current user.
+func compressHome(fqn string) (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	if fqn == home {
+		return homePrefix, nil
+	}
+	if strings.HasPrefix(fqn, home+string(filepath.Separator)) {
+		return homePrefix + fqn[len(home):], nil
+	}
+	return "", fmt.Errorf(err160, fqn)
+}
+
+// Replace the leading "~" of a recorded path by the home directory of the current user.
+// Absolute paths are returned unchanged.
+func expandHome(p string) (string, error) {
+	if !isHomeRelative(p) {
+		return p, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return home + p[len(homePrefix):], nil
+}
+
+// Query the fileset for the records matching the absolute path prefix.
+// The prefix is converted to the path style recorded in the fileset meta, the home relative form for a fileset with
+// home relative paths. A path outside the home directory matches none of its records.
+func queryRecords(fileset string, fqn string, tripDb *db.TriplineDb) ([]db.TriplineEntry, error) {
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return nil, err
+	}
+	if meta.HomeRelative && len(fqn) > 0 {
+		rel, err := compressHome(fqn)
+		if err != nil {
+			return make([]db.TriplineEntry, 0), nil
+		}
+		fqn = rel
+	}
+	return tripDb.QueryTriplineRecords(fileset, fqn)
+}
+
+// Translation of a recorded path prefix to another location, e.g. to verify on another OS.
+type PathMap struct {
+	From string
+	To   string
+	// The target is a snapshot of the source, see Snapshot.PathMap. The files keep their inode numbers but they are
+	// read from another device.
+	Snapshot bool
+}
+
+// Parse a path mapping of the form "FROM=TO", e.g. "/mnt/share=S:\\".
+func ParsePathMap(mapping string) (PathMap, error) {
+	parts := strings.SplitN(mapping, "=", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return PathMap{}, fmt.Errorf(err210, mapping)
+	}
+	return PathMap{From: parts[0], To: parts[1]}, nil
+}
+
+// Whether the translated path is read from a snapshot, its device differs from the recorded one.
+func inSnapshot(p string, maps []PathMap) bool {
+	for _, m := range maps {
+		to := strings.TrimRight(m.To, `/\`)
+		if m.Snapshot && (p == to || strings.HasPrefix(p, to+"/") || strings.HasPrefix(p, to+`\`)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Translate a recorded path using the first mapping that matches it.
+// The separators of the remainder of the path are converted to the style of the target, a target containing
+// backslashes and no slashes is considered to be a Windows path.
+func translatePath(p string, maps []PathMap) string {
+	for _, m := range maps {
+		from := strings.TrimRight(m.From, `/\`)
+		if p != from && !strings.HasPrefix(p, from+"/") && !strings.HasPrefix(p, from+`\`) {
+			continue
+		}
+		rest := p[len(from):]
+		if strings.Contains(m.To, `\`) && !strings.Contains(m.To, "/") {
+			rest = strings.ReplaceAll(rest, "/", `\`)
+		} else {
+			rest = strings.ReplaceAll(rest, `\`, "/")
+		}
+		return strings.TrimRight(m.To, `/\`) + rest
+	}
+	return p
+}
+
+// Check that the path style and the resolve option match the fileset. A fileset cannot mix home relative and
+// absolute paths, and the paths of a fileset are either all resolved or none are. Both are recorded when the
+// fileset is created.
+func checkFilesetStyle(fileset string, homeRelative bool, resolve bool, tripDb *db.TriplineDb) error {
+	exists, err := tripDb.HasFileset(fileset)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return tripDb.SaveFilesetMeta(fileset, &db.FilesetMeta{Resolve: resolve, HomeRelative: homeRelative})
+	}
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return err
+	}
+	if meta.HomeRelative != homeRelative {
+		return fmt.Errorf(err170, fileset)
+	}
+	if meta.Resolve != resolve {
+		return fmt.Errorf(err240, fileset)
+	}
+	return nil
+}
+
+// Make the path absolute, resolving the symbolic links if requested.
+// A path that cannot be resolved, e.g. because it does not exist, is only made absolute.
+func absPath(fn string, resolve bool) (string, error) {
+	fqn, err := filepath.Abs(fn)
+	if err != nil || !resolve {
+		return fqn, err
+	}
+	if resolved, err := filepath.EvalSymlinks(fqn); err == nil {
+		return resolved, nil
+	}
+	return fqn, nil
+}
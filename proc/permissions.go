@@ -3,27 +3,110 @@ package proc
 import (
 	"fmt"
 	"os"
+	"strings"
 )
 
+// All the unix permission bits, including setuid, setgid and sticky.
+const allPermissionBits = 07777
+
+// Recorded permissions, the mode string and the unix style permission bits the mask is applied to.
+// Older versions recorded the mode string only.
+type permissionsData struct {
+	Mode string `json:"mode"`
+	Bits uint32 `json:"bits"`
+}
+
 // Type permissionsChecker verifies if the file permissions have changed since recording them in the database.
-type permissionsChecker struct {}
+type permissionsChecker struct {
+	// The permission bits that are not compared, see VerifyOptions.IgnorePermissions.
+	ignore uint32
+}
+
+func (d permissionsChecker) withVerifyOptions(opts *VerifyOptions) FileChecker {
+	return permissionsChecker{opts.IgnorePermissions & allPermissionBits}
+}
 
-func (d permissionsChecker) prepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
-	// Permissions will be saved as a string "-rw-r--r--"
-	return fmt.Sprintf("%s", fi.Mode()), nil
+func (d permissionsChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	// Permissions will be saved as a string "-rw-r--r--" together with the bits.
+	return &permissionsData{fmt.Sprintf("%s", fi.Mode()), permissionBits(fi.Mode())}, nil
 }
 
-func (d permissionsChecker) executeCheck(fqn string, data interface{}, fi os.FileInfo) error {
+func (d permissionsChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
 	// Retrieve the saved permissions string, verify that it it still a string.
-	expectedMode, ok := data.(string)
-	if !ok {
-		return fmt.Errorf("corrupt data, expected string")
+	var expectedMode string
+	var expectedBits uint32
+	switch v := data.(type) {
+	case string:
+		expectedMode = v
+		bits, ok := parsePermissionBits(v)
+		if !ok {
+			return fmt.Errorf("corrupt data, expected string")
+		}
+		expectedBits = bits
+	case map[string]interface{}:
+		mode, ok := v["mode"].(string)
+		bits, okBits := v["bits"].(float64)
+		if !ok || !okBits {
+			return fmt.Errorf("data corrupt")
+		}
+		expectedMode = mode
+		expectedBits = uint32(bits)
+	default:
+		return fmt.Errorf("data corrupt")
 	}
 
 	// Get the current permissions and verify them against the stored permissions.
 	actualMode := fmt.Sprintf("%s", fi.Mode())
-	if expectedMode != actualMode {
-		return fmt.Errorf("expected %s actual %s", expectedMode, actualMode)
+	if d.ignore == 0 {
+		if expectedMode != actualMode {
+			return fmt.Errorf("expected %s actual %s", expectedMode, actualMode)
+		}
+		return nil
+	}
+	actualBits := permissionBits(fi.Mode())
+	mask := allPermissionBits &^ d.ignore
+	if expectedBits&mask != actualBits&mask {
+		return fmt.Errorf("expected %04o actual %04o mask %04o", expectedBits, actualBits, mask)
 	}
 	return nil
 }
+
+// Convert the file mode to the unix style permission bits.
+func permissionBits(mode os.FileMode) uint32 {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+// Parse the unix style permission bits of a recorded mode string, e.g. "ug-rwxr-xr-x".
+// The type letters precede the nine permission characters.
+func parsePermissionBits(mode string) (uint32, bool) {
+	if len(mode) < 9 {
+		return 0, false
+	}
+	prefix, perm := mode[:len(mode)-9], mode[len(mode)-9:]
+	var bits uint32
+	for i, c := range perm {
+		if c != '-' {
+			bits |= 1 << uint(8-i)
+		}
+	}
+	if strings.ContainsRune(prefix, 'u') {
+		bits |= 04000
+	}
+	if strings.ContainsRune(prefix, 'g') {
+		bits |= 02000
+	}
+	if strings.ContainsRune(prefix, 't') {
+		bits |= 01000
+	}
+	return bits, true
+}
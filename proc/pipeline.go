@@ -0,0 +1,174 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"hash"
+	"io"
+	"sync"
+)
+
+// Number of chunks a reader reads ahead of the hasher of a file.
+const pipelineReadAhead = 4
+
+// A file in the hashing pipeline. The reader streams the contents in chunks, the hasher consumes them in order.
+type hashJob struct {
+	fqn     string
+	names   []string
+	hashers []contentHasher
+	chunks  chan []byte
+	// Set by the reader before closing the chunks.
+	readErr error
+	// Set by the hasher before closing done.
+	digests map[string]string
+	err     error
+	done    chan struct{}
+}
+
+// Type hashPipeline decouples reading the file contents from hashing them. The IO threads read the files and the
+// CPU threads hash them, so the disk latency and the hash computation overlap. The files are hashed in the order
+// of the records, the verification consumes the results in the same order.
+type hashPipeline struct {
+	jobs     []*hashJob
+	stop     chan struct{}
+	pool     sync.Pool
+	readOpts *ReadOptions
+}
+
+// Start hashing the content checks of the file records, the directories and the records without content checks are
+// skipped. The file paths are translated in the same way as the verification does.
+func newHashPipeline(entries []db.TriplineEntry, ioThreads int, cpuThreads int, pathMaps []PathMap, ro *ReadOptions) (*hashPipeline, error) {
+	p := &hashPipeline{jobs: make([]*hashJob, len(entries)), stop: make(chan struct{}), readOpts: ro}
+	p.pool.New = func() interface{} { return make([]byte, ro.bufferSize()) }
+	queued := make([]*hashJob, 0, len(entries))
+	for i, entry := range entries {
+		if entry.Record.IsDir {
+			continue
+		}
+		job := &hashJob{chunks: make(chan []byte, pipelineReadAhead), done: make(chan struct{})}
+		for _, checkName := range withoutPending(&entry.Record) {
+			if hasher, ok := fileChecks[checkName].(contentHasher); ok {
+				job.names = append(job.names, checkName)
+				job.hashers = append(job.hashers, hasher)
+			}
+		}
+		if len(job.hashers) == 0 {
+			continue
+		}
+		path, err := expandHome(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		job.fqn = translatePath(path, pathMaps)
+		p.jobs[i] = job
+		queued = append(queued, job)
+	}
+
+	// Both queues receive the jobs in the same order, a reader can only block on a job a hasher will take next.
+	readQueue := make(chan *hashJob)
+	hashQueue := make(chan *hashJob)
+	for i := 0; i < ioThreads; i++ {
+		go p.read(readQueue)
+	}
+	for i := 0; i < cpuThreads; i++ {
+		go p.hash(hashQueue)
+	}
+	go func() {
+		defer close(readQueue)
+		defer close(hashQueue)
+		for _, job := range queued {
+			select {
+			case readQueue <- job:
+			case <-p.stop:
+				return
+			}
+			select {
+			case hashQueue <- job:
+			case <-p.stop:
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+// The digests of the record with the index, as hashChecks would calculate them. Blocks until the file is hashed.
+func (p *hashPipeline) digests(i int) (map[string]string, error) {
+	job := p.jobs[i]
+	if job == nil {
+		return nil, nil
+	}
+	<-job.done
+	return job.digests, job.err
+}
+
+// Stop the pipeline, the files that are not read yet are abandoned.
+func (p *hashPipeline) close() {
+	close(p.stop)
+}
+
+// Read the files of the jobs in chunks.
+func (p *hashPipeline) read(queue <-chan *hashJob) {
+	for job := range queue {
+		p.readFile(job)
+	}
+}
+
+func (p *hashPipeline) readFile(job *hashJob) {
+	defer close(job.chunks)
+	f, err := p.readOpts.open(job.fqn)
+	if err != nil {
+		job.readErr = fmt.Errorf("open file")
+		return
+	}
+	defer f.Close()
+	for {
+		chunk := p.pool.Get().([]byte)
+		n, err := f.Read(chunk)
+		if n > 0 {
+			select {
+			case job.chunks <- chunk[:n]:
+			case <-p.stop:
+				return
+			}
+		}
+		if err != nil {
+			if err != io.EOF {
+				job.readErr = fmt.Errorf("calculate hash")
+			}
+			return
+		}
+	}
+}
+
+// Hash the chunks of the jobs.
+func (p *hashPipeline) hash(queue <-chan *hashJob) {
+	for job := range queue {
+		hashes := make([]hash.Hash, len(job.hashers))
+		for i, hasher := range job.hashers {
+			hashes[i] = hasher.newHash()
+		}
+		for chunk := range job.chunks {
+			for _, h := range hashes {
+				h.Write(chunk)
+			}
+			p.pool.Put(chunk[:cap(chunk)])
+		}
+		if job.readErr != nil {
+			job.err = job.readErr
+		} else {
+			job.digests = make(map[string]string)
+			for i, h := range hashes {
+				job.digests[job.names[i]] = fmt.Sprintf("%x", h.Sum(nil))
+			}
+		}
+		close(job.done)
+	}
+}
+
+func atLeastOne(threads int) int {
+	if threads < 1 {
+		return 1
+	}
+	return threads
+}
@@ -0,0 +1,125 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"sync"
+	"testing"
+)
+
+// Records of files with random contents and the sha256 and blake2b checks, the first record is a directory.
+func writeHashEntries(tb testing.TB, dir string, files int, size int) []db.TriplineEntry {
+	tb.Helper()
+	entries := []db.TriplineEntry{{Path: dir, Record: db.TriplineRecord{IsDir: true}}}
+	for i := 0; i < files; i++ {
+		fqn := writeRandomFile(tb, dir, fmt.Sprintf("f%03d", i), size)
+		entries = append(entries, db.TriplineEntry{Path: fqn, Record: db.TriplineRecord{Checks: []string{"size", "sha256", "blake2b"}}})
+	}
+	return entries
+}
+
+func TestHashPipelineDigests(t *testing.T) {
+	entries := writeHashEntries(t, t.TempDir(), 20, 100*1024+7)
+	for _, threads := range []struct{ io, cpu int }{{1, 1}, {1, 4}, {4, 1}, {3, 5}} {
+		pipeline, err := newHashPipeline(entries, threads.io, threads.cpu, nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, entry := range entries {
+			got, err := pipeline.digests(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := hashChecks(entry.Path, entry.Record.Checks, fileChecks, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(want) || got["sha256"] != want["sha256"] || got["blake2b"] != want["blake2b"] {
+				t.Errorf("io %d cpu %d: %s digests %v, want %v", threads.io, threads.cpu, entry.Path, got, want)
+			}
+		}
+		pipeline.close()
+	}
+}
+
+// Compare the content hashing of the verification: each file in turn, a pool of workers that read and hash a file
+// each, and the pipeline of IO and CPU threads. The files are read from the page cache after the first iteration,
+// the pipeline only pays off when the reads wait for the storage. Point TMPDIR to the storage to measure, e.g. a
+// network filesystem, and drop the page cache between the runs, e.g.
+//
+//	TMPDIR=/mnt/nfs/tmp go test -run - -bench HashContents -benchtime 3x ./proc
+func BenchmarkHashContents(b *testing.B) {
+	const files = 64
+	const size = 1024 * 1024
+	entries := writeHashEntries(b, b.TempDir(), files, size)[1:]
+
+	b.Run("InTurn", func(b *testing.B) {
+		b.SetBytes(files * size)
+		for i := 0; i < b.N; i++ {
+			for _, entry := range entries {
+				_, err := hashChecks(entry.Path, entry.Record.Checks, fileChecks, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+	for _, workers := range []int{4, 8} {
+		b.Run(fmt.Sprintf("Pool%d", workers), func(b *testing.B) {
+			b.SetBytes(files * size)
+			for i := 0; i < b.N; i++ {
+				benchmarkHashPool(b, entries, workers)
+			}
+		})
+	}
+	for _, threads := range []struct{ io, cpu int }{{4, 4}, {8, 4}, {8, 8}} {
+		b.Run(fmt.Sprintf("Pipeline%dx%d", threads.io, threads.cpu), func(b *testing.B) {
+			b.SetBytes(files * size)
+			for i := 0; i < b.N; i++ {
+				pipeline, err := newHashPipeline(entries, threads.io, threads.cpu, nil, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				for j := range entries {
+					_, err := pipeline.digests(j)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+				pipeline.close()
+			}
+		})
+	}
+}
+
+// The naive pool, each worker reads and hashes a file at a time.
+func benchmarkHashPool(b *testing.B, entries []db.TriplineEntry, workers int) {
+	queue := make(chan db.TriplineEntry)
+	errs := make(chan error, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range queue {
+				_, err := hashChecks(entry.Path, entry.Record.Checks, fileChecks, nil)
+				if err != nil {
+					select {
+					case errs <- err:
+					default:
+					}
+				}
+			}
+		}()
+	}
+	for _, entry := range entries {
+		queue <- entry
+	}
+	close(queue)
+	wg.Wait()
+	select {
+	case err := <-errs:
+		b.Fatal(err)
+	default:
+	}
+}
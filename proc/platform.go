@@ -0,0 +1,33 @@
+package proc
+
+import "fmt"
+
+// The checks that are only built on some platforms, with the platforms that provide them. A recorded check that is
+// not available in this binary is skipped by the verification, e.g. the ownership of a baseline that was created on
+// Linux and is verified on Windows. A check that is not in the list is unknown, the record is corrupt.
+var platformChecks = map[string]string{
+	"ownership": "unix",
+	"devnode":   "unix",
+	"inode":     "unix",
+	"mount":     "unix",
+	"xmeta":     "linux",
+	"xattr":     "linux",
+	"version":   "linux",
+}
+
+// Reported for a recorded check that this binary does not provide, it is skipped and does not count as a failure.
+type unavailableError struct {
+	platforms string
+}
+
+func (e *unavailableError) Error() string {
+	return fmt.Sprintf("not available on this platform, requires %s", e.platforms)
+}
+
+// The error of a recorded check without a checker, the check is either not available on this platform or unknown.
+func missingChecker(checkName string) error {
+	if platforms, known := platformChecks[checkName]; known {
+		return &unavailableError{platforms}
+	}
+	return fmt.Errorf("unknown check")
+}
@@ -0,0 +1,193 @@
+package proc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	msg470 = "%d files, %d dirs, %d bytes, %d bytes read by the content checks"
+)
+
+// Number of directories that are read at the same time by the prewalk.
+const prewalkWorkers = 8
+
+// The scope of an add, counted by the prewalk.
+type AddEstimate struct {
+	Files int
+	Dirs  int
+	// Total size of the files.
+	Bytes int64
+	// Size of the files that are read by the content checks.
+	ContentBytes int64
+}
+
+// Type prewalker stats the files an add would record without preparing the checks. The directories are read
+// concurrently, the same filters apply as in the add.
+type prewalker struct {
+	opts    *AddOptions
+	adder   *adder
+	content bool
+	stat    func(string) (os.FileInfo, error)
+	sem     chan struct{}
+	wg      sync.WaitGroup
+	// Guards the estimate, the visited directories and the error.
+	mu       sync.Mutex
+	estimate AddEstimate
+	visited  map[string]bool
+	err      error
+}
+
+// Count the files, directories and bytes the add of the file names would record.
+func EstimateAdd(fileNames []string, opts *AddOptions) (*AddEstimate, error) {
+	fc, err := parseFileChecks(opts.FileChecks)
+	if err != nil {
+		return nil, fmt.Errorf(err010, err)
+	}
+	dc, err := parseDirChecks(opts.DirChecks)
+	if err != nil {
+		return nil, fmt.Errorf(err020, err)
+	}
+	err = checkGlobs(opts.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	w := &prewalker{
+		opts:    opts,
+		adder:   &adder{opts: opts},
+		content: len(onlyContentChecks(fc)) > 0 && !opts.DeferContent,
+		stat:    addStat(opts.FollowSymlinks, fc, dc),
+		sem:     make(chan struct{}, prewalkWorkers),
+		visited: make(map[string]bool),
+	}
+	for _, fn := range fileNames {
+		fqn, err := absPath(fn, opts.Resolve)
+		if err != nil {
+			return nil, fmt.Errorf(err040, fn, err)
+		}
+		fi, err := w.stat(fqn)
+		if err != nil {
+			return nil, fmt.Errorf(err040, fn, err)
+		}
+		w.count(fqn, fi, nil)
+	}
+	w.wg.Wait()
+	if w.err != nil {
+		return nil, w.err
+	}
+	return &w.estimate, nil
+}
+
+// Print the scope of the add of the file names, nothing is added.
+func LogAddEstimate(fileNames []string, opts *AddOptions) error {
+	estimate, err := EstimateAdd(fileNames, opts)
+	if err != nil {
+		return err
+	}
+	opts.logger().Printf(msg470, estimate.Files, estimate.Dirs, estimate.Bytes, estimate.ContentBytes)
+	return nil
+}
+
+// Count the file or directory and start walking a directory.
+func (w *prewalker) count(fqn string, fi os.FileInfo, rules []ignoreRule) {
+	if matchesAnyGlob(fqn, w.opts.Exclude) || matchingIgnoreRule(fqn, fi.IsDir(), rules) != nil {
+		return
+	}
+	if w.opts.RegularOnly && !fi.Mode().IsRegular() && !fi.IsDir() {
+		return
+	}
+	if w.adder.excluded(fi) {
+		return
+	}
+	w.mu.Lock()
+	if fi.IsDir() {
+		w.estimate.Dirs++
+		if w.opts.Recursive && w.opts.FollowSymlinks && !w.visit(fqn, fi) {
+			w.mu.Unlock()
+			return
+		}
+	} else {
+		w.estimate.Files++
+		w.estimate.Bytes += fi.Size()
+		if w.content && (w.opts.MaxFileSize <= 0 || fi.Size() <= w.opts.MaxFileSize) {
+			w.estimate.ContentBytes += fi.Size()
+		}
+	}
+	w.mu.Unlock()
+
+	if fi.IsDir() && w.opts.Recursive {
+		w.wg.Add(1)
+		go w.walk(fqn, rules)
+	}
+}
+
+// Read a directory and count its children, the subdirectories are walked in their own goroutines.
+func (w *prewalker) walk(dir string, rules []ignoreRule) {
+	defer w.wg.Done()
+	if err := w.opts.interrupted(); err != nil {
+		w.fail(err)
+		return
+	}
+	w.sem <- struct{}{}
+	children, err := ioutil.ReadDir(dir)
+	type child struct {
+		fqn string
+		fi  os.FileInfo
+	}
+	stats := make([]child, 0, len(children))
+	for _, c := range children {
+		if err != nil {
+			break
+		}
+		cfqn := filepath.Join(dir, c.Name())
+		var fi os.FileInfo
+		fi, err = w.stat(cfqn)
+		if err != nil {
+			err = fmt.Errorf(err040, cfqn, err)
+			break
+		}
+		stats = append(stats, child{cfqn, fi})
+	}
+	<-w.sem
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	childRules, err := readIgnore(dir, rules)
+	if err != nil {
+		w.fail(err)
+		return
+	}
+	for _, c := range stats {
+		w.count(c.fqn, c.fi, childRules)
+	}
+}
+
+// Mark the directory as visited, false if it was visited already, e.g. through a symbolic link.
+// The caller holds the lock.
+func (w *prewalker) visit(fqn string, fi os.FileInfo) bool {
+	id, err := fileIdentity(fqn, fi)
+	if err != nil {
+		if w.err == nil {
+			w.err = fmt.Errorf(err040, fqn, err)
+		}
+		return false
+	}
+	if w.visited[id] {
+		return false
+	}
+	w.visited[id] = true
+	return true
+}
+
+// Remember the first error.
+func (w *prewalker) fail(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.err == nil {
+		w.err = err
+	}
+}
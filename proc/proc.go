@@ -1,6 +1,7 @@
 package proc
 
 import (
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -9,34 +10,88 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-var fileChecks = map[string]fileChecker{
+var fileChecks = map[string]FileChecker{
 	"nocheck":     noChecker{},
 	"size":        fileSizeChecker{},
-	"ownership":   ownershipChecker{},
-	"content":     noChecker{},
+	"content":     contentChecker{},
 	"modtime":     modTimeChecker{},
 	"permissions": permissionsChecker{},
-	"sha256":      sha256Checker{},
+	"sha256":      hashChecker{"sha256"},
+	"sha512":      hashChecker{"sha512"},
+	"blake2b":     hashChecker{"blake2b"},
+	"blake2s":     hashChecker{"blake2s"},
+	"casename":    caseNameChecker{},
+	"specialbits": specialBitsChecker{},
+	symlinkCheck:  symlinkChecker{},
 }
 
-var dirChecks = map[string]fileChecker{
+// The checks that read the file contents. These are the expensive ones on large files.
+var contentChecks = map[string]bool{
+	"content": true,
+	"sha256":  true,
+	"sha512":  true,
+	"blake2b": true,
+	"blake2s": true,
+}
+
+var dirChecks = map[string]FileChecker{
 	"nocheck":     noChecker{},
-	"ownership":   ownershipChecker{},
 	"child":       childChecker{},
 	"modtime":     modTimeChecker{},
 	"permissions": permissionsChecker{},
+	"specialbits": specialBitsChecker{},
+	symlinkCheck:  symlinkChecker{},
+}
+
+// A check on a file or directory.
+// PrepareCheck collects the data that is stored in the fileset when the file is added, it has to survive a json
+// round trip. ExecuteCheck compares the stored data with the actual file, the data is the result of unmarshalling the
+// json, e.g. a struct is passed as a map. An error means the check failed.
+type FileChecker interface {
+	PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error)
+	ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error
 }
 
-type fileChecker interface {
-	prepareCheck(fqn string, fi os.FileInfo) (interface{}, error)
-	executeCheck(fqn string, data interface{}, fi os.FileInfo) error
+// A check whose recorded data depends on the add options, e.g. the precision of the modification times.
+// The adder prepares the records with the checker that withOptions returns, the registered checker records the
+// defaults.
+type optionsChecker interface {
+	withOptions(opts *AddOptions) FileChecker
+}
+
+// The checker that prepares the records of an add with the options.
+func addChecker(checker FileChecker, opts *AddOptions) FileChecker {
+	if configurable, ok := checker.(optionsChecker); ok {
+		return configurable.withOptions(opts)
+	}
+	return checker
+}
+
+// A check whose verification depends on the verify options, e.g. the remapping of the owner ids.
+// The verifier executes the checks with the checker that withVerifyOptions returns.
+type verifyOptionsChecker interface {
+	withVerifyOptions(opts *VerifyOptions) FileChecker
+}
+
+// The checker that executes the checks of a verification with the options.
+func verifyChecker(checker FileChecker, opts *VerifyOptions) FileChecker {
+	if configurable, ok := checker.(verifyOptionsChecker); ok {
+		return configurable.withVerifyOptions(opts)
+	}
+	return checker
 }
 
 const (
-	err005 = "(proc/005) fileset %q underscore prefix reserved for internal use"
+	err005 = "(proc/005) fileset %q is reserved for internal use"
+	err006 = "(proc/006) fileset %q cannot contain the namespace separator"
 	err010 = "(proc/010) parse file checks:%w"
 	err020 = "(proc/020) parse dir checks:%w"
 	err030 = "(proc/030) unknown check %q"
@@ -48,46 +103,193 @@ const (
 	err090 = "(proc/090) delete fileset %q:%w"
 	err100 = "(proc/100) list filesets:%w"
 	err110 = "(proc/110) copy fileset:%w"
+	err115 = "(proc/115) rename fileset:%w"
 	err120 = "(proc/120) query files %q:%w"
+	err125 = "(proc/125) delete %q, no records in fileset %q"
 	err130 = "(proc/130) delete file:%w"
 	err140 = "(proc/140) verify fileset %q signature:%w"
 	err150 = "(proc/150) sign fileset %q:%w"
+	err160 = "(proc/160) file %q is not located in the home directory"
+	err170 = "(proc/170) fileset %q cannot mix home relative and absolute paths"
+	err180 = "(proc/180) unknown modtime precision %q"
+	err190 = "(proc/190) select changes fileset %q:%w"
+	err200 = "(proc/200) list snapshots fileset %q:%w"
+	err210 = "(proc/210) invalid path mapping %q, expected FROM=TO"
+	err220 = "(proc/220) fingerprint fileset %q:%w"
+	err230 = "(proc/230) baseline integrity check failed, fileset %q has fingerprint %s"
+	err240 = "(proc/240) fileset %q cannot mix resolved and unresolved paths"
 )
 
 const (
-	msg010 = "%s:basic:%v"
-	msg020 = "%s:basic:file mutation"
-	msg030 = "%s:basic:dir mutation"
 	msg040 = "%s:%s:%v"
 	msg060 = "%v:%v"
 	msg070 = "skip %s"
 	msg080 = "%d entries with prefix %q"
 	msg085 = "%d entries"
+	msg087 = "fileset %q"
 	msg090 = "%s"
+	msg100 = "skip content checks %s, size %d exceeds %d"
+	msg110 = "unchanged %s"
+	msg120 = "fileset %q signed, snapshot %s"
+	msg130 = "exclude %s, size %d"
+	msg140 = "skip %s, not a regular file (%s)"
+	msg145 = "exclude %s, matches %q"
+	msg146 = "not descending into %s, the directory was added as %s"
+	msg150 = "%d records deleted for %s"
 )
 
+// Options that control how files and directories are added to a fileset.
+type AddOptions struct {
+	// Add directories recursively.
+	Recursive bool
+	// Overwrite records that are already in the fileset.
+	Overwrite bool
+	// Ignore files that are already in the fileset.
+	Skip bool
+	// Comma separated list of file checks.
+	FileChecks string
+	// Comma separated list of directory checks.
+	DirChecks string
+	// Store paths in the home directory as "~/...".
+	HomeRelative bool
+	// Resolve the symbolic links in the paths, the records are keyed by the real paths.
+	Resolve bool
+	// Follow the symbolic links and descend into the linked directories. A directory that was already added through
+	// another path is not descended into again, this breaks the cycles. Otherwise the links are recorded as links.
+	FollowSymlinks bool
+	// Key the records of a new fileset by a keyed hash of their paths, see db.EnableHashedPaths.
+	HashPaths bool
+	// Maintain the root of a hash tree of the records, see db.EnableTreeRoot.
+	TreeRoot bool
+	// Number of workers that prepare the checks of the files, e.g. the content hashes, while the directories are
+	// walked. The records are stored in the order of the walk. 0 or 1 to prepare the files in turn.
+	Jobs int
+	// Files larger than this number of bytes are added without content checks. No limit if 0.
+	MaxFileSize int64
+	// Files up to this number of bytes are stored by the content check. The default if 0.
+	ContentLimit int64
+	// The compressed contents of files smaller than this number of bytes are stored in the record,
+	// a failed content check shows a diff. Nothing is stored if 0.
+	InlineUnder int64
+	// Globs of the files and directories that are not added, the directories are not descended into.
+	// A glob without a separator matches the base name, e.g. "node_modules", otherwise the complete path.
+	Exclude []string
+	// Files larger than this number of bytes are not added. No limit if 0.
+	ExcludeLargerThan int64
+	// Files smaller than this number of bytes are not added.
+	ExcludeSmallerThan int64
+	// Skip the device nodes, fifos and sockets, only regular files and directories are added.
+	RegularOnly bool
+	// Record the content checks as pending, they are computed later by ComputePending.
+	DeferContent bool
+	// Record the files owned by the current user and its primary group as "$SELF", they match the verifying user.
+	SelfOwner bool
+	// Only overwrite existing records if the new record differs.
+	OverwriteIfChanged bool
+	// Resolution of the recorded modification times: second, millisecond or nanosecond.
+	// Empty for the default nanosecond resolution.
+	ModTimePrecision string
+	// Count the files and directories with a prewalk first, the progress events include the total.
+	Progress bool
+	// Receives the progress events, can be nil.
+	Events EventSink
+	// How the files are read.
+	ReadOptions
+}
+
+// Refuse the names that cannot be used for a fileset, the reserved names and the names that would address a fileset
+// of another namespace.
+func checkFileset(fileset string, tripDb *db.TriplineDb) error {
+	if tripDb.IsReservedFileset(fileset) {
+		return fmt.Errorf(err005, fileset)
+	}
+	if db.HasNamespaceSeparator(fileset) {
+		return fmt.Errorf(err006, fileset)
+	}
+	return nil
+}
+
+// The logger of the messages of a command, the standard logger if nil.
+func loggerOrStd(logger *log.Logger) *log.Logger {
+	if logger == nil {
+		return log.New(log.Writer(), log.Prefix(), log.Flags())
+	}
+	return logger
+}
+
 // Add the slice of file or directory names to the fileset. The fileset is created if it does not exist.
-func AddFiles(fileNames []string, fileset string, recursive bool, overwrite bool, skip bool, filechecks string, dirchecks string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+func AddFiles(fileNames []string, fileset string, opts *AddOptions, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
 	}
 
-	fc, err := parseFileChecks(filechecks)
+	fc, err := parseFileChecks(opts.FileChecks)
+	if err != nil {
+		return fmt.Errorf(err010, err)
+	}
+	err = checkGlobs(opts.Exclude)
+	if err != nil {
+		return err
+	}
+	dc, err := parseDirChecks(opts.DirChecks)
 	if err != nil {
-		log.Fatalf(err010, err)
+		return fmt.Errorf(err020, err)
+	}
+
+	if _, ok := modTimePrecisions[opts.ModTimePrecision]; !ok && len(opts.ModTimePrecision) > 0 {
+		return fmt.Errorf(err180, opts.ModTimePrecision)
+	}
+
+	if opts.ContentLimit > 0 {
+		err = checkContentLimit(opts.ContentLimit)
+		if err != nil {
+			return err
+		}
 	}
-	dc, err := parseDirChecks(dirchecks)
+
+	err = checkFilesetStyle(fileset, opts.HomeRelative, opts.Resolve, tripDb)
 	if err != nil {
-		log.Fatalf(err020, err)
+		return err
+	}
+	if opts.HashPaths {
+		err = tripDb.EnableHashedPaths(fileset)
+		if err != nil {
+			return err
+		}
+	}
+	if opts.TreeRoot {
+		err = tripDb.EnableTreeRoot(fileset)
+		if err != nil {
+			return err
+		}
 	}
 
+	a := &adder{fileset: fileset, opts: opts, filechecks: fc, dirchecks: dc, tripDb: tripDb, visited: make(map[string]string)}
+	if opts.Progress {
+		estimate, err := EstimateAdd(fileNames, opts)
+		if err != nil {
+			return err
+		}
+		a.total = estimate.Files + estimate.Dirs
+	}
+	if opts.Jobs > 1 {
+		a.startWorkers(opts.Jobs)
+		defer a.stopWorkers()
+	}
 	for _, fn := range fileNames {
-		err := addFileOrDir(fn, fileset, recursive, overwrite, skip, fc, dc, tripDb)
+		err := a.addFileOrDir(fn, nil)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	if a.work != nil {
+		// Store the records of the files that are still being prepared.
+		err = a.drain(0)
+		if err != nil {
+			return err
+		}
+	}
+	return touchFileset(fileset, tripDb)
 }
 
 func parseFileChecks(checks string) ([]string, error) {
@@ -108,7 +310,7 @@ func parseDirChecks(checks string) ([]string, error) {
 
 // Split the string of identifiers "check1,check-2,...,check-n" into a slice and verify that each identifier
 // is a valid one, it is a member of the set of valid identifiers.
-func splitChecks(checks string, validSet map[string]fileChecker) ([]string, error) {
+func splitChecks(checks string, validSet map[string]FileChecker) ([]string, error) {
 	result := strings.Split(checks, ",")
 	for i, c := range result {
 		result[i] = strings.ToLower(strings.TrimSpace(c))
@@ -120,26 +322,141 @@ func splitChecks(checks string, validSet map[string]fileChecker) ([]string, erro
 	return result, nil
 }
 
-func addFileOrDir(fn string, fileset string, recursive bool, overwrite bool, skip bool, filechecks []string, dirchecks []string, tripDb *db.TriplineDb) error {
-	fqn, err := filepath.Abs(fn)
+// Split a list of checks like splitChecks, a check is valid if it is a file or a directory check.
+func ParseChecks(checks string) ([]string, error) {
+	result := strings.Split(checks, ",")
+	for i, c := range result {
+		result[i] = strings.ToLower(strings.TrimSpace(c))
+		_, isFileCheck := fileChecks[result[i]]
+		_, isDirCheck := dirChecks[result[i]]
+		if !isFileCheck && !isDirCheck {
+			return nil, fmt.Errorf(err030, result[i])
+		}
+	}
+	return result, nil
+}
+
+// Remove the content checks from a list of checks.
+func withoutContentChecks(checks []string) []string {
+	result := make([]string, 0, len(checks))
+	for _, checkName := range checks {
+		if !contentChecks[checkName] {
+			result = append(result, checkName)
+		}
+	}
+	return result
+}
+
+// Select the checks that read the file contents.
+func onlyContentChecks(checks []string) []string {
+	var result []string
+	for _, checkName := range checks {
+		if contentChecks[checkName] {
+			result = append(result, checkName)
+		}
+	}
+	return result
+}
+
+// The checks of the record that have data.
+func withoutPending(rec *db.TriplineRecord) []string {
+	if len(rec.Pending) == 0 {
+		return rec.Checks
+	}
+	result := make([]string, 0, len(rec.Checks))
+	for _, checkName := range rec.Checks {
+		if !isPending(rec, checkName) {
+			result = append(result, checkName)
+		}
+	}
+	return result
+}
+
+// Check if the data of a check was deferred.
+func isPending(rec *db.TriplineRecord, checkName string) bool {
+	for _, pending := range rec.Pending {
+		if pending == checkName {
+			return true
+		}
+	}
+	return false
+}
+
+// State of an add run.
+type adder struct {
+	fileset    string
+	opts       *AddOptions
+	filechecks []string
+	dirchecks  []string
+	tripDb     *db.TriplineDb
+	// Number of records written.
+	done int
+	// Number of records the prewalk counted, 0 without prewalk.
+	total int
+	// The files for the workers that prepare the checks, nil if the files are prepared in turn. See AddOptions.Jobs.
+	work    chan *addJob
+	stop    chan struct{}
+	workers sync.WaitGroup
+	// The submitted files in the order of the walk, the records are stored in this order.
+	queue []*addJob
+	// The identities of the directories that were descended into with the path that reached them first, see
+	// AddOptions.FollowSymlinks.
+	visited map[string]string
+}
+
+// The ignore rules are the rules of the .triplineignore files in the directories above the file, see readIgnore.
+func (a *adder) addFileOrDir(fn string, rules []ignoreRule) error {
+	if err := a.opts.interrupted(); err != nil {
+		return err
+	}
+	fqn, err := absPath(fn, a.opts.Resolve)
 	if err != nil {
 		return fmt.Errorf(err040, fn, err)
 	}
 
-	fi, err := os.Stat(fqn)
+	if glob := matchingGlob(fqn, a.opts.Exclude); len(glob) > 0 {
+		a.opts.logger().Printf(msg145, fqn, glob)
+		return nil
+	}
+
+	// The key under which the record is stored.
+	key := fqn
+	if a.opts.HomeRelative {
+		key, err = compressHome(fqn)
+		if err != nil {
+			return err
+		}
+	}
+
+	fi, err := addStat(a.opts.FollowSymlinks, a.filechecks, a.dirchecks)(fqn)
 	if err != nil {
 		return fmt.Errorf(err040, fn, err)
 	}
+	if rule := matchingIgnoreRule(fqn, fi.IsDir(), rules); rule != nil {
+		a.opts.logger().Printf(msg145, fqn, rule.glob)
+		return nil
+	}
+	isLink := fi.Mode()&os.ModeSymlink != 0
+	if a.opts.RegularOnly && !fi.Mode().IsRegular() && !fi.IsDir() && !isLink {
+		// Opening a fifo for the content checks would block.
+		a.opts.logger().Printf(msg140, key, fileType(fi.Mode()))
+		return nil
+	}
+	if a.excluded(fi) {
+		a.opts.logger().Printf(msg130, key, fi.Size())
+		return nil
+	}
 
 	rec := &db.TriplineRecord{}
 	rec.IsDir = fi.IsDir()
+	rec.Type = fileType(fi.Mode())
 	rec.Data = make(map[string]interface{})
 	if rec.IsDir {
 		// It is a directory, walk over the directory checkers to collect data necessary for later verification.
-		rec.Checks = dirchecks
-		for _, checkName := range dirchecks {
-			check, _ := dirChecks[checkName]
-			checkData, err := check.prepareCheck(fqn, fi)
+		rec.Checks = a.dirchecks
+		for _, checkName := range a.dirchecks {
+			check := addChecker(dirChecks[checkName], a.opts)
+			checkData, err := check.PrepareCheck(fqn, fi)
 			if err != nil {
 				// Error while producing verification data
 				return fmt.Errorf(err050, fqn, checkName, err)
@@ -148,25 +465,150 @@ func addFileOrDir(fn string, fileset string, recursive bool, overwrite bool, ski
 		}
 	} else {
 		// It is a file, walk over the file checkers to collect data necessary for later verification.
-		rec.Checks = filechecks
-		for _, checkName := range filechecks {
-			check, _ := fileChecks[checkName]
-			checkData, err := check.prepareCheck(fqn, fi)
+		checks := a.filechecks
+		if a.opts.MaxFileSize > 0 && fi.Size() > a.opts.MaxFileSize {
+			// Only record the metadata checks of files that are too large to read.
+			checks = withoutContentChecks(a.filechecks)
+			a.opts.logger().Printf(msg100, fqn, fi.Size(), a.opts.MaxFileSize)
+		}
+		if isLink {
+			// The target is recorded separately when it is part of the fileset.
+			checks = withoutContentChecks(checks)
+		}
+		rec.Checks = checks
+		if a.opts.DeferContent {
+			// Only the metadata is recorded now.
+			rec.Pending = onlyContentChecks(checks)
+			checks = withoutContentChecks(checks)
+		}
+		if a.work != nil {
+			// The checks are prepared by the workers, the record is stored when it is its turn.
+			return a.submit(&addJob{key: key, fqn: fqn, fi: fi, checks: checks, rec: rec})
+		}
+		err := a.prepareFile(fqn, fi, checks, rec)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = a.store(key, fqn, rec)
+	if err != nil {
+		return err
+	}
+
+	if rec.IsDir && a.opts.Recursive {
+		if a.opts.FollowSymlinks {
+			id, err := fileIdentity(fqn, fi)
 			if err != nil {
-				// Error while producing verification data
-				return fmt.Errorf(err060, fqn, checkName, err)
+				return fmt.Errorf(err040, fn, err)
 			}
-			rec.Data[checkName] = checkData
+			if first, ok := a.visited[id]; ok {
+				a.opts.logger().Printf(msg146, key, first)
+				return nil
+			}
+			a.visited[id] = key
+		}
+		children, err := ioutil.ReadDir(fqn)
+		if err != nil {
+			return err
+		}
+		childRules, err := readIgnore(fqn, rules)
+		if err != nil {
+			return err
+		}
+		for _, child := range children {
+			cfqn := filepath.Join(fqn, child.Name())
+			err := a.addFileOrDir(cfqn, childRules)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Collect the data of the file checks, the content hashes are calculated in a single pass over the file.
+// It does not touch the database, the workers call it concurrently.
+func (a *adder) prepareFile(fqn string, fi os.FileInfo, checks []string, rec *db.TriplineRecord) error {
+	digests, err := hashChecks(fqn, checks, fileChecks, &a.opts.ReadOptions)
+	if err != nil {
+		return fmt.Errorf(err060, fqn, "content", err)
+	}
+	for _, checkName := range checks {
+		if digest, found := digests[checkName]; found {
+			rec.Data[checkName] = digest
+			continue
+		}
+		check := addChecker(fileChecks[checkName], a.opts)
+		checkData, err := check.PrepareCheck(fqn, fi)
+		if err != nil {
+			// Error while producing verification data
+			return fmt.Errorf(err060, fqn, checkName, err)
 		}
+		rec.Data[checkName] = checkData
 	}
+	if a.opts.InlineUnder > 0 && fi.Mode().IsRegular() && fi.Size() < a.opts.InlineUnder && len(onlyContentChecks(checks)) > 0 {
+		content, err := inlineContent(fqn)
+		if err != nil {
+			return fmt.Errorf(err060, fqn, inlineData, err)
+		}
+		rec.Data[inlineData] = content
+	}
+	return nil
+}
 
-	err = tripDb.AddTriplineRecord(fqn, rec, fileset, overwrite)
+// Store the record and report the progress.
+func (a *adder) store(key string, fqn string, rec *db.TriplineRecord) error {
+	err := a.storeRecord(key, fqn, rec)
+	if err != nil {
+		return err
+	}
+	a.done++
+	a.opts.Events.emit(&Event{Type: EventProgress, Done: a.done, Total: a.total})
+	return nil
+}
+
+// Apply the size filters, directories are never excluded.
+// The size filters are applied before the max file size, an excluded file is not recorded at all.
+func (a *adder) excluded(fi os.FileInfo) bool {
+	if fi.IsDir() {
+		return false
+	}
+	if a.opts.ExcludeLargerThan > 0 && fi.Size() > a.opts.ExcludeLargerThan {
+		return true
+	}
+	return fi.Size() < a.opts.ExcludeSmallerThan
+}
+
+// Write the record to the fileset according to the overwrite and skip options.
+func (a *adder) storeRecord(key string, fqn string, rec *db.TriplineRecord) error {
+	overwrite := a.opts.Overwrite
+	if a.opts.OverwriteIfChanged {
+		existing, err := a.tripDb.GetTriplineRecord(key, a.fileset)
+		if err != nil {
+			return fmt.Errorf(err070, fqn, err)
+		}
+		if existing != nil {
+			same, err := sameRecord(rec, existing)
+			if err != nil {
+				return fmt.Errorf(err070, fqn, err)
+			}
+			if same {
+				// Nothing changed, leave the stored record alone.
+				a.opts.logger().Printf(msg110, key)
+				return nil
+			}
+		}
+		overwrite = true
+	}
+
+	err := a.tripDb.AddTriplineRecord(key, rec, a.fileset, overwrite)
 	if err != nil {
 		if errors.Is(err, db.RecordExists) {
-			if skip {
+			if a.opts.Skip {
 				// Ignore the error, we are skipping the files when the
 				// skip flag is set.
-				log.Printf(msg070, fqn)
+				a.opts.logger().Printf(msg070, key)
 			} else {
 				// If the skip flag is not set a duplicate record results in an error
 				return fmt.Errorf(err070, fqn, err)
@@ -176,48 +618,122 @@ func addFileOrDir(fn string, fileset string, recursive bool, overwrite bool, ski
 			return fmt.Errorf(err070, fqn, err)
 		}
 	}
+	return nil
+}
 
-	if rec.IsDir && recursive {
-		children, err := ioutil.ReadDir(fqn)
-		if err != nil {
-			return err
-		}
-		for _, child := range children {
-			cfqn := filepath.Join(fqn, child.Name())
-			err := addFileOrDir(cfqn, fileset, recursive, overwrite, skip, filechecks, dirchecks, tripDb)
-			if err != nil {
-				return err
-			}
-		}
+// Compare a freshly prepared record with a record read from the database.
+// The fresh record holds the checker data types, the stored one the generic json types. A json round trip of the
+// fresh record makes them comparable.
+func sameRecord(fresh *db.TriplineRecord, stored *db.TriplineRecord) (bool, error) {
+	jsn, err := json.Marshal(fresh)
+	if err != nil {
+		return false, err
 	}
-	return nil
+	normalized := db.TriplineRecord{}
+	err = json.Unmarshal(jsn, &normalized)
+	if err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(&normalized, stored), nil
 }
 
-func ListRecords(fileset string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+// Options that control the listing of a fileset.
+type ListOptions struct {
+	// Only list the paths, not the recorded data.
+	PathsOnly bool
+	// Sort by recorded size, largest first.
+	SortBySize bool
+	// Sort by recorded modification time, newest first.
+	SortByTime bool
+	// List the records as a json array in the export format.
+	JSON bool
+	// Indent the json.
+	Pretty bool
+	// Logger of the listing, the standard logger if nil.
+	Log *log.Logger
+}
+
+func ListRecords(fileset string, opts *ListOptions, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
 	}
 
 	entries, err := tripDb.ListTriplineRecords(fileset)
 	if err != nil {
 		return fmt.Errorf(err080, fileset, err)
 	}
+	logger := loggerOrStd(opts.Log)
+	// The entries are sorted by path, the sort is stable so equal keys remain sorted by path.
+	if opts.SortBySize {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return recordedSize(entries[i].Record) > recordedSize(entries[j].Record)
+		})
+	} else if opts.SortByTime {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return recordedModTime(entries[i].Record).After(recordedModTime(entries[j].Record))
+		})
+	}
+
+	if opts.JSON {
+		exported := make([]exportEntry, 0, len(entries))
+		for _, entry := range entries {
+			jsn, err := json.Marshal(entry.Record)
+			if err != nil {
+				return fmt.Errorf(err080, fileset, err)
+			}
+			exported = append(exported, exportEntry{entry.Path, jsn})
+		}
+		jsn, err := marshalExport(exported, opts.Pretty)
+		if err != nil {
+			return fmt.Errorf(err080, fileset, err)
+		}
+		logger.Println(string(jsn))
+		return nil
+	}
+
 	for _, rec := range entries {
+		if opts.PathsOnly {
+			logger.Printf(msg090, rec.Path)
+			continue
+		}
 		pretty, err := json.Marshal(rec.Record)
 		if err != nil {
 			// Just print the record without formatting.
-			log.Printf(msg060, rec.Path, rec.Record)
+			logger.Printf(msg060, rec.Path, rec.Record)
 		} else {
 			// Here we have json formatting.
-			log.Printf(msg060, rec.Path, string(pretty))
+			logger.Printf(msg060, rec.Path, string(pretty))
 		}
 	}
 	return nil
 }
 
+// The recorded size of a file, -1 if no size was recorded.
+func recordedSize(rec db.TriplineRecord) int64 {
+	repr, ok := rec.Data["size"].(string)
+	if !ok {
+		return -1
+	}
+	size, err := strconv.ParseInt(repr, 10, 64)
+	if err != nil {
+		return -1
+	}
+	return size
+}
+
+// The recorded modification time, the zero time if no modification time was recorded.
+func recordedModTime(rec db.TriplineRecord) time.Time {
+	repr, _ := modTimeRepr(rec.Data["modtime"])
+	mtime, err := time.Parse(storageFormat, repr)
+	if err != nil {
+		return time.Time{}
+	}
+	return mtime
+}
+
 func DeleteSet(fileset string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
 	}
 
 	err := tripDb.DeleteFileset(fileset)
@@ -227,113 +743,404 @@ func DeleteSet(fileset string, tripDb *db.TriplineDb) error {
 	return nil
 }
 
-func VerifyFiles(fileNames []string, fileset string, tripDb *db.TriplineDb) (int, error) {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+// Options that control the verification of a fileset.
+type VerifyOptions struct {
+	// Only verify the records that changed since the signature snapshot with this timestamp, or "latest".
+	// Empty to verify all records.
+	SinceSignature string
+	// Receives the progress and result events, can be nil.
+	Events EventSink
+	// Translations of the recorded paths to the paths on the filesystem.
+	PathMaps []PathMap
+	// Only run the basic checks, the existence and the type of the files. The recorded checks are skipped.
+	ExistenceOnly bool
+	// Only verify the records whose recorded data satisfies all the clauses, e.g. a known bad sha256.
+	Where []WhereClause
+	// The name of a saved query of the fileset that selects the records and checks, see SaveQuery.
+	// Its prefixes are verified when there are no file names.
+	Query string
+	// Remembers the outcome of the checks when several filesets are verified in a run, can be nil.
+	Cache *VerifyCache
+	// Number of threads reading the file contents and number of threads hashing them. When one of them is set the
+	// contents are read and hashed in a pipeline, a missing count defaults to 1. Both 0 to read and hash each file in turn.
+	IOThreads  int
+	CPUThreads int
+	// Report the files and directories below the recorded directories that are not recorded themselves.
+	ClosedWorld bool
+	// Checks that each record should have, the records that lack one of them violate the policy.
+	// Only the checks that apply to the kind of record are required, e.g. sha256 is not required for directories.
+	RequireChecks []string
+	// Expected fingerprint of the fileset in hex, the verification is aborted if it does not match.
+	// Empty to skip the integrity check.
+	BaselineHash string
+	// Remapping tables of the user and the group ids for the ownership check. When tables are set, the ownership
+	// check compares the remapped numeric ids instead of the names.
+	UidMaps []IdMap
+	GidMaps []IdMap
+	// The permission bits that are not compared by the permissions check, e.g. 07000 to ignore the setuid, setgid
+	// and sticky bits. The bits apply to the unix style mode 07777.
+	IgnorePermissions uint32
+	// How the files are read, including the retries after transient errors.
+	ReadOptions
+}
+
+// State of a verification run.
+type verifier struct {
+	fileset string
+	opts    *VerifyOptions
+	report  *VerifyReport
+	tripDb  *db.TriplineDb
+	// Paths of the records that changed since the signature snapshot, nil if all records are verified.
+	changed map[string]bool
+	// Paths of the records that were removed since the signature snapshot.
+	removed []string
+	// Filesystem paths of all the records in the fileset, for the closed world check. Loaded on first use.
+	recorded map[string]bool
+	// The saved query that selects the records and checks, nil to verify everything.
+	query *db.SavedQuery
+}
+
+// Verify the files and directories in the fileset against the filesystem.
+// The file names select the records to verify using their path as a prefix, the complete fileset is verified if
+// there are no file names. The results are collected in a report, nothing is written to the output.
+func VerifyFiles(fileNames []string, fileset string, opts *VerifyOptions, tripDb *db.TriplineDb) (*VerifyReport, error) {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return nil, err
+	}
+
+	if len(opts.BaselineHash) > 0 {
+		// Check the integrity of the baseline before touching the filesystem.
+		fingerprint, err := filesetFingerprint(fileset, tripDb)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.EqualFold(fingerprint, strings.TrimSpace(opts.BaselineHash)) {
+			return nil, fmt.Errorf(err230, fileset, fingerprint)
+		}
+	}
+
+	updatedAt, err := logBaselineAge(fileset, opts.logger(), tripDb)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &verifier{fileset: fileset, opts: opts, report: &VerifyReport{UpdatedAt: updatedAt}, tripDb: tripDb}
+	if len(opts.SinceSignature) > 0 {
+		err := v.selectChangedSince(opts.SinceSignature)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.Query) > 0 {
+		v.query, err = tripDb.GetQuery(fileset, opts.Query)
+		if err != nil {
+			return nil, err
+		}
+		if len(fileNames) == 0 {
+			fileNames = v.query.Prefixes
+		}
 	}
 
-	totalFails := 0
 	if len(fileNames) == 0 {
-		fails, err := verifyFile("", fileset, tripDb)
+		err := v.verifyFile("")
 		if err != nil {
-			return 0, err
+			return nil, err
 		}
-		totalFails += fails
 	} else {
+		// The file names are resolved in the same way as the recorded paths.
+		meta, err := tripDb.GetFilesetMeta(fileset)
+		if err != nil {
+			return nil, fmt.Errorf(err120, fileset, err)
+		}
 		for _, fn := range fileNames {
-			fqn, err := filepath.Abs(fn)
+			fqn, err := absPath(fn, meta.Resolve)
 			if err != nil {
-				return 0, fmt.Errorf("file %q:%v", fn, err)
+				return nil, fmt.Errorf(err040, fn, err)
 			}
 
-			fails, err := verifyFile(fqn, fileset, tripDb)
+			err = v.verifyFile(fqn)
 			if err != nil {
-				return 0, err
+				return nil, err
 			}
-			totalFails += fails
 		}
 	}
-	return totalFails, nil
+	return v.report, nil
 }
 
-func verifyFile(fqn string, fileset string, tripDb *db.TriplineDb) (int, error) {
-	entries, err := tripDb.QueryTriplineRecords(fileset, fqn)
+// Print the fingerprint of the fileset, the hex encoded hash of its contents.
+// It can be used with the baseline hash option of the verification.
+func Fingerprint(fileset string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	fingerprint, err := filesetFingerprint(fileset, tripDb)
 	if err != nil {
-		return 0, fmt.Errorf(err120, fqn, err)
+		return err
 	}
+	logger = loggerOrStd(logger)
+	logger.Printf(msg090, fingerprint)
+	return logTreeRoot(fileset, logger, tripDb)
+}
 
-	// Report nr. of matching entries in case the user provided wrong input
-	// The user can see that the input is used as a prefix which sometimes happens with options that are not spelled
-	// correctly.
-	if len(fqn) > 0 {
-		log.Printf(msg080, len(entries), fqn)
-	} else {
-		log.Printf(msg085, len(entries))
+func filesetFingerprint(fileset string, tripDb *db.TriplineDb) (string, error) {
+	hash, err := tripDb.FilesetHash(fileset)
+	if err != nil {
+		return "", fmt.Errorf(err220, fileset, err)
+	}
+	return hex.EncodeToString(hash), nil
+}
+
+// Report the required checks that are missing from the record.
+func (v *verifier) checkPolicy(section *VerifySection, entry db.TriplineEntry) {
+	validChecks := fileChecks
+	if entry.Record.IsDir {
+		validChecks = dirChecks
+	}
+	for _, required := range v.opts.RequireChecks {
+		if _, valid := validChecks[required]; !valid {
+			continue
+		}
+		found := false
+		for _, checkName := range entry.Record.Checks {
+			if checkName == required {
+				found = true
+				break
+			}
+		}
+		if !found {
+			v.add(section, entry.Path, policyCheck, fmt.Errorf("missing required check %q", required))
+		}
+	}
+}
+
+// Add a result to the section of the report and notify the event sink.
+func (v *verifier) add(section *VerifySection, path string, check string, err error) {
+	section.add(path, check, err)
+	v.emitResult(section.Results[len(section.Results)-1])
+}
+
+// Notify the event sink of a result.
+func (v *verifier) emitResult(result CheckResult) {
+	if v.opts.Events != nil {
+		ok := result.Status == StatusOk
+		v.opts.Events.emit(&Event{Type: EventResult, Path: result.Path, Check: result.Check, Ok: &ok, Detail: result.Detail,
+			Missing: result.Missing, Change: result.Change, Diff: result.Diff})
+	}
+}
+
+// Compare the current records with a signature snapshot and remember the paths that were added, modified or
+// removed since. Only those are verified, it narrows the review after a re-baseline.
+func (v *verifier) selectChangedSince(ts string) error {
+	if ts == "latest" {
+		snapshots, err := v.tripDb.ListFilesetSnapshots(v.fileset)
+		if err != nil {
+			return fmt.Errorf(err190, v.fileset, err)
+		}
+		if len(snapshots) == 0 {
+			return fmt.Errorf(err190, v.fileset, errors.New("no snapshots, sign the fileset first"))
+		}
+		ts = snapshots[len(snapshots)-1]
+	}
+	snapshot, err := v.tripDb.GetFilesetSnapshot(v.fileset, ts)
+	if err != nil {
+		return fmt.Errorf(err190, v.fileset, err)
+	}
+	current, err := v.tripDb.FilesetRecordHashes(v.fileset)
+	if err != nil {
+		return fmt.Errorf(err190, v.fileset, err)
 	}
 
-	fails := 0
-	for _, entry := range entries {
+	v.changed = make(map[string]bool)
+	for p, hash := range current {
+		if snapshot[p] != hash {
+			v.changed[p] = true
+		}
+	}
+	v.removed = make([]string, 0)
+	for p := range snapshot {
+		if _, found := current[p]; !found {
+			v.removed = append(v.removed, p)
+		}
+	}
+	sort.Strings(v.removed)
+	return nil
+}
+
+func (v *verifier) verifyFile(fqn string) error {
+	entries, err := queryRecords(v.fileset, fqn, v.tripDb)
+	if err != nil {
+		return fmt.Errorf(err120, fqn, err)
+	}
+
+	if v.changed != nil {
+		// Only keep the records that changed since the snapshot.
+		selected := make([]db.TriplineEntry, 0)
+		for _, entry := range entries {
+			if v.changed[entry.Path] {
+				selected = append(selected, entry)
+			}
+		}
+		entries = selected
+	}
+	if len(v.opts.Where) > 0 || v.query != nil {
+		selected := make([]db.TriplineEntry, 0)
+		for _, entry := range entries {
+			if matchesWhere(&entry.Record, v.opts.Where) && matchesQuery(entry.Path, v.query) {
+				selected = append(selected, entry)
+			}
+		}
+		entries = selected
+	}
+	return v.verifyEntries(fqn, entries)
+}
+
+// Verify the records in a new section of the report, the prefix selected the records.
+func (v *verifier) verifyEntries(fqn string, entries []db.TriplineEntry) error {
+	section := v.report.newSection(v.fileset, fqn, len(entries))
+	var pipeline *hashPipeline
+	if (v.opts.IOThreads > 0 || v.opts.CPUThreads > 0) && !v.opts.ExistenceOnly {
+		var err error
+		pipeline, err = newHashPipeline(entries, atLeastOne(v.opts.IOThreads), atLeastOne(v.opts.CPUThreads), v.opts.PathMaps, &v.opts.ReadOptions)
+		if err != nil {
+			return err
+		}
+		defer pipeline.close()
+	}
+	for _, p := range v.removed {
+		if db.MatchesPathPrefix(p, fqn) {
+			v.add(section, p, basicCheck, errors.New("record removed since signature"))
+		}
+	}
+	for i, entry := range entries {
+		if i > 0 {
+			v.opts.Events.emit(&Event{Type: EventProgress, Done: i, Total: len(entries)})
+		}
+
+		if err := v.opts.interrupted(); err != nil {
+			return err
+		}
+		v.checkPolicy(section, entry)
+
+		// Home relative paths are resolved against the home directory of the current user.
+		path, err := expandHome(entry.Path)
+		if err != nil {
+			return err
+		}
+		path = translatePath(path, v.opts.PathMaps)
 
 		// Basic built-in checks
-		fi, err := os.Stat(entry.Path)
+		stat := v.opts.stat
+		if recordedAsLink(&entry.Record) {
+			stat = v.opts.lstat
+		}
+		fi, err := stat(path)
 		if err != nil {
-			fails++
-			log.Printf(msg010, entry.Path, "file not found")
+			v.add(section, entry.Path, basicCheck, &missingError{isDir: entry.Record.IsDir})
 			continue
 		}
 		if fi.IsDir() != entry.Record.IsDir {
-			fails++
 			if fi.IsDir() {
-				log.Printf(msg020, entry.Path)
+				v.add(section, entry.Path, basicCheck, errors.New("file mutation"))
 			} else {
-				log.Printf(msg030, entry.Path)
+				v.add(section, entry.Path, basicCheck, errors.New("dir mutation"))
 			}
 			continue
 		}
+		if len(entry.Record.Type) > 0 && entry.Record.Type != fileType(fi.Mode()) {
+			// A regular file that became a device, named pipe or socket.
+			v.add(section, entry.Path, basicCheck, fmt.Errorf("type mutation from %s to %s", entry.Record.Type, fileType(fi.Mode())))
+			continue
+		}
+
+		if v.opts.ExistenceOnly {
+			continue
+		}
+
+		// The content hashes are calculated in a single pass over the file.
+		var digests map[string]string
+		var digestErr error
+		if !entry.Record.IsDir {
+			if pipeline != nil {
+				digests, digestErr = pipeline.digests(i)
+			} else {
+				digests, digestErr = v.opts.Cache.hashChecks(path, withoutPending(&entry.Record), fileChecks, &v.opts.ReadOptions)
+			}
+		}
 
 		// user selected checks
+		first := len(section.Results)
 		for _, checkName := range entry.Record.Checks {
-			var checker fileChecker
+			if !queryRunsCheck(v.query, checkName) {
+				continue
+			}
+			var checker FileChecker
 			if entry.Record.IsDir {
 				checker = dirChecks[checkName]
 			} else {
 				checker = fileChecks[checkName]
 			}
 			if checker == nil {
-				log.Printf(msg040, entry.Path, checkName, "unknown check")
-				fails++
+				section.add(entry.Path, checkName, missingChecker(checkName))
 				continue
 			}
-			// Execute the check.
-			checkErr := checker.executeCheck(entry.Path, entry.Record.Data[checkName], fi)
-			if checkErr != nil {
-				log.Printf(msg040, entry.Path, checkName, checkErr)
-				fails++
+			checker = verifyChecker(checker, v.opts)
+			if isPending(&entry.Record, checkName) {
+				section.add(entry.Path, checkName, &pendingError{})
+				continue
+			}
+			if _, isHasher := checker.(contentHasher); isHasher {
+				err := digestErr
+				if err == nil {
+					err = compareDigest(entry.Record.Data[checkName], digests[checkName])
+				}
+				section.add(entry.Path, checkName, err)
+				continue
 			}
+			// Execute the check.
+			section.add(entry.Path, checkName, v.opts.Cache.executeCheck(checkName, checker, path, entry.Record.Data[checkName], fi))
+		}
+		// The results of the checks are classified before they are notified.
+		classifyContentChange(section.Results[first:])
+		if encoded, found := entry.Record.Data[inlineData].(string); found {
+			attachContentDiff(section.Results[first:], encoded, path)
+		}
+		if timestomped(section.Results[first:]) {
+			section.add(entry.Path, tamperCheck, errors.New("content changed without modtime update, likely tampering"))
 		}
+		for _, result := range section.Results[first:] {
+			v.emitResult(result)
+		}
+	}
+	v.opts.Events.emit(&Event{Type: EventProgress, Done: len(entries), Total: len(entries)})
+
+	if v.opts.ClosedWorld {
+		return v.checkClosedWorld(section, entries)
 	}
-	return fails, nil
+	return nil
 }
 
 // List the file sets in the database.
-func Listsets(tripDb *db.TriplineDb) error {
+func Listsets(logger *log.Logger, tripDb *db.TriplineDb) error {
 	sets, err := tripDb.ListFilesets()
 	if err != nil {
 		return fmt.Errorf(err100, err)
 	}
+	logger = loggerOrStd(logger)
 	for _, set := range sets {
-		log.Printf(msg090, set)
+		logger.Printf(msg090, set)
 	}
 	return nil
 }
 
 func CopySet(from, to string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(from, "_") {
-		log.Fatalf(err005, from)
+	if err := checkFileset(from, tripDb); err != nil {
+		return err
 	}
 
-	if strings.HasPrefix(to, "_") {
-		log.Fatalf(err005, to)
+	if err := checkFileset(to, tripDb); err != nil {
+		return err
 	}
 
 	err := tripDb.CopyFileset(from, to)
@@ -343,46 +1150,101 @@ func CopySet(from, to string, tripDb *db.TriplineDb) error {
 	return nil
 }
 
-func DeleteFiles(fileNames []string, fileset string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+// Rename the fileset in a single transaction, see db.RenameFileset.
+func RenameSet(from, to string, tripDb *db.TriplineDb) error {
+	if err := checkFileset(from, tripDb); err != nil {
+		return err
+	}
+	if err := checkFileset(to, tripDb); err != nil {
+		return err
 	}
 
+	err := tripDb.RenameFileset(from, to)
+	if err != nil {
+		return fmt.Errorf(err115, err)
+	}
+	return nil
+}
+
+// Delete the records of the files, a directory deletes the records below it as well. The number of deleted records is
+// logged per file name. In strict mode a file name without records is an error, e.g. a mistyped path.
+func DeleteFiles(fileNames []string, fileset string, strict bool, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	// The file names are resolved in the same way as the recorded paths.
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return fmt.Errorf(err120, fileset, err)
+	}
+
+	logger = loggerOrStd(logger)
 	for _, fn := range fileNames {
-		fqn, err := filepath.Abs(fn)
+		fqn, err := absPath(fn, meta.Resolve)
 		if err != nil {
 			return fmt.Errorf(err040, fn, err)
 		}
 
-		entries, err := tripDb.QueryTriplineRecords(fileset, fqn)
+		entries, err := queryRecords(fileset, fqn, tripDb)
 		if err != nil {
 			return fmt.Errorf(err120, fqn, err)
 		}
 
+		if strict && len(entries) == 0 {
+			return fmt.Errorf(err125, fqn, fileset)
+		}
 		for _, entry := range entries {
-			err := tripDb.DeleteTriplineRecord(entry.Path, fileset, true)
+			err := tripDb.DeleteTriplineRecord(entry.Path, fileset, !strict)
 			if err != nil {
-				return fmt.Errorf(err130, entry.Path)
+				return fmt.Errorf(err130, err)
 			}
 		}
+		logger.Printf(msg150, len(entries), fqn)
 	}
-	return nil
+	exists, err := tripDb.HasFileset(fileset)
+	if err != nil || !exists {
+		return err
+	}
+	return touchFileset(fileset, tripDb)
+}
+
+func SignSet(fileset string, password string, update bool, logger *log.Logger, tripDb *db.TriplineDb) (*SignResult, error) {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return nil, err
+	}
+	info, err := tripDb.SignFileset(fileset, password, update)
+	if err != nil {
+		return nil, fmt.Errorf(err150, fileset, err)
+	}
+	// Keep a snapshot of the signed records, a later verification can focus on the changes since.
+	ts, err := tripDb.SaveFilesetSnapshot(fileset)
+	if err != nil {
+		return nil, fmt.Errorf(err150, fileset, err)
+	}
+	loggerOrStd(logger).Printf(msg120, fileset, ts)
+	return &SignResult{Fileset: fileset, Fingerprint: hex.EncodeToString(info.Hash), Snapshot: ts, Signature: info.Signature}, nil
 }
 
-func SignSet(fileset string, password string, update bool, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+// List the signature snapshots of the fileset.
+func ListSnapshots(fileset string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
 	}
-	err := tripDb.SignFileset(fileset, password, update)
+	snapshots, err := tripDb.ListFilesetSnapshots(fileset)
 	if err != nil {
-		return fmt.Errorf(err150, fileset, err)
+		return fmt.Errorf(err200, fileset, err)
+	}
+	logger = loggerOrStd(logger)
+	for _, ts := range snapshots {
+		logger.Printf(msg090, ts)
 	}
 	return nil
 }
 
 func VerifySetSignature(fileset string, password string, tripDb *db.TriplineDb) error {
-	if strings.HasPrefix(fileset, "_") {
-		log.Fatalf(err005, fileset)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
 	}
 
 	err := tripDb.VerifyFilesetSignature(fileset, password)
@@ -0,0 +1,315 @@
+package proc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Collect the log output of the test, the standard logger is restored when the test ends.
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	out, flags := log.Writer(), log.Flags()
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	t.Cleanup(func() {
+		log.SetOutput(out)
+		log.SetFlags(flags)
+	})
+	return &buf
+}
+
+// A logger that collects the messages of a command, passed in the options or as the logger argument.
+func newTestLogger() (*log.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return log.New(&buf, "", 0), &buf
+}
+
+// Create the files below the directory with their name as the contents, the names are slash separated and the parent
+// directories are created.
+func writeTestFiles(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		writeTestFile(t, dir, name, name)
+	}
+}
+
+func writeTestFile(t *testing.T, dir string, name string, content string) {
+	t.Helper()
+	fqn := filepath.Join(dir, filepath.FromSlash(name))
+	err := os.MkdirAll(filepath.Dir(fqn), 0700)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(fqn, []byte(content), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The recorded paths of the fileset relative to the directory, slash separated and sorted.
+func recordedPaths(t *testing.T, tripDb *db.TriplineDb, fileset string, dir string) []string {
+	t.Helper()
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		rel, err := filepath.Rel(dir, entry.Path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result = append(result, filepath.ToSlash(rel))
+	}
+	return result
+}
+
+func equalPaths(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReservedFileset(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	reserved := "_signatures"
+	if !tripDb.IsReservedFileset(reserved) {
+		t.Fatalf("%q is not reserved", reserved)
+	}
+
+	operations := []struct {
+		name string
+		call func() error
+	}{
+		{"add", func() error { return AddFiles([]string{dir}, reserved, &AddOptions{}, tripDb) }},
+		{"list", func() error { return ListRecords(reserved, &ListOptions{}, tripDb) }},
+		{"deleteset", func() error { return DeleteSet(reserved, tripDb) }},
+		{"verify", func() error {
+			_, err := VerifyFiles([]string{dir}, reserved, &VerifyOptions{}, tripDb)
+			return err
+		}},
+		{"copyset from", func() error { return CopySet(reserved, "copy", tripDb) }},
+		{"copyset to", func() error { return CopySet("default", reserved, tripDb) }},
+		{"rename to", func() error { return RenameSet("default", reserved, tripDb) }},
+		{"delete", func() error { return DeleteFiles([]string{dir}, reserved, false, nil, tripDb) }},
+		{"sign", func() error {
+			_, err := SignSet(reserved, "secret", false, nil, tripDb)
+			return err
+		}},
+		{"verifysig", func() error { return VerifySetSignature(reserved, "secret", tripDb) }},
+	}
+	for _, operation := range operations {
+		err := operation.call()
+		if err == nil || err.Error() != "(proc/005) fileset \"_signatures\" is reserved for internal use" {
+			t.Errorf("%s: got error %v, want the reserved fileset error", operation.name, err)
+		}
+	}
+}
+
+func TestAddFilesUnknownCheck(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+
+	err := AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size,bogus"}, tripDb)
+	if err == nil {
+		t.Fatal("add with an unknown file check succeeded")
+	}
+	err = AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "bogus"}, tripDb)
+	if err == nil {
+		t.Fatal("add with an unknown dir check succeeded")
+	}
+	// The errors are returned before the fileset is created.
+	exists, err := tripDb.HasFileset("test")
+	if err != nil || exists {
+		t.Fatalf("the fileset was created: %v", err)
+	}
+}
+
+func TestSignSetLogsConfirmationOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signature takes seconds")
+	}
+	tripDb := dbtest.Open(t, true)
+	dir := t.TempDir()
+	err := AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	logger, out := newTestLogger()
+	result, err := SignSet("test", "secret", false, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := tripDb.FilesetSignature("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged := out.String()
+	if strings.Count(logged, "\n") != 1 || !strings.HasPrefix(logged, "fileset \"test\" signed") {
+		t.Errorf("sign logged %q, want a single confirmation line", logged)
+	}
+	// Neither the hash nor the signature end up in the output.
+	if strings.Contains(logged, result.Fingerprint) || strings.Contains(logged, hex.EncodeToString(signature)) {
+		t.Errorf("sign logged the hash or the signature: %q", logged)
+	}
+}
+
+func TestDeleteFilesStrict(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	logger, out := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "a/x", "a/y", "ab/z")
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A directory deletes the records below it, not the records of a sibling with a longer name.
+	err = DeleteFiles([]string{filepath.Join(dir, "a")}, "test", true, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged := out.String(); logged != fmt.Sprintf("3 records deleted for %s\n", filepath.Join(dir, "a")) {
+		t.Errorf("delete logged %q", logged)
+	}
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{".", "ab", "ab/z"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+
+	// A path without records is an error in strict mode, it is only logged otherwise.
+	missing := filepath.Join(dir, "missing")
+	err = DeleteFiles([]string{missing}, "test", true, logger, tripDb)
+	if err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("strict delete of a missing path: got error %v, want an error naming the path", err)
+	}
+	out.Reset()
+	err = DeleteFiles([]string{missing}, "test", false, logger, tripDb)
+	if err != nil {
+		t.Errorf("delete of a missing path: %v", err)
+	}
+	if logged := out.String(); logged != fmt.Sprintf("0 records deleted for %s\n", missing) {
+		t.Errorf("delete logged %q", logged)
+	}
+}
+
+func TestFilesetStyle(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	opts := &AddOptions{FileChecks: "size", DirChecks: "modtime"}
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The style is taken from the fileset meta, a fileset keeps the style it was created with.
+	err = AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "modtime", HomeRelative: true}, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/170)") {
+		t.Errorf("home relative add: got error %v, want the path style error", err)
+	}
+	err = AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "modtime", Resolve: true}, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/240)") {
+		t.Errorf("resolved add: got error %v, want the resolve style error", err)
+	}
+	err = AddFiles([]string{filepath.Join(dir, "x")}, "test", opts, tripDb)
+	if err != nil {
+		t.Errorf("add in the same style: %v", err)
+	}
+}
+
+func TestRemovedSinceSignaturePathBoundary(t *testing.T) {
+	v := &verifier{fileset: "test", opts: &VerifyOptions{}, report: &VerifyReport{},
+		removed: []string{"/home/u/doc", "/home/u/doc/a", "/home/u/documents-backup/b"}}
+	err := v.verifyEntries("/home/u/doc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	for _, result := range v.report.Sections[0].Results {
+		removed = append(removed, result.Path)
+	}
+	if strings.Join(removed, ",") != "/home/u/doc,/home/u/doc/a" {
+		t.Errorf("removed records %v, want the records below /home/u/doc", removed)
+	}
+}
+
+func TestInterruptPerRun(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+
+	// The interrupt of one run does not stop another one.
+	interrupted := &AddOptions{FileChecks: "size", DirChecks: "modtime", ReadOptions: ReadOptions{Interrupt: &Interrupt{}}}
+	interrupted.Interrupt.Set()
+	err := AddFiles([]string{dir}, "interrupted", interrupted, tripDb)
+	if !errors.Is(err, ErrInterrupted) {
+		t.Errorf("interrupted add: got error %v, want ErrInterrupted", err)
+	}
+	running := &AddOptions{FileChecks: "size", DirChecks: "modtime", ReadOptions: ReadOptions{Interrupt: &Interrupt{}}}
+	err = AddFiles([]string{dir}, "running", running, tripDb)
+	if err != nil {
+		t.Errorf("add: %v", err)
+	}
+}
+
+func TestQueryRecordsStyle(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("no home directory")
+	}
+	rec := &db.TriplineRecord{Type: "regular", Checks: []string{}, Data: map[string]interface{}{}}
+	err = tripDb.SaveFilesetMeta("home", &db.FilesetMeta{HomeRelative: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.AddTriplineRecord("~/x", rec, "home", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A fileset with absolute paths is not searched in the home relative form.
+	err = tripDb.AddTriplineRecord("~/x", rec, "absolute", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fqn := filepath.Join(home, "x")
+	entries, err := queryRecords("home", fqn, tripDb)
+	if err != nil || len(entries) != 1 || entries[0].Path != "~/x" {
+		t.Errorf("home relative query of %s: got %v, %v", fqn, entries, err)
+	}
+	entries, err = queryRecords("absolute", fqn, tripDb)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("absolute query of %s: got %v, %v", fqn, entries, err)
+	}
+	entries, err = queryRecords("home", filepath.Dir(home), tripDb)
+	if err != nil || len(entries) != 0 {
+		t.Errorf("home relative query outside the home directory: got %v, %v", entries, err)
+	}
+}
@@ -0,0 +1,99 @@
+package proc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// The metrics of the Prometheus rendering, in the order they are written.
+var prometheusMetrics = []struct {
+	name string
+	help string
+}{
+	{"tripline_entries", "Number of verified records."},
+	{"tripline_failed_checks", "Number of failed checks."},
+	{"tripline_check_failures", "Number of failed checks per check."},
+	{"tripline_pending_checks", "Number of pending checks, see compute-pending."},
+	{"tripline_verify_success", "1 if the verification had no failed checks, 0 otherwise."},
+	{"tripline_last_verify_timestamp", "Time of the verification in seconds since the epoch."},
+}
+
+// The counts of a fileset in the report.
+type filesetMetrics struct {
+	entries  int
+	failures int
+	pending  int
+	// The failures per check, the checks that passed have a zero count.
+	checks map[string]int
+}
+
+// Render the report in the Prometheus text exposition format, e.g. for the textfile collector of the node exporter.
+// The metrics are labelled by fileset, the failures are also broken down per check.
+func (r *VerifyReport) WritePrometheus(w io.Writer, at time.Time) error {
+	filesets := make([]string, 0)
+	metrics := make(map[string]*filesetMetrics)
+	for _, section := range r.Sections {
+		m, ok := metrics[section.Fileset]
+		if !ok {
+			m = &filesetMetrics{checks: make(map[string]int)}
+			metrics[section.Fileset] = m
+			filesets = append(filesets, section.Fileset)
+		}
+		m.entries += section.Entries
+		for _, result := range section.Results {
+			switch result.Status {
+			case StatusFailed:
+				m.failures++
+				m.checks[result.Check]++
+			case StatusPending:
+				m.pending++
+			case StatusOk:
+				m.checks[result.Check] += 0
+			}
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, metric := range prometheusMetrics {
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s gauge\n", metric.name, metric.help, metric.name)
+		for _, fileset := range filesets {
+			m := metrics[fileset]
+			label := fmt.Sprintf("fileset=\"%s\"", escapeLabel(fileset))
+			switch metric.name {
+			case "tripline_entries":
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric.name, label, m.entries)
+			case "tripline_failed_checks":
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric.name, label, m.failures)
+			case "tripline_check_failures":
+				checks := make([]string, 0, len(m.checks))
+				for check := range m.checks {
+					checks = append(checks, check)
+				}
+				sort.Strings(checks)
+				for _, check := range checks {
+					fmt.Fprintf(bw, "%s{%s,check=\"%s\"} %d\n", metric.name, label, escapeLabel(check), m.checks[check])
+				}
+			case "tripline_pending_checks":
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric.name, label, m.pending)
+			case "tripline_verify_success":
+				success := 0
+				if m.failures == 0 {
+					success = 1
+				}
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric.name, label, success)
+			case "tripline_last_verify_timestamp":
+				fmt.Fprintf(bw, "%s{%s} %d\n", metric.name, label, at.Unix())
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Escape a label value, the backslashes, double quotes and line feeds.
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,116 @@
+package proc
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	err300 = "(proc/300) quarantine %q:%w"
+)
+
+const (
+	msg300 = "quarantined %s as %s"
+	msg310 = "%d files quarantined"
+)
+
+// Name of the manifest in the quarantine directory, a json object per quarantined file.
+const quarantineManifest = "manifest.jsonl"
+
+// Manifest entry of a quarantined file.
+type quarantineEntry struct {
+	Path     string `json:"path"`
+	Check    string `json:"check"`
+	Expected string `json:"expected"`
+	Actual   string `json:"actual"`
+	File     string `json:"file"`
+	Time     string `json:"time"`
+}
+
+// Verify the fileset and copy the files that fail a content hash check to the quarantine directory, to preserve
+// the evidence at detection time. The copies are named by the hash of their path and the time, the manifest
+// records the expected and actual hashes. Returns the verification report.
+func Quarantine(fileset string, out string, opts *VerifyOptions, tripDb *db.TriplineDb) (*VerifyReport, error) {
+	report, err := VerifyFiles(nil, fileset, opts, tripDb)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(out, 0700)
+	if err != nil {
+		return nil, fmt.Errorf(err300, out, err)
+	}
+	manifest, err := os.OpenFile(filepath.Join(out, quarantineManifest), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf(err300, out, err)
+	}
+	defer manifest.Close()
+
+	ts := time.Now().UTC()
+	quarantined := 0
+	for _, section := range report.Sections {
+		for _, result := range section.Results {
+			if result.Status != StatusFailed || len(result.Actual) == 0 {
+				continue
+			}
+			if _, isHasher := fileChecks[result.Check].(contentHasher); !isHasher {
+				continue
+			}
+			path, err := expandHome(result.Path)
+			if err != nil {
+				return nil, err
+			}
+			path = translatePath(path, opts.PathMaps)
+
+			name := fmt.Sprintf("%x-%s", sha256.Sum256([]byte(path)), ts.Format("20060102T150405Z"))
+			err = copyFile(path, filepath.Join(out, name))
+			if err != nil {
+				return nil, fmt.Errorf(err300, path, err)
+			}
+			jsn, err := json.Marshal(&quarantineEntry{
+				Path:     result.Path,
+				Check:    result.Check,
+				Expected: result.Expected,
+				Actual:   result.Actual,
+				File:     name,
+				Time:     ts.Format(time.RFC3339),
+			})
+			if err != nil {
+				return nil, fmt.Errorf(err300, path, err)
+			}
+			_, err = manifest.Write(append(jsn, '\n'))
+			if err != nil {
+				return nil, fmt.Errorf(err300, out, err)
+			}
+			opts.logger().Printf(msg300, result.Path, name)
+			quarantined++
+		}
+	}
+	opts.logger().Printf(msg310, quarantined)
+	return report, manifest.Close()
+}
+
+// Copy the contents of a file, the copy is only readable by the user.
+func copyFile(src string, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,81 @@
+package proc
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/db/dbtest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestQuarantine(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+	writeTestFile(t, dir, "bad", "original")
+	writeTestFile(t, dir, "good", "original")
+	opts := &AddOptions{Recursive: true, FileChecks: "sha256,size", DirChecks: "child"}
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Same size, only the content hash fails.
+	writeTestFile(t, dir, "bad", "tampered")
+
+	out := filepath.Join(t.TempDir(), "quarantine")
+	report, err := Quarantine("test", out, &VerifyOptions{ReadOptions: ReadOptions{Log: logger}}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failures() != 1 {
+		t.Errorf("quarantine reported %d failures, want the hash of the corrupted file", report.Failures())
+	}
+
+	// Only the corrupted file is copied, the manifest has the hashes of the recorded and the current contents.
+	f, err := os.Open(filepath.Join(out, quarantineManifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	entries := make([]quarantineEntry, 0)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry quarantineEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry)
+	}
+	bad := filepath.Join(dir, "bad")
+	if len(entries) != 1 || entries[0].Path != bad || entries[0].Check != "sha256" {
+		t.Fatalf("manifest %+v, want the sha256 of the corrupted file", entries)
+	}
+	if entries[0].Expected != fmt.Sprintf("%x", sha256.Sum256([]byte("original"))) ||
+		entries[0].Actual != fmt.Sprintf("%x", sha256.Sum256([]byte("tampered"))) {
+		t.Errorf("manifest expected %q actual %q, want the hashes of the contents", entries[0].Expected, entries[0].Actual)
+	}
+	files, err := ioutil.ReadDir(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("quarantine holds %d files, want the manifest and the corrupted file", len(files))
+	}
+	copied := filepath.Join(out, entries[0].File)
+	fi, err := os.Stat(copied)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("copy has mode %v, want 0600", fi.Mode().Perm())
+	}
+	content, err := ioutil.ReadFile(copied)
+	if err != nil || string(content) != "tampered" {
+		t.Errorf("copy holds %q (%v), want the current contents", content, err)
+	}
+}
@@ -0,0 +1,152 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	err490 = "(proc/490) query %q of fileset %q:%w"
+	err495 = "(proc/495) invalid glob %q:%w"
+)
+
+const (
+	msg485 = "query %q %s"
+)
+
+// Save a named query of the fileset, see VerifyOptions.Query. The file names are the path prefixes of the query,
+// they are resolved in the same way as the file names of a verification. The globs are validated.
+func SaveQuery(fileset string, name string, fileNames []string, query *db.SavedQuery, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return fmt.Errorf(err490, name, fileset, err)
+	}
+	query.Prefixes = nil
+	for _, fn := range fileNames {
+		fqn, err := absPath(fn, meta.Resolve)
+		if err != nil {
+			return fmt.Errorf(err040, fn, err)
+		}
+		query.Prefixes = append(query.Prefixes, fqn)
+	}
+	err = checkGlobs(append(append([]string{}, query.Include...), query.Exclude...))
+	if err != nil {
+		return err
+	}
+	err = tripDb.SaveQuery(fileset, name, query)
+	if err != nil {
+		return fmt.Errorf(err490, name, fileset, err)
+	}
+	loggerOrStd(logger).Printf(msg485, name, "saved")
+	return nil
+}
+
+// Print the saved queries of the fileset, one per line.
+func ListQueries(fileset string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	names, err := tripDb.ListQueries(fileset)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		query, err := tripDb.GetQuery(fileset, name)
+		if err != nil {
+			return err
+		}
+		line := name
+		if len(query.Prefixes) > 0 {
+			line += " " + strings.Join(query.Prefixes, " ")
+		}
+		if len(query.Include) > 0 {
+			line += " include=" + strings.Join(query.Include, ",")
+		}
+		if len(query.Exclude) > 0 {
+			line += " exclude=" + strings.Join(query.Exclude, ",")
+		}
+		if len(query.Checks) > 0 {
+			line += " checks=" + strings.Join(query.Checks, ",")
+		}
+		logger.Printf(msg090, line)
+	}
+	return nil
+}
+
+// Delete a saved query of the fileset.
+func DeleteQuery(fileset string, name string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	err := tripDb.DeleteQuery(fileset, name)
+	if err != nil {
+		return err
+	}
+	loggerOrStd(logger).Printf(msg485, name, "deleted")
+	return nil
+}
+
+// Check if the recorded path is selected by the globs of the query. A glob without a separator matches the base name,
+// e.g. *.conf, the other globs match the complete path.
+func matchesQuery(p string, query *db.SavedQuery) bool {
+	if query == nil {
+		return true
+	}
+	if len(query.Include) > 0 && !matchesAnyGlob(p, query.Include) {
+		return false
+	}
+	return !matchesAnyGlob(p, query.Exclude)
+}
+
+// A glob without a separator matches the base name of a path, a glob with separators matches the complete path.
+func matchesAnyGlob(p string, globs []string) bool {
+	return len(matchingGlob(p, globs)) > 0
+}
+
+// The first glob that matches the path, empty if none matches.
+func matchingGlob(p string, globs []string) string {
+	for _, glob := range globs {
+		name := p
+		if !strings.ContainsAny(glob, `/\`) {
+			name = filepath.Base(p)
+		}
+		// The patterns were validated before they were used, see checkGlobs.
+		if ok, _ := filepath.Match(glob, name); ok {
+			return glob
+		}
+	}
+	return ""
+}
+
+// Validate the syntax of the globs.
+func checkGlobs(globs []string) error {
+	for _, glob := range globs {
+		if _, err := filepath.Match(glob, ""); err != nil {
+			return fmt.Errorf(err495, glob, err)
+		}
+	}
+	return nil
+}
+
+// Check if the query runs the check, a query without checks runs all the recorded checks.
+func queryRunsCheck(query *db.SavedQuery, checkName string) bool {
+	if query == nil || len(query.Checks) == 0 {
+		return true
+	}
+	for _, c := range query.Checks {
+		if c == checkName {
+			return true
+		}
+	}
+	return false
+}
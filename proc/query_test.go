@@ -0,0 +1,175 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSaveQuery(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "etc/app.conf", "etc/app.log")
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file names become the prefixes of the query, a query with the same name is replaced.
+	etc := filepath.Join(dir, "etc")
+	err = SaveQuery("test", "conf", []string{etc}, &db.SavedQuery{Include: []string{"*.log"}}, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveQuery("test", "conf", []string{etc}, &db.SavedQuery{Include: []string{"*.conf"}, Checks: []string{"size"}}, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query, err := tripDb.GetQuery("test", "conf")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &db.SavedQuery{Prefixes: []string{etc}, Include: []string{"*.conf"}, Checks: []string{"size"}}
+	if !reflect.DeepEqual(query, want) {
+		t.Errorf("saved query %+v, want %+v", query, want)
+	}
+	err = SaveQuery("test", "bad", nil, &db.SavedQuery{Exclude: []string{"["}}, logger, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/495)") {
+		t.Errorf("save with an invalid glob: got error %v, want the glob error", err)
+	}
+
+	err = DeleteQuery("test", "conf", logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tripDb.GetQuery("test", "conf")
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/420)") {
+		t.Errorf("get a deleted query: got error %v, want the unknown query error", err)
+	}
+	err = DeleteQuery("test", "conf", logger, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(db/420)") {
+		t.Errorf("delete a deleted query: got error %v, want the unknown query error", err)
+	}
+}
+
+func TestMatchesQuery(t *testing.T) {
+	query := &db.SavedQuery{Include: []string{"*.conf", "/srv/*/app.log"}, Exclude: []string{"local.conf"}}
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/etc/app.conf", true},
+		{"/etc/sub/app.conf", true},
+		{"/etc/local.conf", false},
+		{"/srv/web/app.log", true},
+		{"/srv/web/sub/app.log", false},
+		{"/etc/app.log", false},
+	}
+	for _, test := range tests {
+		if got := matchesQuery(test.path, query); got != test.want {
+			t.Errorf("matchesQuery(%q) = %v, want %v", test.path, got, test.want)
+		}
+	}
+	if !matchesQuery("/etc/app.log", nil) || !matchesQuery("/etc/app.log", &db.SavedQuery{}) {
+		t.Error("a query without globs selects all the paths")
+	}
+}
+
+func TestQueryRunsCheck(t *testing.T) {
+	query := &db.SavedQuery{Checks: []string{"size", "sha256"}}
+	if !queryRunsCheck(query, "sha256") || queryRunsCheck(query, "modtime") {
+		t.Errorf("query with checks %v", query.Checks)
+	}
+	if !queryRunsCheck(nil, "modtime") || !queryRunsCheck(&db.SavedQuery{}, "modtime") {
+		t.Error("a query without checks runs all the checks")
+	}
+}
+
+func TestQuerySignature(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signature takes seconds")
+	}
+	srcDb := openKeyedDb(t, "")
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x.conf", "y.log")
+	export := exportSignedSet(t, srcDb, dir, &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime"})
+
+	// A query saved after the signing narrows the verification, it breaks the signature until the fileset is re-signed.
+	err := SaveQuery("test", "conf", nil, &db.SavedQuery{Include: []string{"*.conf"}}, logger, srcDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySetSignature("test", "secret", srcDb)
+	if err == nil {
+		t.Error("signature verified after a query was saved")
+	}
+	_, err = SignSet("test", "secret", true, logger, srcDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = SaveQuery("test", "conf", nil, &db.SavedQuery{Include: []string{"*"}}, logger, srcDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySetSignature("test", "secret", srcDb)
+	if err == nil {
+		t.Error("signature verified after a query was changed")
+	}
+	err = SaveQuery("test", "conf", nil, &db.SavedQuery{Include: []string{"*.conf"}}, logger, srcDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySetSignature("test", "secret", srcDb)
+	if err != nil {
+		t.Errorf("signature after the signed query was restored: %v", err)
+	}
+
+	// The export carries the queries, the signature of the import remains valid.
+	export.Reset()
+	err = ExportSet("test", export, srcDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tripDb := openKeyedDb(t, "")
+	err = ImportSet("test", export, false, logger, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := srcDb.FilesetSignature("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tripDb.StoreFilesetSignature("test", signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySetSignature("test", "secret", tripDb)
+	if err != nil {
+		t.Errorf("signature of the import: %v", err)
+	}
+	if _, err = tripDb.GetQuery("test", "conf"); err != nil {
+		t.Errorf("query of the import: %v", err)
+	}
+}
+
+// The exports of the same fileset compare equal, the export without queries equals one whose queries were deleted.
+func TestExportMetaQueries(t *testing.T) {
+	meta := &db.FilesetMeta{Queries: map[string]*db.SavedQuery{}}
+	if !newExportMeta(meta).isDefault() {
+		t.Error("settings without queries are not the default")
+	}
+	meta.Queries["conf"] = &db.SavedQuery{Include: []string{"*.conf"}}
+	other := &db.FilesetMeta{Queries: map[string]*db.SavedQuery{"conf": {Include: []string{"*.conf"}}}}
+	if !newExportMeta(meta).equal(newExportMeta(other)) {
+		t.Error("settings with the same queries differ")
+	}
+	other.Queries["conf"].Include = []string{"*.log"}
+	if newExportMeta(meta).equal(newExportMeta(other)) {
+		t.Error("settings with other queries are equal")
+	}
+}
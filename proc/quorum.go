@@ -0,0 +1,103 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"sort"
+)
+
+const (
+	err420 = "(proc/420) quorum %d invalid for %d baselines"
+	err430 = "(proc/430) quorum verify fileset %q:%w"
+)
+
+// The content check the baselines vote on.
+const quorumHashCheck = "sha256"
+
+// Verify the file contents against several independent baselines of the same fileset, e.g. signed copies on
+// different media. For each path the recorded sha256 hashes are counted and the file must match the hash that at
+// least quorum baselines agree on. A single tampered baseline can not make a modified file pass, nor make a
+// good file fail. Paths without a quorum fail the quorum check, paths where the baselines disagree while there is
+// a quorum are reported with a warning. The records without a sha256 are skipped.
+// The caller begins a read transaction on each of the baselines.
+func VerifyQuorum(fileset string, baselines []*db.TriplineDb, quorum int, opts *VerifyOptions) (*VerifyReport, error) {
+	for _, baseline := range baselines {
+		if err := checkFileset(fileset, baseline); err != nil {
+			return nil, err
+		}
+	}
+	if quorum < 1 || quorum > len(baselines) {
+		return nil, fmt.Errorf(err420, quorum, len(baselines))
+	}
+
+	// The recorded hashes of each path, one vote per baseline.
+	votes := make(map[string]map[string]int)
+	for _, baseline := range baselines {
+		entries, err := baseline.ListTriplineRecords(fileset)
+		if err != nil {
+			return nil, fmt.Errorf(err430, fileset, err)
+		}
+		for _, entry := range entries {
+			hash, ok := entry.Record.Data[quorumHashCheck].(string)
+			if !ok || entry.Record.IsDir {
+				continue
+			}
+			if votes[entry.Path] == nil {
+				votes[entry.Path] = make(map[string]int)
+			}
+			votes[entry.Path][hash]++
+		}
+	}
+	paths := make([]string, 0, len(votes))
+	for p := range votes {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+
+	report := &VerifyReport{}
+	section := report.newSection(fileset, "", len(paths))
+	v := &verifier{fileset: fileset, opts: opts, report: report}
+	for i, p := range paths {
+		if err := opts.interrupted(); err != nil {
+			return nil, err
+		}
+		opts.Events.emit(&Event{Type: EventProgress, Done: i, Total: len(paths)})
+
+		expected, agree := majority(votes[p])
+		if agree < quorum {
+			v.add(section, p, quorumCheck, fmt.Errorf("%d of %d baselines agree, quorum %d", agree, len(baselines), quorum))
+			continue
+		}
+		if agree < len(baselines) {
+			v.add(section, p, disagreeCheck, fmt.Errorf("%d of %d baselines agree", agree, len(baselines)))
+		}
+
+		path, err := expandHome(p)
+		if err != nil {
+			return nil, fmt.Errorf(err430, fileset, err)
+		}
+		path = translatePath(path, opts.PathMaps)
+		if _, err := opts.stat(path); err != nil {
+			v.add(section, p, basicCheck, &missingError{})
+			continue
+		}
+		digests, err := hashChecks(path, []string{quorumHashCheck}, fileChecks, &opts.ReadOptions)
+		if err == nil {
+			err = compareDigest(expected, digests[quorumHashCheck])
+		}
+		v.add(section, p, quorumHashCheck, err)
+	}
+	opts.Events.emit(&Event{Type: EventProgress, Done: len(paths), Total: len(paths)})
+	return report, nil
+}
+
+// The value with the most votes and its number of votes. Ties are broken by the value so the outcome is stable.
+func majority(votes map[string]int) (string, int) {
+	best, most := "", 0
+	for value, count := range votes {
+		if count > most || (count == most && value < best) {
+			best, most = value, count
+		}
+	}
+	return best, most
+}
@@ -0,0 +1,88 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The status of the check of the path in the report, empty if the check was not reported.
+func resultStatus(report *VerifyReport, path string, check string) string {
+	for _, section := range report.Sections {
+		for _, result := range section.Results {
+			if result.Path == path && result.Check == check {
+				return result.Status
+			}
+		}
+	}
+	return ""
+}
+
+func TestVerifyQuorum(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.conf")
+	opts := &AddOptions{FileChecks: "sha256", DirChecks: "modtime"}
+	// Two baselines record the original contents, the third one was tampered to accept the modified contents.
+	baselines := []*db.TriplineDb{dbtest.Open(t, true), dbtest.Open(t, true), dbtest.Open(t, true)}
+	writeTestFile(t, dir, "app.conf", "original")
+	for _, baseline := range baselines[:2] {
+		if err := AddFiles([]string{file}, "test", opts, baseline); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, dir, "app.conf", "modified")
+	if err := AddFiles([]string{file}, "test", opts, baselines[2]); err != nil {
+		t.Fatal(err)
+	}
+	logger, _ := newTestLogger()
+	verifyOpts := &VerifyOptions{ReadOptions: ReadOptions{Log: logger}}
+
+	tests := []struct {
+		name      string
+		contents  string
+		baselines []*db.TriplineDb
+		quorum    int
+		// The status of the sha256, disagree and quorum checks, empty if not reported.
+		sha256, disagree, noQuorum string
+	}{
+		{"agree", "original", baselines[:2], 2, StatusOk, "", ""},
+		{"agree modified", "modified", baselines[:2], 2, StatusFailed, "", ""},
+		{"disagree", "original", baselines, 2, StatusOk, StatusFailed, ""},
+		{"disagree tampered", "modified", baselines, 2, StatusFailed, StatusFailed, ""},
+		{"no quorum", "original", baselines, 3, "", "", StatusFailed},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			writeTestFile(t, dir, "app.conf", test.contents)
+			report, err := VerifyQuorum("test", test.baselines, test.quorum, verifyOpts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := resultStatus(report, file, "sha256"); got != test.sha256 {
+				t.Errorf("sha256 %q, want %q", got, test.sha256)
+			}
+			if got := resultStatus(report, file, disagreeCheck); got != test.disagree {
+				t.Errorf("disagree %q, want %q", got, test.disagree)
+			}
+			if got := resultStatus(report, file, quorumCheck); got != test.noQuorum {
+				t.Errorf("quorum %q, want %q", got, test.noQuorum)
+			}
+		})
+	}
+
+	// The disagreement is a warning, the quorum decides.
+	writeTestFile(t, dir, "app.conf", "original")
+	report, err := VerifyQuorum("test", baselines, 2, verifyOpts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.FailuresAtOrAbove(SeverityCritical) != 0 {
+		t.Errorf("%d critical failures, want the disagreement only", report.FailuresAtOrAbove(SeverityCritical))
+	}
+	_, err = VerifyQuorum("test", baselines, 4, verifyOpts)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/420)") {
+		t.Errorf("quorum larger than the baselines: got error %v, want the quorum error", err)
+	}
+}
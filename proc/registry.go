@@ -0,0 +1,38 @@
+package proc
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	err950 = "(proc/950) invalid check name %q"
+	err960 = "(proc/960) check %q already registered"
+)
+
+// Register an external file check under the name, the name can then be used in the file check lists.
+// The name may not collide with the built-in checks or the checks that were registered before.
+// The registration is not synchronized, register the checks before calling the other functions of the package.
+func RegisterFileCheck(name string, checker FileChecker) error {
+	return registerCheck(fileChecks, name, checker)
+}
+
+// Register an external directory check under the name, the name can then be used in the directory check lists.
+// The same rules apply as for the file checks.
+func RegisterDirCheck(name string, checker FileChecker) error {
+	return registerCheck(dirChecks, name, checker)
+}
+
+func registerCheck(checks map[string]FileChecker, name string, checker FileChecker) error {
+	// The check lists are comma separated and converted to lower case.
+	if len(name) == 0 || name != strings.ToLower(strings.TrimSpace(name)) || strings.Contains(name, ",") || checker == nil {
+		return fmt.Errorf(err950, name)
+	}
+	// The basic, policy, closed world and quorum checks are reported by the verification itself.
+	if _, found := checks[name]; found || name == basicCheck || name == policyCheck || name == closedWorldCheck ||
+		name == quorumCheck || name == disagreeCheck {
+		return fmt.Errorf(err960, name)
+	}
+	checks[name] = checker
+	return nil
+}
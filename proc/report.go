@@ -0,0 +1,405 @@
+package proc
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	err800 = "(proc/800) unknown severity %q, expected warning or critical"
+)
+
+// Status of a check result.
+const (
+	StatusOk     = "ok"
+	StatusFailed = "failed"
+	// The check was deferred when the file was added, it cannot be verified yet.
+	StatusPending = "pending"
+	// The recorded check is not available on this platform, it is skipped.
+	StatusUnavailable = "unavailable"
+	// The recorded data cannot be compared, e.g. the contents of a file larger than the content limit.
+	StatusNotCompared = "not-compared"
+)
+
+// Severity of a failed check.
+const (
+	SeverityWarning  = "warning"
+	SeverityCritical = "critical"
+)
+
+// Ordering of the severities, a higher level is more severe.
+var severityLevels = map[string]int{
+	SeverityWarning:  1,
+	SeverityCritical: 2,
+}
+
+// Checks that fail with a warning, the timestamps and the case of names change without the contents being
+// tampered with. All other checks are critical.
+var checkSeverities = map[string]string{
+	"modtime":  SeverityWarning,
+	"casename": SeverityWarning,
+	// The baselines disagree but there is a quorum.
+	disagreeCheck: SeverityWarning,
+}
+
+// Severity of a failure of the check.
+func checkSeverity(check string) string {
+	if severity, found := checkSeverities[check]; found {
+		return severity
+	}
+	return SeverityCritical
+}
+
+// Verify that the severity is known.
+func ParseSeverity(severity string) (string, error) {
+	if _, found := severityLevels[severity]; !found {
+		return "", fmt.Errorf(err800, severity)
+	}
+	return severity, nil
+}
+
+// Name of the built-in checks that are always executed, the existence and type of the file.
+const basicCheck = "basic"
+
+// Name of the check that reports the records that lack a required check.
+const policyCheck = "policy"
+
+// Name of the check that reports the unrecorded files below the recorded directories.
+const closedWorldCheck = "closedworld"
+
+// Names of the checks that report the baselines without a quorum and the baselines that disagree.
+const (
+	quorumCheck   = "quorum"
+	disagreeCheck = "disagree"
+)
+
+// Name of the check that records the inode number, it classifies the content changes.
+const inodeCheck = "inode"
+
+// Name of the check that reports a content change without a change of the modification time, see timestomped.
+const tamperCheck = "tamper"
+
+// Classification of a failed content check, only set when the inode is recorded as well.
+const (
+	// The inode changed, the file was replaced, e.g. by renaming another file over it.
+	ChangeReplaced = "replaced"
+	// The inode is the same, the file was modified in place.
+	ChangeModified = "modified"
+)
+
+// Descriptions of the content change classifications in the text report.
+var changeDescriptions = map[string]string{
+	ChangeReplaced: "replaced, inode changed",
+	ChangeModified: "modified in place, same inode",
+}
+
+// CheckResult is the outcome of a single check on a single path.
+type CheckResult struct {
+	Path   string `json:"path"`
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	// Only set for failed checks.
+	Severity string `json:"severity,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	// The kind of the recorded path that is missing, "file" or "dir". Empty if the path exists.
+	Missing string `json:"missing,omitempty"`
+	// The recorded and the actual value of a check that compares values, e.g. the content hashes.
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+	// How a file with a failed content check changed, "replaced" or "modified". Empty if the inode is not recorded.
+	Change string `json:"change,omitempty"`
+	// The changed lines of a failed content check of a file with inline contents, see AddOptions.InlineUnder.
+	Diff []string `json:"diff,omitempty"`
+}
+
+// Reported by the checks when the recorded value differs from the actual one.
+type mismatchError struct {
+	expected string
+	actual   string
+}
+
+func (e *mismatchError) Error() string {
+	return fmt.Sprintf("expected %s actual %s", e.expected, e.actual)
+}
+
+// Reported by the basic check when a recorded file or directory no longer exists.
+type missingError struct {
+	isDir bool
+}
+
+func (e *missingError) kind() string {
+	if e.isDir {
+		return "dir"
+	}
+	return "file"
+}
+
+func (e *missingError) Error() string {
+	return e.kind() + " not found"
+}
+
+// A deferred check, it is reported as a warning and does not count as a failure.
+type pendingError struct{}
+
+func (e *pendingError) Error() string {
+	return "pending, run compute-pending"
+}
+
+// A check that has nothing to compare, it is reported as a warning and does not count as a failure.
+type notComparedError struct {
+	reason string
+}
+
+func (e *notComparedError) Error() string {
+	return e.reason
+}
+
+// VerifySection groups the results of verifying a path prefix, or the complete fileset if the prefix is empty.
+type VerifySection struct {
+	Fileset string        `json:"fileset"`
+	Prefix  string        `json:"prefix,omitempty"`
+	Entries int           `json:"entries"`
+	Results []CheckResult `json:"results"`
+}
+
+// VerifyReport collects the results of a verification so they can be rendered in one go after the verification.
+// The verification itself does not produce output.
+type VerifyReport struct {
+	Sections []*VerifySection `json:"sections"`
+	// Time of the last update of the fileset, empty if unknown.
+	UpdatedAt string `json:"updatedAt,omitempty"`
+}
+
+// Start a new section in the report.
+func (r *VerifyReport) newSection(fileset string, prefix string, entries int) *VerifySection {
+	section := &VerifySection{Fileset: fileset, Prefix: prefix, Entries: entries, Results: make([]CheckResult, 0)}
+	r.Sections = append(r.Sections, section)
+	return section
+}
+
+// Add a result to the section.
+func (s *VerifySection) add(path string, check string, err error) {
+	result := CheckResult{Path: path, Check: check, Status: StatusOk}
+	var pending *pendingError
+	var unavailable *unavailableError
+	var notCompared *notComparedError
+	if errors.As(err, &pending) {
+		result.Status = StatusPending
+		result.Severity = SeverityWarning
+		result.Detail = err.Error()
+	} else if errors.As(err, &notCompared) {
+		result.Status = StatusNotCompared
+		result.Severity = SeverityWarning
+		result.Detail = err.Error()
+	} else if errors.As(err, &unavailable) {
+		result.Status = StatusUnavailable
+		result.Severity = SeverityWarning
+		result.Detail = err.Error()
+	} else if err != nil {
+		result.Status = StatusFailed
+		result.Severity = checkSeverity(check)
+		result.Detail = err.Error()
+		var missing *missingError
+		if errors.As(err, &missing) {
+			result.Missing = missing.kind()
+		}
+		var mismatch *mismatchError
+		if errors.As(err, &mismatch) {
+			result.Expected = mismatch.expected
+			result.Actual = mismatch.actual
+		}
+	}
+	s.Results = append(s.Results, result)
+}
+
+// Count the failed checks in the report.
+func (r *VerifyReport) Failures() int {
+	fails := 0
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status == StatusFailed {
+				fails++
+			}
+		}
+	}
+	return fails
+}
+
+// Count the pending checks in the report.
+func (r *VerifyReport) Pending() int {
+	pending := 0
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status == StatusPending {
+				pending++
+			}
+		}
+	}
+	return pending
+}
+
+// Count the checks in the report that had nothing to compare.
+func (r *VerifyReport) NotCompared() int {
+	notCompared := 0
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status == StatusNotCompared {
+				notCompared++
+			}
+		}
+	}
+	return notCompared
+}
+
+// Count the skipped checks that are not available on this platform, per check.
+func (r *VerifyReport) Unavailable() map[string]int {
+	counts := make(map[string]int)
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status == StatusUnavailable {
+				counts[result.Check]++
+			}
+		}
+	}
+	return counts
+}
+
+// Count the failed checks in the report with the severity or a higher one.
+func (r *VerifyReport) FailuresAtOrAbove(severity string) int {
+	fails := 0
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status == StatusFailed && severityLevels[result.Severity] >= severityLevels[severity] {
+				fails++
+			}
+		}
+	}
+	return fails
+}
+
+// Render the report as text, the number of entries of each section followed by the failed checks.
+// The output is buffered and written in a single flush so it cannot interleave with other output.
+func (r *VerifyReport) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	// A report of several filesets names the fileset of the sections.
+	multi := false
+	for _, section := range r.Sections {
+		multi = multi || section.Fileset != r.Sections[0].Fileset
+	}
+	for i, section := range r.Sections {
+		if multi && (i == 0 || section.Fileset != r.Sections[i-1].Fileset) {
+			fmt.Fprintf(bw, msg087+"\n", section.Fileset)
+		}
+		// Report nr. of matching entries in case the user provided wrong input
+		// The user can see that the input is used as a prefix which sometimes happens with options that are not
+		// spelled correctly.
+		if len(section.Prefix) > 0 {
+			fmt.Fprintf(bw, msg080+"\n", section.Entries, section.Prefix)
+		} else {
+			fmt.Fprintf(bw, msg085+"\n", section.Entries)
+		}
+		for _, result := range section.Results {
+			if result.Status == StatusUnavailable {
+				// Summarized by the caller, a cross-platform verification would list every record.
+				continue
+			}
+			if result.Status != StatusOk && len(result.Change) > 0 {
+				fmt.Fprintf(bw, msg040+" (%s)\n", result.Path, result.Check, result.Detail, changeDescriptions[result.Change])
+			} else if result.Status != StatusOk {
+				fmt.Fprintf(bw, msg040+"\n", result.Path, result.Check, result.Detail)
+			}
+			for _, line := range result.Diff {
+				fmt.Fprintf(bw, "    %s\n", line)
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// The columns of the csv rendering, the column set is stable so the ingestion does not break on upgrades.
+var csvHeader = []string{"fileset", "path", "check", "status", "expected", "actual", "severity"}
+
+// Render the report as csv, a header row followed by a row per check result including the passed checks.
+func (r *VerifyReport) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			row := []string{section.Fileset, result.Path, result.Check, result.Status, result.Expected, result.Actual, result.Severity}
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Write the paths with failed checks to the writer, each path once, terminated by the separator.
+// The home relative paths are expanded, the paths can be passed to the add command to re-baseline them.
+func (r *VerifyReport) WriteFailedPaths(w io.Writer, separator byte) error {
+	bw := bufio.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, section := range r.Sections {
+		for _, result := range section.Results {
+			if result.Status != StatusFailed || seen[result.Path] {
+				continue
+			}
+			seen[result.Path] = true
+			path, err := expandHome(result.Path)
+			if err != nil {
+				return err
+			}
+			bw.WriteString(path)
+			bw.WriteByte(separator)
+		}
+	}
+	return bw.Flush()
+}
+
+// Check if the contents of a record changed while its modification time did not. Editing a file updates the
+// modification time, an attacker resets it to hide the change from the modtime check. Neither check reports it on
+// its own. Only a record with a content check and the modtime check qualifies, a content check that could not read
+// the file does not count as a change.
+func timestomped(results []CheckResult) bool {
+	contentChanged := false
+	modTimeOk := false
+	for _, result := range results {
+		if result.Check == "modtime" {
+			modTimeOk = result.Status == StatusOk
+		}
+		if contentChecks[result.Check] && result.Status == StatusFailed && len(result.Actual) > 0 {
+			contentChanged = true
+		}
+	}
+	return contentChanged && modTimeOk
+}
+
+// Classify the failed content checks of a record by the outcome of its inode check. A replaced file has a new inode,
+// a file that was modified in place keeps it. Nothing is classified if the inode is not recorded or not verified.
+func classifyContentChange(results []CheckResult) {
+	inodeStatus := ""
+	for _, result := range results {
+		if result.Check == inodeCheck {
+			inodeStatus = result.Status
+		}
+	}
+	change := ChangeModified
+	switch inodeStatus {
+	case StatusOk:
+	case StatusFailed:
+		change = ChangeReplaced
+	default:
+		return
+	}
+	for i := range results {
+		if results[i].Status == StatusFailed && contentChecks[results[i].Check] {
+			results[i].Change = change
+		}
+	}
+}
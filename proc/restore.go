@@ -0,0 +1,64 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"os"
+)
+
+const (
+	err390 = "(proc/390) restore fileset %q:%w"
+	err400 = "(proc/400) restore fileset %q, the signature did not validate, nothing restored:%w"
+)
+
+const (
+	msg390 = "signature %q validated, fileset %q restored"
+)
+
+// Replace a fileset by a trusted export, e.g. after the database was corrupted or tampered with.
+// The export is imported in place of the current records and verified against the detached signature. The error
+// signals that the caller should roll back the transaction, the current fileset is then left untouched.
+// After a successful restore the detached signature becomes the stored signature of the fileset.
+func Restore(fileset string, from string, sigFile string, password string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	signature, err := readDetachedSignature(fileset, sigFile, logger)
+	if err != nil {
+		return err
+	}
+
+	exists, err := tripDb.HasFileset(fileset)
+	if err != nil {
+		return fmt.Errorf(err390, fileset, err)
+	}
+	if exists {
+		err = tripDb.DeleteFileset(fileset)
+		if err != nil {
+			return fmt.Errorf(err390, fileset, err)
+		}
+	}
+
+	f, err := os.Open(from)
+	if err != nil {
+		return fmt.Errorf(err390, fileset, err)
+	}
+	defer f.Close()
+	err = ImportSet(fileset, f, false, logger, tripDb)
+	if err != nil {
+		return err
+	}
+
+	err = tripDb.VerifyFilesetSignatureWith(fileset, password, signature)
+	if err != nil {
+		return fmt.Errorf(err400, fileset, err)
+	}
+	err = tripDb.StoreFilesetSignature(fileset, signature)
+	if err != nil {
+		return fmt.Errorf(err390, fileset, err)
+	}
+	loggerOrStd(logger).Printf(msg390, sigFile, fileset)
+	return nil
+}
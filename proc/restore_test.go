@@ -0,0 +1,73 @@
+package proc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreSettings(t *testing.T) {
+	if testing.Short() {
+		t.Skip("the key derivation of the signature takes seconds")
+	}
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "a/x", "y")
+
+	tests := []struct {
+		name string
+		opts AddOptions
+	}{
+		{"resolve", AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime", Resolve: true}},
+		{"hash paths", AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime", HashPaths: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tripDb := openKeyedDb(t, "path key")
+			logger, _ := newTestLogger()
+			out := t.TempDir()
+			exportFile, sigFile := filepath.Join(out, "test.json"), filepath.Join(out, "test.sig")
+			err := AddFiles([]string{dir}, "test", &test.opts, tripDb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = ExportSignature("test", "secret", true, sigFile, logger, tripDb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			f, err := os.Create(exportFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = ExportSet("test", f, tripDb)
+			f.Close()
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := recordedPaths(t, tripDb, "test", dir)
+
+			// The fileset is tampered with, the restore brings back the records and the settings.
+			err = DeleteFiles([]string{filepath.Join(dir, "a")}, "test", true, logger, tripDb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Restore("test", exportFile, sigFile, "secret", logger, tripDb)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := recordedPaths(t, tripDb, "test", dir); !equalPaths(got, want) {
+				t.Errorf("restored %v, want %v", got, want)
+			}
+			err = VerifySetSignature("test", "secret", tripDb)
+			if err != nil {
+				t.Errorf("signature of the restored fileset: %v", err)
+			}
+
+			// A signature made with another password does not validate.
+			err = Restore("test", exportFile, sigFile, "other", logger, tripDb)
+			if err == nil || !strings.HasPrefix(err.Error(), "(proc/400)") {
+				t.Errorf("restore with the wrong password: got error %v, want the validation error", err)
+			}
+		})
+	}
+}
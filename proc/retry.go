@@ -0,0 +1,96 @@
+package proc
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	err985 = "(proc/985) retry count %d out of range 0..%d"
+	msg985 = "%s %q succeeded after %d retries"
+)
+
+// The maximum number of retries, the delay doubles on each retry.
+const maxRetries = 10
+
+// The delay before the first retry by default.
+const defaultRetryDelay = 100 * time.Millisecond
+
+// Check the number of retries of the stat and the open of the files after transient errors, e.g. the EIO and ESTALE
+// errors of a flaky network filesystem, see ReadOptions.
+func CheckRetries(count int) error {
+	if count < 0 || count > maxRetries {
+		return fmt.Errorf(err985, count, maxRetries)
+	}
+	return nil
+}
+
+// A transient error is classified by its errno, it might not occur on a second attempt.
+func transientError(err error) bool {
+	var errno syscall.Errno
+	if !errors.As(err, &errno) {
+		return false
+	}
+	switch errno {
+	case syscall.EIO, syscall.ESTALE, syscall.EAGAIN, syscall.EINTR, syscall.ETIMEDOUT:
+		return true
+	}
+	return false
+}
+
+// Run the operation on the file and repeat it while it fails with a transient error and retries remain. The delay
+// doubles after each retry, errors that are not transient, e.g. ENOENT, are never retried.
+// A successful retry is logged so the flakiness of the filesystem is visible.
+func (ro *ReadOptions) withRetry(op string, fqn string, fn func() error) error {
+	err := fn()
+	if ro == nil {
+		return err
+	}
+	delay := ro.RetryDelay
+	if delay <= 0 {
+		delay = defaultRetryDelay
+	}
+	for retry := 1; retry <= ro.Retries && transientError(err); retry++ {
+		time.Sleep(delay)
+		delay *= 2
+		err = fn()
+		if err == nil {
+			ro.logger().Printf(msg985, op, fqn, retry)
+		}
+	}
+	return err
+}
+
+// os.Stat with retries, see withRetry.
+func (ro *ReadOptions) stat(fqn string) (os.FileInfo, error) {
+	return ro.retryStat("stat", os.Stat, fqn)
+}
+
+// os.Lstat with retries, see withRetry.
+func (ro *ReadOptions) lstat(fqn string) (os.FileInfo, error) {
+	return ro.retryStat("lstat", os.Lstat, fqn)
+}
+
+func (ro *ReadOptions) retryStat(op string, stat func(string) (os.FileInfo, error), fqn string) (os.FileInfo, error) {
+	var fi os.FileInfo
+	err := ro.withRetry(op, fqn, func() error {
+		var err error
+		fi, err = stat(fqn)
+		return err
+	})
+	return fi, err
+}
+
+// os.Open with retries, see withRetry.
+func (ro *ReadOptions) open(fqn string) (*os.File, error) {
+	var f *os.File
+	err := ro.withRetry("open", fqn, func() error {
+		var err error
+		f, err = os.Open(fqn)
+		return err
+	})
+	return f, err
+}
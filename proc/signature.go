@@ -0,0 +1,217 @@
+package proc
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/branscha/tripline/crypto"
+	"github.com/branscha/tripline/db"
+	"io/ioutil"
+	"log"
+)
+
+const (
+	err700 = "(proc/700) write signature file %q:%w"
+	err710 = "(proc/710) read signature file %q:%w"
+	err720 = "(proc/720) signature file %q unsupported algorithm %q"
+	err730 = "(proc/730) %d filesets failed the signature check"
+	err740 = "(proc/740) %d filesets not re-signed"
+)
+
+const (
+	msg700 = "signature written to %s"
+	msg710 = "signature file %q was created for fileset %q"
+	msg720 = "fileset %q compromised:%v"
+	msg730 = "fileset %q re-signed, hash version %d to %d"
+	msg740 = "fileset %q already has hash version %d"
+	msg750 = "Integrity fileset %q is ok."
+)
+
+// The signature algorithm: the sha256 fileset hash encrypted with aes-gcm using a key derived with scrypt.
+const signatureAlgorithm = "sha256+scrypt+aes-gcm"
+
+// Contents of a detached signature file.
+// The signature bytes are self contained, they include the nonce and the salt. The salt is repeated as
+// information for the reader.
+type detachedSignature struct {
+	Fileset   string `json:"fileset"`
+	Algorithm string `json:"algorithm"`
+	Salt      string `json:"salt"`
+	Signature string `json:"signature"`
+}
+
+// Outcome of signing a fileset, meant for automation.
+type SignResult struct {
+	Fileset string `json:"fileset"`
+	// The hex encoded hash of the fileset contents that was signed.
+	Fingerprint string `json:"fingerprint"`
+	// The snapshot of the signed records, empty for detached signatures.
+	Snapshot string `json:"snapshot,omitempty"`
+	// The signature, it is not part of the output and only logged on request.
+	Signature []byte `json:"-"`
+}
+
+// Outcome of a signature verification, meant for automation.
+type SignatureVerification struct {
+	Fileset string `json:"fileset"`
+	Ok      bool   `json:"ok"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// Describe the outcome of a signature verification, the error is the reason of the failure.
+func NewSignatureVerification(fileset string, err error) *SignatureVerification {
+	result := &SignatureVerification{Fileset: fileset, Ok: err == nil}
+	if err != nil {
+		result.Reason = err.Error()
+	}
+	return result
+}
+
+// Write the signature of the fileset to a file so it can be distributed separately from the database.
+// If the detached flag is set the signature is only written to the file, otherwise the stored signature is exported.
+func ExportSignature(fileset string, password string, detached bool, out string, logger *log.Logger, tripDb *db.TriplineDb) (*SignResult, error) {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return nil, err
+	}
+
+	var signature []byte
+	if detached {
+		info, err := tripDb.CreateFilesetSignature(fileset, password)
+		if err != nil {
+			return nil, fmt.Errorf(err150, fileset, err)
+		}
+		signature = info.Signature
+	} else {
+		stored, err := tripDb.FilesetSignature(fileset)
+		if err != nil {
+			return nil, fmt.Errorf(err150, fileset, err)
+		}
+		signature = stored
+	}
+	fingerprint, err := filesetFingerprint(fileset, tripDb)
+	if err != nil {
+		return nil, err
+	}
+
+	sig := &detachedSignature{
+		Fileset:   fileset,
+		Algorithm: signatureAlgorithm,
+		Salt:      hex.EncodeToString(signature[len(signature)-crypto.SaltSize:]),
+		Signature: hex.EncodeToString(signature),
+	}
+	jsn, err := json.MarshalIndent(sig, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf(err700, out, err)
+	}
+	err = ioutil.WriteFile(out, append(jsn, '\n'), 0600)
+	if err != nil {
+		return nil, fmt.Errorf(err700, out, err)
+	}
+	loggerOrStd(logger).Printf(msg700, out)
+	return &SignResult{Fileset: fileset, Fingerprint: fingerprint, Signature: signature}, nil
+}
+
+// Verify the fileset against a detached signature file.
+func VerifySetDetachedSignature(fileset string, password string, sigFile string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	signature, err := readDetachedSignature(fileset, sigFile, logger)
+	if err != nil {
+		return err
+	}
+	err = tripDb.VerifyFilesetSignatureWith(fileset, password, signature)
+	if err != nil {
+		return fmt.Errorf(err140, fileset, err)
+	}
+	return nil
+}
+
+// Read the signature bytes from a detached signature file.
+func readDetachedSignature(fileset string, sigFile string, logger *log.Logger) ([]byte, error) {
+	jsn, err := ioutil.ReadFile(sigFile)
+	if err != nil {
+		return nil, fmt.Errorf(err710, sigFile, err)
+	}
+	sig := &detachedSignature{}
+	err = json.Unmarshal(jsn, sig)
+	if err != nil {
+		return nil, fmt.Errorf(err710, sigFile, err)
+	}
+	if sig.Algorithm != signatureAlgorithm {
+		return nil, fmt.Errorf(err720, sigFile, sig.Algorithm)
+	}
+	// The signature can be verified against a fileset with another name, e.g. an imported copy,
+	// so a name mismatch is only reported.
+	if sig.Fileset != fileset {
+		loggerOrStd(logger).Printf(msg710, sigFile, sig.Fileset)
+	}
+	signature, err := hex.DecodeString(sig.Signature)
+	if err != nil {
+		return nil, fmt.Errorf(err710, sigFile, err)
+	}
+	return signature, nil
+}
+
+// Verify all the signed filesets against their signatures, e.g. before trusting the database.
+// The compromised filesets are reported, an error is returned if there is at least one.
+func CheckSignatures(password string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	filesets, err := tripDb.ListSignedFilesets()
+	if err != nil {
+		return err
+	}
+	failed := 0
+	for _, fileset := range filesets {
+		err := tripDb.VerifyFilesetSignature(fileset, password)
+		if err != nil {
+			logger.Printf(msg720, fileset, err)
+			failed++
+		} else {
+			logger.Printf(msg750, fileset)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf(err730, failed)
+	}
+	return nil
+}
+
+// Replace the signatures of the filesets by signatures with the current hash version, all the signed filesets if
+// there are none. Each signature is verified with the hash version it was created with before it is replaced, the
+// filesets that fail the verification keep their signature and are reported. The other filesets are re-signed
+// regardless, the caller can commit them.
+func Resign(filesets []string, password string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	for _, fileset := range filesets {
+		if err := checkFileset(fileset, tripDb); err != nil {
+			return err
+		}
+	}
+	if len(filesets) == 0 {
+		signed, err := tripDb.ListSignedFilesets()
+		if err != nil {
+			return err
+		}
+		filesets = signed
+	}
+	failed := 0
+	for _, fileset := range filesets {
+		version, err := tripDb.ResignFileset(fileset, password)
+		if err != nil {
+			logger.Println(err)
+			failed++
+			continue
+		}
+		if version == db.CurrentHashVersion {
+			logger.Printf(msg740, fileset, version)
+		} else {
+			logger.Printf(msg730, fileset, version, db.CurrentHashVersion)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf(err740, failed)
+	}
+	return nil
+}
@@ -8,14 +8,14 @@ import (
 
 type fileSizeChecker struct {}
 
-func (d fileSizeChecker) prepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+func (d fileSizeChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
 	// Get the file size.
 	fileSize := fi.Size()
 	// Convert it to a string to preserve int64 precision.
 	return strconv.FormatInt(fileSize, 10), nil
 }
 
-func (d fileSizeChecker) executeCheck(fqn string, data interface{}, fi os.FileInfo) error {
+func (d fileSizeChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
 	// Get the actual file size.
 	actualSize := fi.Size()
 	// Get the recorded size from a string.
@@ -0,0 +1,165 @@
+package proc
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	err320 = "(proc/320) invalid snapshot %q, expected TYPE:PATH"
+	err330 = "(proc/330) unknown snapshot type %q"
+	err340 = "(proc/340) create %s snapshot of %q:%w"
+	err350 = "(proc/350) remove %s snapshot %q:%w"
+	err360 = "(proc/360) %s %s:%s"
+	err370 = "(proc/370) %q is not the mountpoint of a zfs dataset"
+)
+
+const (
+	msg320 = "verifying %s snapshot %q"
+)
+
+// Prefix of the names of the snapshots, so leftovers of a crashed run can be recognized.
+const snapshotPrefix = "tripline-"
+
+// A snapshot mechanism of a filesystem type.
+// Create makes a read only snapshot of the source directory and returns the directory where the snapshot can be
+// read. Remove deletes the snapshot again, it receives the source and the directory that were used to create it.
+type Snapshotter interface {
+	Create(source string) (string, error)
+	Remove(source string, mount string) error
+}
+
+var snapshotters = map[string]Snapshotter{
+	"btrfs": btrfsSnapshotter{},
+	"zfs":   zfsSnapshotter{},
+}
+
+// Register the snapshot mechanism of another filesystem type, e.g. LVM.
+// The registration is not synchronized, register the snapshotters before calling the other functions of the package.
+func RegisterSnapshotter(fsType string, snapshotter Snapshotter) error {
+	if len(fsType) == 0 || snapshotter == nil {
+		return fmt.Errorf(err330, fsType)
+	}
+	snapshotters[fsType] = snapshotter
+	return nil
+}
+
+// A temporary snapshot of a directory, the recorded paths below the source are verified against the snapshot.
+type Snapshot struct {
+	Type   string
+	Source string
+	Mount  string
+}
+
+// Create a temporary snapshot of the form "TYPE:PATH", e.g. "btrfs:/srv". The path is the subvolume or the mountpoint
+// of the dataset. The caller is responsible for removing the snapshot.
+func CreateSnapshot(spec string, logger *log.Logger) (*Snapshot, error) {
+	parts := strings.SplitN(spec, ":", 2)
+	if len(parts) != 2 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf(err320, spec)
+	}
+	snapshotter, found := snapshotters[parts[0]]
+	if !found {
+		return nil, fmt.Errorf(err330, parts[0])
+	}
+	source, err := filepath.Abs(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf(err340, parts[0], parts[1], err)
+	}
+	mount, err := snapshotter.Create(source)
+	if err != nil {
+		return nil, fmt.Errorf(err340, parts[0], source, err)
+	}
+	loggerOrStd(logger).Printf(msg320, parts[0], mount)
+	return &Snapshot{Type: parts[0], Source: source, Mount: mount}, nil
+}
+
+// Remove the snapshot.
+func (s *Snapshot) Remove() error {
+	err := snapshotters[s.Type].Remove(s.Source, s.Mount)
+	if err != nil {
+		return fmt.Errorf(err350, s.Type, s.Mount, err)
+	}
+	return nil
+}
+
+// The translation of the recorded paths to the snapshot.
+func (s *Snapshot) PathMap() PathMap {
+	return PathMap{From: s.Source, To: s.Mount, Snapshot: true}
+}
+
+// Run the command of a snapshot tool, the output is part of the error.
+func runTool(name string, args ...string) (string, error) {
+	out, err := exec.Command(name, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if len(msg) == 0 {
+			// The tool is not installed or did not explain the failure.
+			msg = err.Error()
+		}
+		return "", fmt.Errorf(err360, name, strings.Join(args, " "), msg)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// The name of a new snapshot.
+func snapshotName() string {
+	return snapshotPrefix + time.Now().Format("20060102T150405")
+}
+
+// Type btrfsSnapshotter creates a read only snapshot of a subvolume next to the subvolume.
+type btrfsSnapshotter struct{}
+
+func (b btrfsSnapshotter) Create(source string) (string, error) {
+	mount := filepath.Join(filepath.Dir(source), "."+snapshotName())
+	_, err := runTool("btrfs", "subvolume", "snapshot", "-r", source, mount)
+	return mount, err
+}
+
+func (b btrfsSnapshotter) Remove(source string, mount string) error {
+	_, err := runTool("btrfs", "subvolume", "delete", mount)
+	return err
+}
+
+// Type zfsSnapshotter snapshots the dataset that is mounted on the source, the snapshot is read from the hidden
+// .zfs/snapshot directory of the dataset.
+type zfsSnapshotter struct{}
+
+func (z zfsSnapshotter) Create(source string) (string, error) {
+	dataset, err := zfsDataset(source)
+	if err != nil {
+		return "", err
+	}
+	name := snapshotName()
+	_, err = runTool("zfs", "snapshot", dataset+"@"+name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(source, ".zfs", "snapshot", name), nil
+}
+
+func (z zfsSnapshotter) Remove(source string, mount string) error {
+	dataset, err := zfsDataset(source)
+	if err != nil {
+		return err
+	}
+	_, err = runTool("zfs", "destroy", dataset+"@"+filepath.Base(mount))
+	return err
+}
+
+// Find the dataset that is mounted on the directory.
+func zfsDataset(source string) (string, error) {
+	out, err := runTool("zfs", "list", "-H", "-o", "name,mountpoint", source)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Split(out, "\t")
+	if len(fields) != 2 || fields[1] != source {
+		return "", fmt.Errorf(err370, source)
+	}
+	return fields[0], nil
+}
@@ -0,0 +1,64 @@
+package proc
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// The special permission bits in the order they are recorded and reported.
+var specialBits = []struct {
+	name string
+	mode os.FileMode
+}{
+	{"setuid", os.ModeSetuid},
+	{"setgid", os.ModeSetgid},
+	{"sticky", os.ModeSticky},
+}
+
+// Type specialBitsChecker verifies the setuid, setgid and sticky bits only, the bits that grant privileges.
+// Changes of the rwx bits are ignored, the permissions check covers these. The set bits are recorded by name,
+// e.g. "setuid,setgid", an empty string when none is set.
+type specialBitsChecker struct{}
+
+func (s specialBitsChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return strings.Join(setSpecialBits(fi.Mode()), ","), nil
+}
+
+func (s specialBitsChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	recorded, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	expected := make(map[string]bool)
+	if len(recorded) > 0 {
+		for _, name := range strings.Split(recorded, ",") {
+			expected[name] = true
+		}
+	}
+	var changes []string
+	for _, bit := range specialBits {
+		actual := fi.Mode()&bit.mode != 0
+		switch {
+		case actual && !expected[bit.name]:
+			changes = append(changes, bit.name+" added")
+		case !actual && expected[bit.name]:
+			changes = append(changes, bit.name+" cleared")
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("%s", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// The names of the special bits that are set in the mode.
+func setSpecialBits(mode os.FileMode) []string {
+	var names []string
+	for _, bit := range specialBits {
+		if mode&bit.mode != 0 {
+			names = append(names, bit.name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,64 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSpecialBitsCheck(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "tool")
+	tool := filepath.Join(dir, "tool")
+	err := os.Chmod(tool, 0755|os.ModeSetuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(tool); err != nil || fi.Mode()&os.ModeSetuid == 0 {
+		t.Skip("the filesystem does not keep the setuid bit")
+	}
+	err = AddFiles([]string{tool}, "test", &AddOptions{FileChecks: "specialbits", DirChecks: "child"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The rwx bits are left to the permissions check.
+	err = os.Chmod(tool, 0700|os.ModeSetuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if failed := failedResults(report, "specialbits"); len(failed) != 0 {
+		t.Errorf("failed checks %v after a change of the rwx bits", failed)
+	}
+
+	err = os.Chmod(tool, 0755|os.ModeSticky)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err = VerifyFiles(nil, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, found := failedResults(report, "specialbits")[tool]
+	if !found || result.Detail != "setuid cleared, sticky added" {
+		t.Errorf("result %+v, want the cleared setuid and the added sticky bits", result)
+	}
+}
+
+func TestSpecialBitsCorrupt(t *testing.T) {
+	fi, err := os.Stat(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = specialBitsChecker{}.ExecuteCheck("dir", 42.0, fi)
+	if err == nil || err.Error() != "data corrupt" {
+		t.Errorf("check of corrupt data: got error %v, want data corrupt", err)
+	}
+}
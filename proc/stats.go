@@ -0,0 +1,124 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"os"
+)
+
+const (
+	err500 = "(proc/500) stats fileset %q:%w"
+)
+
+const (
+	msg500 = "%d entries, %d files, %d dirs"
+	msg510 = "%d of %d entries probably changed"
+	msg520 = "changed %s"
+	msg530 = "%d records, %d compressed, raw %d bytes (avg %d), stored %d bytes (avg %d), saved %d bytes"
+	msg540 = "with the current threshold stored %d bytes, saved %d bytes"
+)
+
+// The cheap metadata checks used by the quick scan. The content is never read.
+var quickChecks = []string{"size", "modtime"}
+
+// Print statistics about a fileset.
+// When the changed flag is set, a quick scan compares the size and modification times with the filesystem and
+// reports the number of entries that probably changed. It is a fast approximation of a full verification, the
+// first top changed paths are listed as well. The scan stops at the next entry when the interrupt is set.
+func Stats(fileset string, changed bool, top int, interrupt *Interrupt, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return fmt.Errorf(err500, fileset, err)
+	}
+
+	dirs := 0
+	for _, entry := range entries {
+		if entry.Record.IsDir {
+			dirs++
+		}
+	}
+	logger.Printf(msg500, len(entries), len(entries)-dirs, dirs)
+
+	if !changed {
+		return nil
+	}
+
+	changedPaths := make([]string, 0)
+	for _, entry := range entries {
+		if err := interrupt.check(); err != nil {
+			return err
+		}
+		probablyChanged, err := quickScan(entry)
+		if err != nil {
+			return fmt.Errorf(err500, fileset, err)
+		}
+		if probablyChanged {
+			changedPaths = append(changedPaths, entry.Path)
+		}
+	}
+	logger.Printf(msg510, len(changedPaths), len(entries))
+	for i, p := range changedPaths {
+		if i >= top {
+			break
+		}
+		logger.Printf(msg520, p)
+	}
+	return nil
+}
+
+// Check if an entry probably changed using only the metadata checks that were recorded for it.
+func quickScan(entry db.TriplineEntry) (bool, error) {
+	path, err := expandHome(entry.Path)
+	if err != nil {
+		return false, err
+	}
+	stat := os.Stat
+	if recordedAsLink(&entry.Record) {
+		stat = os.Lstat
+	}
+	fi, err := stat(path)
+	if err != nil || fi.IsDir() != entry.Record.IsDir {
+		// Removed or mutated from file to dir or vice versa.
+		return true, nil
+	}
+	for _, checkName := range entry.Record.Checks {
+		for _, quickCheck := range quickChecks {
+			if checkName != quickCheck {
+				continue
+			}
+			if fileChecks[checkName].ExecuteCheck(path, entry.Record.Data[checkName], fi) != nil {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// Print the storage usage of a fileset: the size of the json records, the stored size and what the compression
+// saves. The stored size with the current compression threshold is printed as well, to tune the threshold.
+func Storage(fileset string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	logger = loggerOrStd(logger)
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	stats, err := tripDb.FilesetStorage(fileset)
+	if err != nil {
+		return fmt.Errorf(err500, fileset, err)
+	}
+	avgRaw, avgStored := int64(0), int64(0)
+	if stats.Records > 0 {
+		avgRaw = stats.RawBytes / int64(stats.Records)
+		avgStored = stats.StoredBytes / int64(stats.Records)
+	}
+	logger.Printf(msg530, stats.Records, stats.Compressed, stats.RawBytes, avgRaw, stats.StoredBytes, avgStored,
+		stats.RawBytes-stats.StoredBytes)
+	logger.Printf(msg540, stats.ThresholdBytes, stats.RawBytes-stats.ThresholdBytes)
+	return nil
+}
@@ -0,0 +1,72 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"os"
+)
+
+// The name of the symlink check. Records with this check are stat'ed without following the symbolic links.
+const symlinkCheck = "symlink"
+
+// Type symlinkChecker verifies the target of a symbolic link. A link that is repointed is reported, as well as a link
+// that was replaced by a regular file and a file that was replaced by a link. The recorded target is empty for
+// paths that are not symbolic links.
+type symlinkChecker struct{}
+
+func (s symlinkChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return linkTarget(fqn, fi)
+}
+
+func (s symlinkChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	expectedTarget, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	actualTarget, err := linkTarget(fqn, fi)
+	if err != nil {
+		return err
+	}
+	switch {
+	case expectedTarget == actualTarget:
+		return nil
+	case len(expectedTarget) == 0:
+		return fmt.Errorf("expected no symlink actual symlink to %s", actualTarget)
+	case len(actualTarget) == 0:
+		return fmt.Errorf("expected symlink to %s actual %s", expectedTarget, fileType(fi.Mode()))
+	}
+	return fmt.Errorf("expected target %s actual %s", expectedTarget, actualTarget)
+}
+
+// The target of the symbolic link as it is stored in the link, it is not resolved.
+// Empty if the file info does not describe a symbolic link.
+func linkTarget(fqn string, fi os.FileInfo) (string, error) {
+	if fi.Mode()&os.ModeSymlink == 0 {
+		return "", nil
+	}
+	return os.Readlink(fqn)
+}
+
+// The stat of the add. The symbolic links are recorded as links, they are only followed with
+// AddOptions.FollowSymlinks and never when the symlink check is selected, it records the links themselves.
+func addStat(follow bool, filechecks []string, dirchecks []string) func(string) (os.FileInfo, error) {
+	if follow && !hasSymlinkCheck(filechecks) && !hasSymlinkCheck(dirchecks) {
+		return os.Stat
+	}
+	return os.Lstat
+}
+
+// The record of a symbolic link or a record with the symlink check, the path is stat'ed without following the link.
+func recordedAsLink(rec *db.TriplineRecord) bool {
+	return rec.Type == fileType(os.ModeSymlink) || hasSymlinkCheck(rec.Checks)
+}
+
+// Symbolic links are recorded as links when one of the checks is the symlink check.
+func hasSymlinkCheck(checks []string) bool {
+	for _, checkName := range checks {
+		if checkName == symlinkCheck {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db"
+	"github.com/branscha/tripline/db/dbtest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// A tree with a link to a directory and a link to its own root:
+// real/a, link -> real, loop -> ".".
+func writeLinkTree(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "real/a")
+	for link, target := range map[string]string{"link": "real", "loop": "."} {
+		err := os.Symlink(target, filepath.Join(dir, link))
+		if err != nil {
+			t.Skipf("symbolic links not supported: %v", err)
+		}
+	}
+	return dir
+}
+
+func recordType(t *testing.T, tripDb *db.TriplineDb, fqn string) string {
+	t.Helper()
+	rec, err := tripDb.GetTriplineRecord(fqn, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rec.Type
+}
+
+func TestAddFilesRecordsLinks(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := writeLinkTree(t)
+
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size", DirChecks: "modtime"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The links are recorded as links, they are not descended into.
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{".", "link", "loop", "real", "real/a"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+	for _, link := range []string{"link", "loop"} {
+		if typ := recordType(t, tripDb, filepath.Join(dir, link)); typ != "symlink" {
+			t.Errorf("%s recorded as %q, want symlink", link, typ)
+		}
+	}
+
+	report, err := VerifyFiles([]string{dir}, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failures() != 0 {
+		t.Errorf("verify of the unchanged tree has %d failures", report.Failures())
+	}
+}
+
+func TestAddFilesFollowSymlinksBreaksCycles(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	logger, out := newTestLogger()
+	dir := writeLinkTree(t)
+
+	opts := &AddOptions{Recursive: true, FollowSymlinks: true, FileChecks: "size", DirChecks: "modtime"}
+	opts.Log = logger
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The linked directory is descended into through the first path that reaches it, the loop back to the root and
+	// the second path to the linked directory are recorded without descending.
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{".", "link", "link/a", "loop", "real"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+	for _, link := range []string{"link", "loop"} {
+		if typ := recordType(t, tripDb, filepath.Join(dir, link)); typ != "dir" {
+			t.Errorf("%s recorded as %q, want dir", link, typ)
+		}
+	}
+	if n := strings.Count(out.String(), "not descending into"); n != 2 {
+		t.Errorf("logged %d cycles, want 2:\n%s", n, out.String())
+	}
+}
+
+func TestAddFilesFollowSymlinksWithSymlinkCheck(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := writeLinkTree(t)
+
+	// The symlink check needs the links themselves, they are not followed.
+	opts := &AddOptions{Recursive: true, FollowSymlinks: true, FileChecks: "size,symlink", DirChecks: "modtime"}
+	err := AddFiles([]string{dir}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{".", "link", "loop", "real", "real/a"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+}
+
+func TestDeleteFilesResolvedLink(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := writeLinkTree(t)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &AddOptions{Recursive: true, Resolve: true, FileChecks: "size", DirChecks: "modtime"}
+	err = AddFiles([]string{filepath.Join(dir, "real")}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The file name is resolved like the recorded paths, the link selects the record of its target.
+	err = DeleteFiles([]string{filepath.Join(dir, "link", "a")}, "test", true, nil, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := recordedPaths(t, tripDb, "test", dir)
+	want := []string{"real"}
+	if !equalPaths(got, want) {
+		t.Errorf("recorded %v, want %v", got, want)
+	}
+}
+
+func TestUpdateFilesResolvedLink(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := writeLinkTree(t)
+	dir, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := &AddOptions{Recursive: true, Resolve: true, FileChecks: "size", DirChecks: "modtime"}
+	err = AddFiles([]string{filepath.Join(dir, "real")}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The link selects the record of its target.
+	writeTestFile(t, dir, "real/a", "changed")
+	err = UpdateFiles([]string{filepath.Join(dir, "link", "a")}, "test", &ReadOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fails := report.Failures(); fails != 0 {
+		t.Errorf("verify after the update: %d failed checks", fails)
+	}
+}
@@ -0,0 +1,64 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+)
+
+const (
+	err480 = "(proc/480) tree root %q:%w"
+)
+
+const (
+	msg490 = "tree root %s (%s)"
+	msg495 = "tree root of fileset %q %s"
+)
+
+// Sign the current tree root of the fileset, e.g. right after an add so the fileset always has a signed summary.
+func SignTreeRoot(fileset string, password string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	err := tripDb.SignTreeRoot(fileset, password)
+	if err != nil {
+		return fmt.Errorf(err480, fileset, err)
+	}
+	loggerOrStd(logger).Printf(msg495, fileset, "signed")
+	return nil
+}
+
+// Check that the signature of the tree root covers the current records of the fileset.
+func VerifyTreeRoot(fileset string, password string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	err := tripDb.VerifyTreeRoot(fileset, password)
+	if err != nil {
+		return fmt.Errorf(err480, fileset, err)
+	}
+	loggerOrStd(logger).Printf(msg495, fileset, "verified")
+	return nil
+}
+
+// Print the tree root of the fileset and the state of its signature, nothing if the fileset does not maintain one.
+// The state is taken from the fileset meta, only VerifyTreeRoot checks the signature itself.
+func logTreeRoot(fileset string, logger *log.Logger, tripDb *db.TriplineDb) error {
+	info, err := tripDb.TreeRoot(fileset)
+	if err != nil {
+		return fmt.Errorf(err480, fileset, err)
+	}
+	if info == nil {
+		return nil
+	}
+	state := "unsigned"
+	if info.SignedRoot == info.Root {
+		state = "signed"
+	} else if len(info.SignedRoot) > 0 {
+		state = "changed since signed"
+	}
+	loggerOrStd(logger).Printf(msg490, info.Root, state)
+	return nil
+}
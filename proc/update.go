@@ -0,0 +1,87 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+)
+
+const (
+	err510 = "(proc/510) update %q:%w"
+	err520 = "(proc/520) no record of %q in fileset %q"
+	err530 = "(proc/530) cannot update %q"
+)
+
+const (
+	msg550 = "%d records updated"
+)
+
+// Refresh the recorded data of files that changed legitimately, e.g. after a package upgrade. The file names select
+// the records using their path as a prefix like the verification, each file name has to match at least one record.
+// The checks of the records are prepared again with their add options, see recordOptions, and replace the recorded
+// data, the list of checks is preserved.
+// Pending checks stay pending. Nothing is updated if one of the files cannot be read.
+func UpdateFiles(fileNames []string, fileset string, ro *ReadOptions, tripDb *db.TriplineDb) error {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+
+	// The file names are resolved in the same way as the recorded paths.
+	meta, err := tripDb.GetFilesetMeta(fileset)
+	if err != nil {
+		return fmt.Errorf(err510, fileset, err)
+	}
+
+	updated := 0
+	for _, fn := range fileNames {
+		fqn, err := absPath(fn, meta.Resolve)
+		if err != nil {
+			return fmt.Errorf(err040, fn, err)
+		}
+		entries, err := queryRecords(fileset, fqn, tripDb)
+		if err != nil {
+			return fmt.Errorf(err510, fqn, err)
+		}
+		if len(entries) == 0 {
+			return fmt.Errorf(err520, fqn, fileset)
+		}
+
+		for _, entry := range entries {
+			if err := ro.interrupted(); err != nil {
+				return err
+			}
+			rec := entry.Record
+			validChecks := fileChecks
+			if rec.IsDir {
+				validChecks = dirChecks
+			}
+			// The checks that are not available on this platform keep their recorded data.
+			var checkNames []string
+			for _, checkName := range withoutPending(&rec) {
+				if _, valid := validChecks[checkName]; valid {
+					checkNames = append(checkNames, checkName)
+				}
+			}
+			if !prepareRecordChecks(entry.Path, &rec, checkNames, validChecks, ro) {
+				return fmt.Errorf(err530, entry.Path)
+			}
+			if _, found := rec.Data[inlineData]; found {
+				path, err := expandHome(entry.Path)
+				if err != nil {
+					return fmt.Errorf(err510, entry.Path, err)
+				}
+				content, err := inlineContent(path)
+				if err != nil {
+					return fmt.Errorf(err510, entry.Path, err)
+				}
+				rec.Data[inlineData] = content
+			}
+			err := tripDb.AddTriplineRecord(entry.Path, &rec, fileset, true)
+			if err != nil {
+				return fmt.Errorf(err510, entry.Path, err)
+			}
+			updated++
+		}
+	}
+	ro.logger().Printf(msg550, updated)
+	return touchFileset(fileset, tripDb)
+}
@@ -0,0 +1,83 @@
+package proc
+
+import (
+	"github.com/branscha/tripline/db/dbtest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUpdateFiles(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	logger, out := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "a/x", "y")
+	err := AddFiles([]string{dir}, "test", &AddOptions{Recursive: true, FileChecks: "size,sha256", DirChecks: "child"}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The changed file is updated, the verification passes again.
+	writeTestFile(t, dir, "a/x", "changed")
+	ro := &ReadOptions{Log: logger}
+	err = UpdateFiles([]string{filepath.Join(dir, "a")}, "test", ro, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged := out.String(); logged != "2 records updated\n" {
+		t.Errorf("update logged %q", logged)
+	}
+	report, err := VerifyFiles(nil, "test", &VerifyOptions{ReadOptions: *ro}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fails := report.Failures(); fails != 0 {
+		t.Errorf("verify after the update: %d failed checks", fails)
+	}
+	rec, err := tripDb.GetTriplineRecord(filepath.Join(dir, "a", "x"), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(rec.Checks, ",") != "size,sha256" {
+		t.Errorf("updated checks %v, want the recorded checks", rec.Checks)
+	}
+
+	// Each file name has to match a record.
+	missing := filepath.Join(dir, "missing")
+	err = UpdateFiles([]string{missing}, "test", ro, tripDb)
+	if err == nil || !strings.HasPrefix(err.Error(), "(proc/520)") {
+		t.Errorf("update of a path without records: got error %v, want the no record error", err)
+	}
+}
+
+func TestUpdateFilesKeepsAddOptions(t *testing.T) {
+	tripDb := dbtest.Open(t, true)
+	captureLog(t)
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	fqn := filepath.Join(dir, "x")
+	opts := &AddOptions{FileChecks: "size,modtime,ownership", DirChecks: "modtime", SelfOwner: true, ModTimePrecision: "second"}
+	err := AddFiles([]string{fqn}, "test", opts, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The record is prepared again with the options it was added with.
+	writeTestFile(t, dir, "x", "changed")
+	err = UpdateFiles([]string{fqn}, "test", &ReadOptions{}, tripDb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := tripDb.GetTriplineRecord(fqn, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, precision := modTimeRepr(rec.Data["modtime"]); precision != "second" {
+		t.Errorf("updated modtime precision %q, want second", precision)
+	}
+	owner, _ := rec.Data["ownership"].(map[string]interface{})
+	if owner["User"] != selfOwner || owner["Group"] != selfOwner {
+		t.Errorf("updated ownership %v, want the self owner", owner)
+	}
+}
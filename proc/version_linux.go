@@ -0,0 +1,65 @@
+// +build linux,amd64 linux,arm64 linux,386 linux,arm
+
+package proc
+
+import (
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"strconv"
+	"unsafe"
+)
+
+// FS_IOC_GETVERSION, _IOR('v', 1, long) in the generic ioctl encoding of these architectures.
+var fsIocGetVersion = uint(0x80007601) | uint(unsafe.Sizeof(uintptr(0)))<<16
+
+// Recorded value for files that are not regular files, the others cannot be opened safely.
+const noVersion = "none"
+
+func init() {
+	fileChecks["version"] = versionChecker{}
+}
+
+// Type versionChecker verifies the object version of a file, the inode generation on ext2/3/4 and btrfs.
+// The version is assigned by the filesystem when the inode is created and cannot be set from user space like the
+// modification time. A file that is replaced gets a new version, even if the inode number is reused.
+type versionChecker struct{}
+
+func (d versionChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return objectVersion(fqn, fi)
+}
+
+func (d versionChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	expectedVersion, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	actualVersion, err := objectVersion(fqn, fi)
+	if err != nil {
+		return err
+	}
+	if expectedVersion != actualVersion {
+		return &mismatchError{expectedVersion, actualVersion}
+	}
+	return nil
+}
+
+// Read the object version of a regular file with the FS_IOC_GETVERSION ioctl.
+func objectVersion(fqn string, fi os.FileInfo) (string, error) {
+	if !fi.Mode().IsRegular() {
+		return noVersion, nil
+	}
+	fd, err := unix.Open(fqn, unix.O_RDONLY|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return "", fmt.Errorf("open file")
+	}
+	defer unix.Close(fd)
+	version, err := unix.IoctlGetUint32(fd, fsIocGetVersion)
+	if err == unix.ENOTTY || err == unix.EOPNOTSUPP || err == unix.EINVAL {
+		return "", fmt.Errorf("object version unsupported by the filesystem")
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(uint64(version), 10), nil
+}
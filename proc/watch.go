@@ -0,0 +1,233 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"log"
+	"sort"
+	"time"
+)
+
+const (
+	err440 = "(proc/440) watch %q:%w"
+	err450 = "(proc/450) watch %q: no filesystem events on this platform, set a check interval"
+)
+
+const (
+	msg440 = "%s verification of %q at %s, %d failed checks"
+	msg450 = "watching %d paths of %q, full verification every %s"
+	msg455 = "watching %d paths of %q"
+	msg460 = "cannot watch the filesystem events of %q, only the periodic verification runs:%v"
+)
+
+// Origins of the verifications of the watch.
+const (
+	// Triggered by the filesystem events of the recorded paths.
+	WatchEvent = "event"
+	// The periodic verification of the complete fileset.
+	WatchScan = "scan"
+)
+
+// Time to collect the events of a burst of changes before verifying them.
+const watchSettle = time.Second
+
+// Options of the watch of a fileset.
+type WatchOptions struct {
+	// Interval of the full verification that catches the changes the events missed, 0 to only verify on events.
+	CheckInterval time.Duration
+	// Options of the verifications, the messages of the watch go to their logger.
+	Verify *VerifyOptions
+}
+
+// Type fileWatcher delivers the filesystem paths that changed.
+type fileWatcher interface {
+	Changes() <-chan string
+	Close() error
+}
+
+// Start watching the filesystem paths, set by the platforms that have filesystem events.
+var newFileWatcher func(paths []string) (fileWatcher, error)
+
+// Watch the fileset until interrupted. The recorded paths that change are verified as the filesystem events arrive,
+// the complete fileset is verified when the watch starts and every check interval as a safety net for the events
+// that were missed under load or while the watch was not running. The results are reported with their origin.
+// Each verification runs in its own read transaction so the event and the periodic verifications do not overlap.
+// The database is released between the verifications, other processes can use it meanwhile, e.g. to update the
+// baseline. The records are read again for each verification, the paths that are added to the fileset during the
+// watch are only verified by the periodic verification. The database is open again when the watch ends.
+func WatchSet(fileset string, opts *WatchOptions, tripDb *db.TriplineDb) (err error) {
+	if err := checkFileset(fileset, tripDb); err != nil {
+		return err
+	}
+	defer func() {
+		if reacquireErr := tripDb.Reacquire(); err == nil && reacquireErr != nil {
+			err = fmt.Errorf(err440, fileset, reacquireErr)
+		}
+	}()
+
+	// The filesystem paths mapped to the records.
+	var watched map[string]db.TriplineEntry
+	err = watchTx(tripDb, func() error {
+		var err error
+		watched, err = watchedPaths(fileset, opts.Verify, tripDb)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf(err440, fileset, err)
+	}
+
+	var changes <-chan string
+	if newFileWatcher != nil {
+		paths := make([]string, 0, len(watched))
+		for path := range watched {
+			paths = append(paths, path)
+		}
+		sort.Strings(paths)
+		watcher, err := newFileWatcher(paths)
+		if err != nil {
+			opts.Verify.logger().Printf(msg460, fileset, err)
+		} else {
+			defer watcher.Close()
+			changes = watcher.Changes()
+		}
+	}
+	if changes == nil && opts.CheckInterval <= 0 {
+		return fmt.Errorf(err450, fileset)
+	}
+	if opts.CheckInterval > 0 {
+		opts.Verify.logger().Printf(msg450, len(watched), fileset, opts.CheckInterval)
+	} else {
+		opts.Verify.logger().Printf(msg455, len(watched), fileset)
+	}
+
+	// The changes made while the watch was not running.
+	err = watchScan(fileset, opts.Verify, tripDb)
+	if err != nil {
+		return err
+	}
+
+	var scans <-chan time.Time
+	if opts.CheckInterval > 0 {
+		ticker := time.NewTicker(opts.CheckInterval)
+		defer ticker.Stop()
+		scans = ticker.C
+	}
+	// The interrupts are polled, they are not delivered on a channel.
+	poll := time.NewTicker(200 * time.Millisecond)
+	defer poll.Stop()
+	settle := time.NewTimer(watchSettle)
+	settle.Stop()
+
+	pending := make(map[string]bool)
+	for {
+		select {
+		case path, ok := <-changes:
+			if !ok {
+				return fmt.Errorf(err440, fileset, fmt.Errorf("filesystem events stopped"))
+			}
+			if _, isRecorded := watched[path]; !isRecorded {
+				continue
+			}
+			if len(pending) == 0 {
+				settle.Reset(watchSettle)
+			}
+			pending[path] = true
+		case <-settle.C:
+			entries := make([]db.TriplineEntry, 0, len(pending))
+			for path := range pending {
+				entries = append(entries, watched[path])
+			}
+			sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+			pending = make(map[string]bool)
+			err := watchEvent(fileset, entries, opts.Verify, tripDb)
+			if err != nil {
+				return err
+			}
+		case <-scans:
+			err := watchScan(fileset, opts.Verify, tripDb)
+			if err != nil {
+				return err
+			}
+		case <-poll.C:
+			if err := opts.Verify.interrupted(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// Verify the complete fileset.
+func watchScan(fileset string, opts *VerifyOptions, tripDb *db.TriplineDb) error {
+	return watchTx(tripDb, func() error {
+		report, err := VerifyFiles(nil, fileset, opts, tripDb)
+		if err != nil {
+			return err
+		}
+		return logWatchReport(WatchScan, fileset, report, opts.logger())
+	})
+}
+
+// Verify the records of the paths that changed, only the records themselves, not the records below a directory.
+// The current records are verified, a record that was deleted since the watch started is skipped.
+func watchEvent(fileset string, entries []db.TriplineEntry, opts *VerifyOptions, tripDb *db.TriplineDb) error {
+	return watchTx(tripDb, func() error {
+		current := make([]db.TriplineEntry, 0, len(entries))
+		for _, entry := range entries {
+			rec, err := tripDb.GetTriplineRecord(entry.Path, fileset)
+			if err != nil {
+				return err
+			}
+			if rec != nil {
+				current = append(current, db.TriplineEntry{Record: *rec, Path: entry.Path})
+			}
+		}
+		v := &verifier{fileset: fileset, opts: opts, report: &VerifyReport{}, tripDb: tripDb}
+		err := v.verifyEntries("", current)
+		if err != nil {
+			return err
+		}
+		return logWatchReport(WatchEvent, fileset, v.report, opts.logger())
+	})
+}
+
+func logWatchReport(origin string, fileset string, report *VerifyReport, logger *log.Logger) error {
+	logger.Printf(msg440, origin, fileset, time.Now().Format(time.RFC3339), report.Failures())
+	return report.WriteText(logger.Writer())
+}
+
+// Run the function in a read transaction, the database is only held during the transaction.
+func watchTx(tripDb *db.TriplineDb, fn func() error) error {
+	err := tripDb.Reacquire()
+	if err != nil {
+		return err
+	}
+	err = tripDb.Begin(false)
+	if err != nil {
+		return err
+	}
+	err = fn()
+	if rollbackErr := tripDb.Rollback(); err == nil {
+		err = rollbackErr
+	}
+	if releaseErr := tripDb.Release(); err == nil {
+		err = releaseErr
+	}
+	return err
+}
+
+// Map the filesystem paths to the records when the watch starts, the changes of the other paths are ignored.
+func watchedPaths(fileset string, opts *VerifyOptions, tripDb *db.TriplineDb) (map[string]db.TriplineEntry, error) {
+	entries, err := tripDb.ListTriplineRecords(fileset)
+	if err != nil {
+		return nil, err
+	}
+	watched := make(map[string]db.TriplineEntry)
+	for _, entry := range entries {
+		path, err := expandHome(entry.Path)
+		if err != nil {
+			return nil, err
+		}
+		watched[translatePath(path, opts.PathMaps)] = entry
+	}
+	return watched, nil
+}
@@ -0,0 +1,82 @@
+package proc
+
+import (
+	"bytes"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"unsafe"
+)
+
+// The events that change the recorded metadata or contents of a file, or the entries of a directory.
+const inotifyMask = unix.IN_ATTRIB | unix.IN_CLOSE_WRITE | unix.IN_MODIFY | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_DELETE_SELF | unix.IN_MOVE_SELF | unix.IN_MOVED_FROM | unix.IN_MOVED_TO
+
+func init() {
+	newFileWatcher = newInotifyWatcher
+}
+
+// Type inotifyWatcher reports the paths that changed using inotify. Every recorded path is watched, the children
+// of a watched directory are reported as well so a replaced file is noticed.
+type inotifyWatcher struct {
+	file    *os.File
+	paths   map[int]string
+	changes chan string
+}
+
+func newInotifyWatcher(paths []string) (fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	// The non blocking descriptor uses the runtime poller, closing the file ends a pending read.
+	w := &inotifyWatcher{file: os.NewFile(uintptr(fd), "inotify"), paths: make(map[int]string), changes: make(chan string)}
+	for _, path := range paths {
+		wd, err := unix.InotifyAddWatch(fd, path, inotifyMask)
+		if err == unix.ENOENT {
+			// Missing paths are reported by the periodic verification.
+			continue
+		}
+		if err != nil {
+			w.file.Close()
+			return nil, &os.PathError{Op: "watch", Path: path, Err: err}
+		}
+		w.paths[wd] = path
+	}
+	go w.read()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan string {
+	return w.changes
+}
+
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// Decode the events until the file is closed.
+func (w *inotifyWatcher) read() {
+	defer close(w.changes)
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			path, ok := w.paths[int(event.Wd)]
+			if !ok {
+				continue
+			}
+			w.changes <- path
+			if event.Len > 0 {
+				name := string(bytes.TrimRight(buf[start:offset], "\x00"))
+				w.changes <- filepath.Join(path, name)
+			}
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package proc
+
+import (
+	"errors"
+	"github.com/branscha/tripline/db"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchSetReleasesDatabase(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "tripline.db")
+	tripDb, err := db.OpenTriplineDb(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tripDb.Close()
+	logger, _ := newTestLogger()
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "x")
+	err = tripDb.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AddFiles([]string{dir}, "test", &AddOptions{FileChecks: "size", DirChecks: "modtime", ReadOptions: ReadOptions{Log: logger}}, tripDb)
+	if err == nil {
+		err = tripDb.Commit()
+	} else {
+		_ = tripDb.Rollback()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interrupt := &Interrupt{}
+	opts := &WatchOptions{CheckInterval: time.Hour, Verify: &VerifyOptions{ReadOptions: ReadOptions{Log: logger, Interrupt: interrupt}}}
+	done := make(chan error)
+	go func() {
+		done <- WatchSet("test", opts, tripDb)
+	}()
+
+	// Another process can modify the baseline between the verifications of the watch.
+	other, err := db.OpenTriplineDbWith(dbPath, &db.OpenOptions{LockWait: 5 * time.Second, Logger: logger})
+	if err != nil {
+		t.Fatalf("open the watched database: %v", err)
+	}
+	err = other.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interrupt.Set()
+	err = <-done
+	if !errors.Is(err, ErrInterrupted) {
+		t.Errorf("interrupted watch: got error %v, want ErrInterrupted", err)
+	}
+	err = tripDb.Begin(false)
+	if err != nil {
+		t.Fatalf("the database is not open after the watch: %v", err)
+	}
+	_ = tripDb.Rollback()
+}
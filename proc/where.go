@@ -0,0 +1,66 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/branscha/tripline/db"
+	"strings"
+)
+
+const (
+	err460 = "(proc/460) invalid where clause %q, expected CHECK=VALUE"
+)
+
+// A condition on the recorded data of a check, e.g. "sha256=abcd...". The nested values are selected with a dotted
+// name, e.g. "ownership.user=root".
+type WhereClause struct {
+	Check string
+	Value string
+}
+
+// Parse a where clause of the form "CHECK=VALUE".
+func ParseWhere(clause string) (WhereClause, error) {
+	parts := strings.SplitN(clause, "=", 2)
+	if len(parts) != 2 || len(strings.TrimSpace(parts[0])) == 0 {
+		return WhereClause{}, fmt.Errorf(err460, clause)
+	}
+	return WhereClause{strings.TrimSpace(parts[0]), parts[1]}, nil
+}
+
+// Check if the recorded data satisfies all the clauses. The values are compared as text ignoring the case, so the
+// hex digests match regardless of how they are written. A record without the check does not match.
+func matchesWhere(rec *db.TriplineRecord, clauses []WhereClause) bool {
+	for _, clause := range clauses {
+		names := strings.Split(clause.Check, ".")
+		var value interface{} = rec.Data
+		for _, name := range names {
+			fields, ok := value.(map[string]interface{})
+			if !ok {
+				return false
+			}
+			value = lookupField(fields, name)
+		}
+		if value == nil {
+			return false
+		}
+		if _, isMap := value.(map[string]interface{}); isMap {
+			return false
+		}
+		if !strings.EqualFold(fmt.Sprint(value), clause.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// Look up a field by name, ignoring the case. The recorded structs use capitalized names, e.g. "User".
+func lookupField(fields map[string]interface{}, name string) interface{} {
+	if value, found := fields[name]; found {
+		return value
+	}
+	for key, value := range fields {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package proc
+
+import (
+	"encoding/base64"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Type xattrChecker verifies the extended attributes of a file or directory one by one, e.g. the SELinux label
+// (security.selinux) and the capabilities (security.capability). It stores the names with their base64 encoded
+// values and reports which attributes were added, removed or modified. The xmeta check is cheaper to store.
+type xattrChecker struct{}
+
+func init() {
+	fileChecks["xattr"] = xattrChecker{}
+	dirChecks["xattr"] = xattrChecker{}
+}
+
+func (d xattrChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return readXattrs(fqn)
+}
+
+func (d xattrChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	// The json round trip turns the recorded map into a generic map.
+	recorded, ok := data.(map[string]interface{})
+	if !ok && data != nil {
+		return fmt.Errorf("data corrupt")
+	}
+	expected := make(map[string]string)
+	for name, value := range recorded {
+		encoded, ok := value.(string)
+		if !ok {
+			return fmt.Errorf("data corrupt")
+		}
+		expected[name] = encoded
+	}
+	actual, err := readXattrs(fqn)
+	if err != nil {
+		return err
+	}
+
+	var added, removed, modified []string
+	for name, value := range actual {
+		expectedValue, found := expected[name]
+		if !found {
+			added = append(added, name)
+		} else if expectedValue != value {
+			modified = append(modified, name)
+		}
+	}
+	for name := range expected {
+		if _, found := actual[name]; !found {
+			removed = append(removed, name)
+		}
+	}
+	var changes []string
+	for _, change := range []struct {
+		kind  string
+		names []string
+	}{{"added", added}, {"removed", removed}, {"modified", modified}} {
+		if len(change.names) > 0 {
+			sort.Strings(change.names)
+			changes = append(changes, change.kind+" "+strings.Join(change.names, " "))
+		}
+	}
+	if len(changes) > 0 {
+		return fmt.Errorf("extended attributes %s", strings.Join(changes, ", "))
+	}
+	return nil
+}
+
+// The extended attributes of the file by name, the values are base64 encoded.
+func readXattrs(fqn string) (map[string]string, error) {
+	names, err := listXattrs(fqn)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]string)
+	for _, name := range names {
+		value, err := getXattr(fqn, name)
+		if err != nil {
+			return nil, err
+		}
+		result[name] = base64.StdEncoding.EncodeToString(value)
+	}
+	return result, nil
+}
@@ -0,0 +1,91 @@
+package proc
+
+import (
+	"encoding/json"
+	"golang.org/x/sys/unix"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Set the extended attribute, the test is skipped if the filesystem of the temp directory has no user attributes.
+func setTestXattr(t *testing.T, fqn string, name string, value string) {
+	t.Helper()
+	err := unix.Setxattr(fqn, name, []byte(value), 0)
+	if err == unix.ENOTSUP || err == unix.EOPNOTSUPP {
+		t.Skip("no user extended attributes on the filesystem of the temp directory")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Prepare the check and pass the data through a json round trip, the way it is stored in the fileset.
+func prepareStoredCheck(t *testing.T, checker FileChecker, fqn string) (interface{}, os.FileInfo) {
+	t.Helper()
+	fi, err := os.Lstat(fqn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := checker.PrepareCheck(fqn, fi)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jsn, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stored interface{}
+	err = json.Unmarshal(jsn, &stored)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return stored, fi
+}
+
+func TestXattrChecker(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "file")
+	fqn := filepath.Join(dir, "file")
+	setTestXattr(t, fqn, "user.modified", "before")
+	setTestXattr(t, fqn, "user.removed", "value")
+	setTestXattr(t, fqn, "user.same", "value")
+
+	checker := xattrChecker{}
+	data, fi := prepareStoredCheck(t, checker, fqn)
+	err := checker.ExecuteCheck(fqn, data, fi)
+	if err != nil {
+		t.Fatalf("unchanged attributes failed: %v", err)
+	}
+
+	setTestXattr(t, fqn, "user.modified", "after")
+	setTestXattr(t, fqn, "user.added", "value")
+	err = unix.Removexattr(fqn, "user.removed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checker.ExecuteCheck(fqn, data, fi)
+	want := "extended attributes added user.added, removed user.removed, modified user.modified"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+}
+
+func TestXattrCheckerNoAttributes(t *testing.T) {
+	dir := t.TempDir()
+	writeTestFiles(t, dir, "file")
+	fqn := filepath.Join(dir, "file")
+
+	checker := xattrChecker{}
+	data, fi := prepareStoredCheck(t, checker, fqn)
+	err := checker.ExecuteCheck(fqn, data, fi)
+	if err != nil {
+		t.Fatalf("a file without attributes failed: %v", err)
+	}
+	setTestXattr(t, fqn, "user.added", "value")
+	err = checker.ExecuteCheck(fqn, data, fi)
+	want := "extended attributes added user.added"
+	if err == nil || err.Error() != want {
+		t.Fatalf("got %v, want %q", err, want)
+	}
+}
@@ -0,0 +1,97 @@
+package proc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"os"
+	"sort"
+)
+
+// Type xmetaChecker verifies a single digest over all the extended metadata of a file or directory.
+// On Linux the ACLs (system.posix_acl_*) and the capabilities (security.capability) are stored as extended
+// attributes, so one digest over the sorted names and values catches all of them. The check is cheap to store but
+// cannot tell which attribute changed.
+type xmetaChecker struct{}
+
+func init() {
+	fileChecks["xmeta"] = xmetaChecker{}
+	dirChecks["xmeta"] = xmetaChecker{}
+}
+
+func (d xmetaChecker) PrepareCheck(fqn string, fi os.FileInfo) (interface{}, error) {
+	return xmetaDigest(fqn)
+}
+
+func (d xmetaChecker) ExecuteCheck(fqn string, data interface{}, fi os.FileInfo) error {
+	expectedDigest, ok := data.(string)
+	if !ok {
+		return fmt.Errorf("data corrupt")
+	}
+	actualDigest, err := xmetaDigest(fqn)
+	if err != nil {
+		return err
+	}
+	if expectedDigest != actualDigest {
+		return fmt.Errorf("extended metadata changed")
+	}
+	return nil
+}
+
+// Compute the sha256 over the sorted attribute names, each followed by its value.
+// Names and values are length prefixed so that different attribute sets cannot produce the same stream.
+func xmetaDigest(fqn string) (string, error) {
+	names, err := listXattrs(fqn)
+	if err != nil {
+		return "", err
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	for _, name := range names {
+		value, err := getXattr(fqn, name)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%d:%s%d:", len(name), name, len(value))
+		h.Write(value)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func listXattrs(fqn string) ([]string, error) {
+	size, err := unix.Listxattr(fqn, nil)
+	if err == unix.ENOTSUP {
+		// The filesystem has no extended attributes, it is the same as an empty set.
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Listxattr(fqn, buf)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, name := range bytes.Split(buf[:size], []byte{0}) {
+		if len(name) > 0 {
+			names = append(names, string(name))
+		}
+	}
+	return names, nil
+}
+
+func getXattr(fqn string, name string) ([]byte, error) {
+	size, err := unix.Getxattr(fqn, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(fqn, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}